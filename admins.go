@@ -0,0 +1,462 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const minPasswordLength = 8
+
+var (
+	errInvalidCredentials = errors.New("invalid username or password")
+	errAdminExists        = errors.New("admin already exists")
+	errAdminNotFound      = errors.New("admin not found")
+	errLastOwner          = errors.New("cannot remove the last owner")
+)
+
+// dummyPasswordHash - хеш для сравнения, когда логин не найден, чтобы время ответа не выдавало существующие логины
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy-password"), bcrypt.DefaultCost)
+
+type AdminsResponse struct {
+	Success bool    `json:"success"`
+	Admins  []Admin `json:"admins,omitempty"`
+	Error   string  `json:"error,omitempty"`
+}
+
+type CreateAdminRequest struct {
+	Username string `json:"username"`
+	Role     Role   `json:"role"`
+}
+
+type SetAdminRoleRequest struct {
+	ID   int64 `json:"id"`
+	Role Role  `json:"role"`
+}
+
+type Admin struct {
+	ID            int64      `json:"id"`
+	Username      string     `json:"username"`
+	PasswordHash  string     `json:"-"`
+	Role          Role       `json:"role"`
+	TOTPEnabledAt *time.Time `json:"totp_enabled_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+	LastLoginAt   *time.Time `json:"last_login_at"`
+}
+
+// hashPassword - хеширует пароль bcrypt
+func hashPassword(password string) (string, error) {
+	if len(password) < minPasswordLength {
+		return "", fmt.Errorf("password must be at least %d characters", minPasswordLength)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	return string(hash), nil
+}
+
+// getAdminByUsername - ищет администратора по логину
+func getAdminByUsername(ctx context.Context, db *pgxpool.Pool, username string) (*Admin, error) {
+	query := `SELECT id, username, password_hash, role, totp_enabled_at, created_at, last_login_at
+			  FROM admin_user
+			  WHERE username = $1`
+
+	var admin Admin
+	err := db.QueryRow(ctx, query, username).Scan(
+		&admin.ID,
+		&admin.Username,
+		&admin.PasswordHash,
+		&admin.Role,
+		&admin.TOTPEnabledAt,
+		&admin.CreatedAt,
+		&admin.LastLoginAt,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errAdminNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query admin: %w", err)
+	}
+
+	return &admin, nil
+}
+
+// listAdmins - возвращает всех администраторов
+func listAdmins(ctx context.Context, db *pgxpool.Pool) ([]Admin, error) {
+	query := `SELECT id, username, role, totp_enabled_at, created_at, last_login_at
+			  FROM admin_user
+			  ORDER BY id`
+
+	rows, err := db.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query admins: %w", err)
+	}
+	defer rows.Close()
+
+	var admins []Admin
+	for rows.Next() {
+		var admin Admin
+		if err := rows.Scan(&admin.ID, &admin.Username, &admin.Role, &admin.TOTPEnabledAt, &admin.CreatedAt, &admin.LastLoginAt); err != nil {
+			return nil, fmt.Errorf("failed to scan admin: %w", err)
+		}
+		admins = append(admins, admin)
+	}
+
+	return admins, rows.Err()
+}
+
+// createAdmin - создает администратора с захешированным паролем
+func createAdmin(ctx context.Context, db *pgxpool.Pool, username, password string, role Role) (*Admin, error) {
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return nil, errors.New("username is required")
+	}
+	if !role.Valid() {
+		return nil, fmt.Errorf("unknown role %q", role)
+	}
+
+	hash, err := hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `INSERT INTO admin_user (username, password_hash, role)
+			  VALUES ($1, $2, $3)
+			  ON CONFLICT (username) DO NOTHING
+			  RETURNING id, created_at`
+
+	admin := &Admin{Username: username, PasswordHash: hash, Role: role}
+	err = db.QueryRow(ctx, query, username, hash, role).Scan(&admin.ID, &admin.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errAdminExists
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert admin: %w", err)
+	}
+
+	return admin, nil
+}
+
+// setAdminPassword - меняет пароль существующего администратора
+func setAdminPassword(ctx context.Context, db *pgxpool.Pool, username, password string) error {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	tag, err := db.Exec(ctx, `UPDATE admin_user SET password_hash = $1 WHERE username = $2`, hash, username)
+	if err != nil {
+		return fmt.Errorf("failed to update password: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return errAdminNotFound
+	}
+
+	return nil
+}
+
+// setAdminRole - меняет роль администратора, не позволяя оставить панель без владельца
+func setAdminRole(ctx context.Context, db *pgxpool.Pool, username string, role Role) error {
+	if !role.Valid() {
+		return fmt.Errorf("unknown role %q", role)
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Блокируем строки владельцев, чтобы два параллельных понижения не оставили панель без владельца
+	var owners int
+	if err := tx.QueryRow(ctx, `SELECT COUNT(*) FROM (SELECT id FROM admin_user WHERE role = 'owner' FOR UPDATE) AS o`).Scan(&owners); err != nil {
+		return fmt.Errorf("failed to count owners: %w", err)
+	}
+
+	var current Role
+	err = tx.QueryRow(ctx, `SELECT role FROM admin_user WHERE username = $1 FOR UPDATE`, username).Scan(&current)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return errAdminNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to query admin: %w", err)
+	}
+
+	if current == RoleOwner && role != RoleOwner && owners <= 1 {
+		return errLastOwner
+	}
+
+	if _, err := tx.Exec(ctx, `UPDATE admin_user SET role = $1 WHERE username = $2`, role, username); err != nil {
+		return fmt.Errorf("failed to update role: %w", err)
+	}
+
+	return tx.Commit(ctx)
+}
+
+// countAdmins - возвращает количество администраторов
+func countAdmins(ctx context.Context, db *pgxpool.Pool) (int, error) {
+	var count int
+	if err := db.QueryRow(ctx, `SELECT COUNT(*) FROM admin_user`).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count admins: %w", err)
+	}
+	return count, nil
+}
+
+// authenticateAdmin - проверяет логин и пароль администратора (первый фактор)
+func (s *Server) authenticateAdmin(ctx context.Context, username, password string) (*Admin, error) {
+	admin, err := getAdminByUsername(ctx, s.db, username)
+	if errors.Is(err, errAdminNotFound) {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		return nil, errInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(admin.PasswordHash), []byte(password)); err != nil {
+		return nil, errInvalidCredentials
+	}
+
+	return admin, nil
+}
+
+// runCommand - выполняет служебную команду из командной строки вместо запуска сервера
+func runCommand(ctx context.Context, db *pgxpool.Pool, args []string) error {
+	switch args[0] {
+	case "create-admin":
+		return runCreateAdminCommand(ctx, db, args[1:])
+	case "reset-password":
+		return runResetPasswordCommand(ctx, db, args[1:])
+	case "set-role":
+		return runSetRoleCommand(ctx, db, args[1:])
+	case "reset-2fa":
+		return runResetTwoFactorCommand(ctx, db, args[1:])
+	default:
+		return fmt.Errorf("unknown command %q (available: create-admin, reset-password, set-role, reset-2fa, fake-bot-api)", args[0])
+	}
+}
+
+// runCreateAdminCommand - создает администратора: create-admin -username <логин> [-password <пароль>] [-role <роль>]
+func runCreateAdminCommand(ctx context.Context, db *pgxpool.Pool, args []string) error {
+	fs := flag.NewFlagSet("create-admin", flag.ContinueOnError)
+	username := fs.String("username", "", "логин администратора")
+	password := fs.String("password", "", "пароль (если не указан, будет сгенерирован)")
+	roleName := fs.String("role", "", "роль: viewer, support, translator, operator, owner (первый администратор — owner)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	// Первый администратор по умолчанию владелец, иначе панелью некому будет управлять
+	if *roleName == "" {
+		count, err := countAdmins(ctx, db)
+		if err != nil {
+			return err
+		}
+		*roleName = string(RoleViewer)
+		if count == 0 {
+			*roleName = string(RoleOwner)
+		}
+	}
+
+	role, err := parseRole(*roleName)
+	if err != nil {
+		return err
+	}
+
+	generated := *password == ""
+	if generated {
+		*password = generateRandomString(16)
+	}
+
+	admin, err := createAdmin(ctx, db, *username, *password, role)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("✅ Администратор %s создан (id=%d, роль %s)\n", admin.Username, admin.ID, admin.Role)
+	if generated {
+		fmt.Printf("🔑 Пароль: %s\n", *password)
+	}
+	return nil
+}
+
+// runResetPasswordCommand - задает новый пароль: reset-password -username <логин> [-password <пароль>]
+func runResetPasswordCommand(ctx context.Context, db *pgxpool.Pool, args []string) error {
+	fs := flag.NewFlagSet("reset-password", flag.ContinueOnError)
+	username := fs.String("username", "", "логин администратора")
+	password := fs.String("password", "", "новый пароль (если не указан, будет сгенерирован)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	generated := *password == ""
+	if generated {
+		*password = generateRandomString(16)
+	}
+
+	if err := setAdminPassword(ctx, db, *username, *password); err != nil {
+		return err
+	}
+
+	fmt.Printf("✅ Пароль администратора %s обновлен\n", *username)
+	if generated {
+		fmt.Printf("🔑 Пароль: %s\n", *password)
+	}
+	return nil
+}
+
+// runSetRoleCommand - меняет роль: set-role -username <логин> -role <роль>
+func runSetRoleCommand(ctx context.Context, db *pgxpool.Pool, args []string) error {
+	fs := flag.NewFlagSet("set-role", flag.ContinueOnError)
+	username := fs.String("username", "", "логин администратора")
+	roleName := fs.String("role", "", "роль: viewer, support, translator, operator, owner")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	role, err := parseRole(*roleName)
+	if err != nil {
+		return err
+	}
+
+	if err := setAdminRole(ctx, db, *username, role); err != nil {
+		return err
+	}
+
+	fmt.Printf("✅ Администратору %s назначена роль %s\n", *username, role)
+	return nil
+}
+
+// adminsHandler - список администраторов
+func (s *Server) adminsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	admins, err := listAdmins(r.Context(), s.db)
+
+	response := AdminsResponse{
+		Success: err == nil,
+		Admins:  admins,
+	}
+
+	if err != nil {
+		slog.Error("Failed to list admins", "error", err)
+		response.Error = err.Error()
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// createAdminHandler - создание администратора из панели; пароль генерируется и показывается один раз
+func (s *Server) createAdminHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req CreateAdminRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if req.Username == "" || !req.Role.Valid() {
+		http.Error(w, "Username and valid role are required", http.StatusBadRequest)
+		return
+	}
+
+	setAuditTarget(r.Context(), req.Username)
+
+	password := generateRandomString(16)
+	admin, err := createAdmin(r.Context(), s.db, req.Username, password, req.Role)
+
+	response := map[string]interface{}{
+		"success": err == nil,
+	}
+
+	if err != nil {
+		response["error"] = err.Error()
+	} else {
+		current := sessionFromContext(r.Context())
+		log.Printf("👤 %s создал администратора %s с ролью %s", current.Username, admin.Username, admin.Role)
+		response["message"] = fmt.Sprintf("Администратор %s создан", admin.Username)
+		response["password"] = password
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// setAdminRoleHandler - смена роли администратора
+func (s *Server) setAdminRoleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req SetAdminRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if req.ID == 0 || !req.Role.Valid() {
+		http.Error(w, "Admin ID and valid role are required", http.StatusBadRequest)
+		return
+	}
+
+	// Для аудита запоминаем прежнюю роль
+	if before, err := getAdminByID(r.Context(), s.db, req.ID); err == nil {
+		setAuditTarget(r.Context(), before.Username)
+		setAuditDetail(r.Context(), "diff", map[string]interface{}{
+			"role": map[string]Role{"old": before.Role, "new": req.Role},
+		})
+	} else {
+		setAuditTarget(r.Context(), fmt.Sprintf("#%d", req.ID))
+	}
+
+	err := s.setAdminRoleByID(r.Context(), req.ID, req.Role)
+
+	response := map[string]interface{}{
+		"success": err == nil,
+	}
+
+	if err != nil {
+		response["error"] = err.Error()
+	} else {
+		current := sessionFromContext(r.Context())
+		log.Printf("🎭 %s назначил администратору #%d роль %s", current.Username, req.ID, req.Role)
+		response["message"] = fmt.Sprintf("Роль изменена на %s", req.Role)
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// setAdminRoleByID - то же, что setAdminRole, но по ID из API
+func (s *Server) setAdminRoleByID(ctx context.Context, id int64, role Role) error {
+	var username string
+	err := s.db.QueryRow(ctx, `SELECT username FROM admin_user WHERE id = $1`, id).Scan(&username)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return errAdminNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to query admin: %w", err)
+	}
+
+	return setAdminRole(ctx, s.db, username, role)
+}
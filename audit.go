@@ -0,0 +1,447 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Действия журнала аудита
+const (
+	AuditLogin               = "auth.login"
+	AuditLogout              = "auth.logout"
+	AuditLoginLockout        = "auth.lockout"
+	AuditBroadcast           = "broadcast.send"
+	AuditPauseBroadcast      = "broadcast.pause"
+	AuditResumeBroadcast     = "broadcast.resume"
+	AuditCancelBroadcast     = "broadcast.cancel"
+	AuditUpdateBroadcast     = "broadcast.update"
+	AuditUploadMedia         = "broadcast.upload_media"
+	AuditTestBroadcast       = "broadcast.test"
+	AuditEditSentBroadcast   = "broadcast.edit_sent"
+	AuditDeleteSentBroadcast = "broadcast.delete_sent"
+	AuditSaveTemplate        = "templates.save"
+	AuditCloneTemplate       = "templates.clone"
+	AuditDeleteTemplate      = "templates.delete"
+	AuditSaveLifecycleRule   = "lifecycle.save"
+	AuditDeleteLifecycleRule = "lifecycle.delete"
+	AuditUpdateTranslations  = "translations.update"
+	AuditRestartBot          = "bot.restart"
+	AuditCreateAdmin         = "admins.create"
+	AuditSetAdminRole        = "admins.set_role"
+	AuditRevokeSession       = "sessions.revoke"
+	AuditUnblockLogin        = "login_blocks.unblock"
+	AuditUpdateCustomer      = "customers.update"
+	AuditTwoFactorSetup      = "2fa.setup"
+	AuditTwoFactorEnable     = "2fa.enable"
+	AuditTwoFactorDisable    = "2fa.disable"
+	AuditRecoveryCodes       = "2fa.recovery_codes"
+)
+
+const (
+	auditMaxRequestBody  = 64 << 10
+	auditMaxResponseBody = 4 << 10
+	auditDefaultLimit    = 50
+	auditMaxLimit        = 200
+)
+
+// auditRedactedFields - поля запроса, которые не попадают в журнал
+var auditRedactedFields = map[string]bool{
+	"password":   true,
+	"code":       true,
+	"secret":     true,
+	"token":      true,
+	"csrf_token": true,
+}
+
+const auditContextKey contextKey = "audit"
+
+type AuditEntry struct {
+	ID        int64           `json:"id"`
+	AdminID   *int64          `json:"admin_id"`
+	Username  string          `json:"username"`
+	IP        string          `json:"ip"`
+	Action    string          `json:"action"`
+	Target    string          `json:"target"`
+	Details   json.RawMessage `json:"details"`
+	Success   bool            `json:"success"`
+	Status    int             `json:"status"`
+	Error     string          `json:"error,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+type AuditResponse struct {
+	Success    bool         `json:"success"`
+	Entries    []AuditEntry `json:"entries,omitempty"`
+	NextBefore int64        `json:"next_before,omitempty"`
+	Error      string       `json:"error,omitempty"`
+}
+
+// AuditFilter - условия выборки журнала; Before - курсор (ID последней полученной записи)
+type AuditFilter struct {
+	Username string
+	Action   string
+	Target   string
+	Success  *bool
+	From     time.Time
+	To       time.Time
+	Before   int64
+	Limit    int
+}
+
+// auditRecord - то, что обработчик может дописать к записи аудита через контекст
+type auditRecord struct {
+	target  string
+	details map[string]interface{}
+}
+
+// setAuditTarget - объект действия (язык, ID администратора и т.п.) для записи аудита текущего запроса
+func setAuditTarget(ctx context.Context, target string) {
+	if record, ok := ctx.Value(auditContextKey).(*auditRecord); ok {
+		record.target = target
+	}
+}
+
+// setAuditDetail - дополнительные сведения (например, diff изменений) для записи аудита текущего запроса
+func setAuditDetail(ctx context.Context, key string, value interface{}) {
+	if record, ok := ctx.Value(auditContextKey).(*auditRecord); ok {
+		record.details[key] = value
+	}
+}
+
+// auditResponseWriter - запоминает статус и начало ответа, чтобы записать результат действия
+type auditResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *auditResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *auditResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if remaining := auditMaxResponseBody - w.body.Len(); remaining > 0 {
+		if len(b) < remaining {
+			remaining = len(b)
+		}
+		w.body.Write(b[:remaining])
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// result - успешность действия и текст ошибки по ответу обработчика
+func (w *auditResponseWriter) result() (bool, string) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	var payload struct {
+		Success *bool  `json:"success"`
+		Error   string `json:"error"`
+	}
+	if json.Unmarshal(w.body.Bytes(), &payload) == nil {
+		success := w.status < 400 && (payload.Success == nil || *payload.Success)
+		return success, payload.Error
+	}
+
+	if w.status >= 400 {
+		return false, strings.TrimSpace(w.body.String())
+	}
+	return true, ""
+}
+
+// redactAuditPayload - убирает из JSON запроса пароли, коды и токены
+func redactAuditPayload(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			if auditRedactedFields[strings.ToLower(key)] {
+				v[key] = "***"
+			} else {
+				v[key] = redactAuditPayload(field)
+			}
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redactAuditPayload(item)
+		}
+	}
+	return value
+}
+
+// audit - middleware: записывает изменяющий запрос в журнал аудита (кто, откуда, что сделал и с каким результатом).
+// Используется внутри requirePermission/requireAuth, чтобы в контексте уже была сессия.
+func (s *Server) audit(action string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if csrfSafeMethod(r.Method) {
+			next(w, r)
+			return
+		}
+
+		record := &auditRecord{details: map[string]interface{}{}}
+
+		// Тело JSON запроса сохраняем целиком (без секретов) и возвращаем обработчику
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") && r.Body != nil {
+			body, err := io.ReadAll(io.LimitReader(r.Body, auditMaxRequestBody+1))
+			r.Body.Close()
+			if err == nil && len(body) <= auditMaxRequestBody {
+				var payload interface{}
+				if json.Unmarshal(body, &payload) == nil {
+					record.details["request"] = redactAuditPayload(payload)
+				}
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		recorder := &auditResponseWriter{ResponseWriter: w}
+		next(recorder, r.WithContext(context.WithValue(r.Context(), auditContextKey, record)))
+
+		success, errMsg := recorder.result()
+		entry := AuditEntry{
+			Action:  action,
+			Target:  record.target,
+			Success: success,
+			Status:  recorder.status,
+			Error:   errMsg,
+		}
+		if len(record.details) > 0 {
+			entry.Details, _ = json.Marshal(record.details)
+		}
+
+		s.recordAudit(context.WithoutCancel(r.Context()), sessionFromContext(r.Context()), clientIP(r), entry)
+	}
+}
+
+// recordAudit - сохраняет запись аудита; ошибка записи не должна ломать само действие
+func (s *Server) recordAudit(ctx context.Context, session *Session, ip string, entry AuditEntry) {
+	var adminID *int64
+	username := ""
+	if session != nil {
+		adminID = &session.AdminID
+		username = session.Username
+	}
+
+	var details interface{}
+	if len(entry.Details) > 0 {
+		details = string(entry.Details)
+	}
+
+	query := `INSERT INTO admin_audit (admin_id, username, ip, action, target, details, success, status, error)
+			  VALUES ($1, $2, $3, $4, $5, $6::jsonb, $7, $8, $9)`
+
+	_, err := s.db.Exec(ctx, query,
+		adminID,
+		username,
+		ip,
+		entry.Action,
+		entry.Target,
+		details,
+		entry.Success,
+		entry.Status,
+		entry.Error,
+	)
+	if err != nil {
+		slog.Error("Failed to write audit entry", "action", entry.Action, "username", username, "error", err)
+	}
+}
+
+// listAudit - записи журнала по фильтру, от новых к старым
+func (s *Server) listAudit(ctx context.Context, filter AuditFilter) ([]AuditEntry, error) {
+	query := `SELECT id, admin_id, username, ip, action, target, COALESCE(details, 'null'::jsonb), success, status, error, created_at
+			  FROM admin_audit
+			  WHERE ($1::text = '' OR username = $1)
+			    AND ($2::text = '' OR action = $2)
+			    AND ($3::text = '' OR target ILIKE '%' || $3 || '%')
+			    AND ($4::boolean IS NULL OR success = $4)
+			    AND ($5::timestamptz IS NULL OR created_at >= $5)
+			    AND ($6::timestamptz IS NULL OR created_at < $6)
+			    AND ($7::bigint = 0 OR id < $7)
+			  ORDER BY id DESC
+			  LIMIT $8`
+
+	var from, to *time.Time
+	if !filter.From.IsZero() {
+		from = &filter.From
+	}
+	if !filter.To.IsZero() {
+		to = &filter.To
+	}
+
+	rows, err := s.db.Query(ctx, query,
+		filter.Username,
+		filter.Action,
+		filter.Target,
+		filter.Success,
+		from,
+		to,
+		filter.Before,
+		filter.Limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit log: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []AuditEntry
+	for rows.Next() {
+		var entry AuditEntry
+		var details []byte
+		err := rows.Scan(
+			&entry.ID,
+			&entry.AdminID,
+			&entry.Username,
+			&entry.IP,
+			&entry.Action,
+			&entry.Target,
+			&details,
+			&entry.Success,
+			&entry.Status,
+			&entry.Error,
+			&entry.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan audit entry: %w", err)
+		}
+		entry.Details = details
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// parseAuditFilter - фильтр журнала из query string: username, action, target, success, from, to, before, limit
+func parseAuditFilter(r *http.Request) (AuditFilter, error) {
+	q := r.URL.Query()
+	filter := AuditFilter{
+		Username: strings.TrimSpace(q.Get("username")),
+		Action:   strings.TrimSpace(q.Get("action")),
+		Target:   strings.TrimSpace(q.Get("target")),
+		Limit:    auditDefaultLimit,
+	}
+
+	if value := q.Get("success"); value != "" {
+		success, err := strconv.ParseBool(value)
+		if err != nil {
+			return filter, fmt.Errorf("invalid success value %q", value)
+		}
+		filter.Success = &success
+	}
+
+	for _, param := range []struct {
+		name string
+		dst  *time.Time
+	}{{"from", &filter.From}, {"to", &filter.To}} {
+		value := q.Get(param.name)
+		if value == "" {
+			continue
+		}
+		t, err := parseFilterTime(value)
+		if err != nil {
+			return filter, fmt.Errorf("invalid %s value %q", param.name, value)
+		}
+		*param.dst = t
+	}
+
+	if value := q.Get("before"); value != "" {
+		before, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || before < 0 {
+			return filter, fmt.Errorf("invalid before value %q", value)
+		}
+		filter.Before = before
+	}
+
+	if value := q.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			return filter, fmt.Errorf("invalid limit value %q", value)
+		}
+		filter.Limit = min(limit, auditMaxLimit)
+	}
+
+	return filter, nil
+}
+
+// parseFilterTime - дата фильтра в формате RFC 3339 или YYYY-MM-DD (по UTC)
+func parseFilterTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+// auditHandler - журнал действий администраторов с фильтрами и постраничной загрузкой
+func (s *Server) auditHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	filter, err := parseAuditFilter(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	entries, err := s.listAudit(r.Context(), filter)
+
+	response := AuditResponse{
+		Success: err == nil,
+		Entries: entries,
+	}
+
+	if err != nil {
+		slog.Error("Failed to list audit log", "error", err)
+		response.Error = err.Error()
+	} else if len(entries) == filter.Limit {
+		response.NextBefore = entries[len(entries)-1].ID
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// translationsDiff - какие ключи перевода добавлены, изменены и удалены
+func translationsDiff(before, after map[string]string) map[string]interface{} {
+	type change struct {
+		Old string `json:"old"`
+		New string `json:"new"`
+	}
+
+	added := map[string]string{}
+	changed := map[string]change{}
+	var removed []string
+
+	for key, value := range after {
+		old, ok := before[key]
+		switch {
+		case !ok:
+			added[key] = value
+		case old != value:
+			changed[key] = change{Old: old, New: value}
+		}
+	}
+	for key := range before {
+		if _, ok := after[key]; !ok {
+			removed = append(removed, key)
+		}
+	}
+
+	return map[string]interface{}{
+		"added":   added,
+		"changed": changed,
+		"removed": removed,
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRedactAuditPayload(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		want    string
+	}{
+		{
+			name:    "password and TOTP code",
+			payload: `{"username": "admin", "password": "hunter2", "code": "123456"}`,
+			want:    `{"username": "admin", "password": "***", "code": "***"}`,
+		},
+		{
+			name:    "field names in any case",
+			payload: `{"Password": "hunter2", "CSRF_TOKEN": "abc", "Secret": "JBSWY3DP"}`,
+			want:    `{"Password": "***", "CSRF_TOKEN": "***", "Secret": "***"}`,
+		},
+		{
+			name:    "nested objects and arrays",
+			payload: `{"admin": {"token": "t", "role": "viewer"}, "items": [{"secret": "s", "id": 1}, "plain"]}`,
+			want:    `{"admin": {"token": "***", "role": "viewer"}, "items": [{"secret": "***", "id": 1}, "plain"]}`,
+		},
+		{
+			name:    "secret object replaced entirely",
+			payload: `{"password": {"old": "a", "new": "b"}}`,
+			want:    `{"password": "***"}`,
+		},
+		{
+			name:    "nothing to redact",
+			payload: `{"message": "Привет", "languages": ["ru", "en"]}`,
+			want:    `{"message": "Привет", "languages": ["ru", "en"]}`,
+		},
+		{
+			name:    "not an object",
+			payload: `"password"`,
+			want:    `"password"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var payload, want interface{}
+			if err := json.Unmarshal([]byte(tt.payload), &payload); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal([]byte(tt.want), &want); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := redactAuditPayload(payload); !reflect.DeepEqual(got, want) {
+				t.Errorf("redactAuditPayload = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+// Обработчик получает тело запроса без изменений, а в журнал попадает копия без секретов
+func TestAuditRedactsRequest(t *testing.T) {
+	db := testDB(t)
+	s := &Server{db: db}
+
+	const body = `{"username": "operator", "password": "hunter2", "role": "operator"}`
+	handler := s.audit(AuditCreateAdmin, func(w http.ResponseWriter, r *http.Request) {
+		got, err := io.ReadAll(r.Body)
+		if err != nil || string(got) != body {
+			t.Errorf("handler body = %q (%v), want the original request", got, err)
+		}
+		writeJSON(w, http.StatusOK, map[string]bool{"success": true})
+	})
+
+	r := httptest.NewRequest(http.MethodPost, "/admin/admins/create", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	handler(httptest.NewRecorder(), r)
+
+	var details string
+	err := db.QueryRow(context.Background(),
+		`SELECT details::text FROM admin_audit WHERE action = $1`, AuditCreateAdmin,
+	).Scan(&details)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(details, "hunter2") || !strings.Contains(details, `"password": "***"`) {
+		t.Errorf("audit details = %s, want the password redacted", details)
+	}
+}
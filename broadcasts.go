@@ -0,0 +1,729 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// Статусы задания рассылки
+const (
+	BroadcastScheduled = "scheduled"
+	BroadcastQueued    = "queued"
+	BroadcastRunning   = "running"
+	BroadcastPaused    = "paused"
+	BroadcastCompleted = "completed"
+	BroadcastCancelled = "cancelled"
+	BroadcastFailed    = "failed"
+)
+
+// Статусы доставки отдельному получателю. sending ставится до обращения к Telegram:
+// если панель упала между отправкой и сохранением результата, такой получатель не получит сообщение повторно.
+const (
+	RecipientPending   = "pending"
+	RecipientSending   = "sending"
+	RecipientSent      = "sent"
+	RecipientFailed    = "failed"
+	RecipientCancelled = "cancelled"
+	RecipientBlocked   = "blocked" // пользователь заблокировал бота или чат не найден
+)
+
+// Действия управления заданием рассылки
+const (
+	BroadcastActionPause  = "pause"
+	BroadcastActionResume = "resume"
+	BroadcastActionCancel = "cancel"
+)
+
+const (
+	broadcastBatchSize    = 100
+	broadcastPollInterval = 10 * time.Second
+	broadcastsListLimit   = 50
+	broadcastsMaxLimit    = 200
+)
+
+var (
+	errBroadcastNotFound = errors.New("broadcast not found")
+	errNoRecipients      = errors.New("в сегменте нет получателей")
+	errBroadcastState    = errors.New("действие недоступно в текущем статусе рассылки")
+)
+
+// broadcastTransitions - из каких статусов допустимо действие и в какой статус оно переводит задание
+var broadcastTransitions = map[string]struct {
+	from []string
+	to   string
+}{
+	BroadcastActionPause:  {[]string{BroadcastQueued, BroadcastRunning}, BroadcastPaused},
+	BroadcastActionResume: {[]string{BroadcastPaused}, BroadcastQueued},
+	BroadcastActionCancel: {[]string{BroadcastScheduled, BroadcastQueued, BroadcastRunning, BroadcastPaused}, BroadcastCancelled},
+}
+
+// BroadcastJob - задание рассылки; счетчики считаются по таблице получателей
+type BroadcastJob struct {
+	ID int64 `json:"id"`
+	BroadcastContent
+	Segment      BroadcastSegment `json:"segment"`
+	SegmentLabel string           `json:"segment_label"`
+	Status       string           `json:"status"`
+	CreatedBy    string           `json:"created_by"`
+	CreatedAt    time.Time        `json:"created_at"`
+	ScheduledAt  *time.Time       `json:"scheduled_at"`
+	Timezone     string           `json:"timezone,omitempty"`
+	StartedAt    *time.Time       `json:"started_at"`
+	FinishedAt   *time.Time       `json:"finished_at"`
+	Total        int              `json:"total"`
+	Sent         int              `json:"sent"`
+	Failed       int              `json:"failed"`
+	Pending      int              `json:"pending"`
+	Cancelled    int              `json:"cancelled"`
+	Blocked      int              `json:"blocked"`
+	Error        string           `json:"error,omitempty"`
+}
+
+type BroadcastJobResponse struct {
+	Success   bool          `json:"success"`
+	Broadcast *BroadcastJob `json:"broadcast,omitempty"`
+	Error     string        `json:"error,omitempty"`
+}
+
+type BroadcastsResponse struct {
+	Success    bool           `json:"success"`
+	Broadcasts []BroadcastJob `json:"broadcasts,omitempty"`
+	NextBefore int64          `json:"next_before,omitempty"` // курсор следующей страницы истории
+	Error      string         `json:"error,omitempty"`
+}
+
+// broadcastJobColumns - поля задания со счетчиками; запрос должен соединять admin_broadcast b с admin_broadcast_recipient r
+const broadcastJobColumns = `b.id, b.message, b.messages, b.fallback_language, b.media, b.buttons, b.segment, b.status, b.created_by_name, b.created_at, b.started_at, b.finished_at,
+	b.total, b.error, b.scheduled_at, b.timezone,
+	COUNT(r.telegram_id) FILTER (WHERE r.status = 'sent'),
+	COUNT(r.telegram_id) FILTER (WHERE r.status = 'failed'),
+	COUNT(r.telegram_id) FILTER (WHERE r.status IN ('pending', 'sending')),
+	COUNT(r.telegram_id) FILTER (WHERE r.status = 'cancelled'),
+	COUNT(r.telegram_id) FILTER (WHERE r.status = 'blocked')`
+
+func scanBroadcastJob(row pgx.Row) (*BroadcastJob, error) {
+	var job BroadcastJob
+	var segment []byte
+	content := scanContent(&job.BroadcastContent)
+	dest := append([]interface{}{&job.ID}, content.dest()...)
+	err := row.Scan(append(dest,
+		&segment,
+		&job.Status,
+		&job.CreatedBy,
+		&job.CreatedAt,
+		&job.StartedAt,
+		&job.FinishedAt,
+		&job.Total,
+		&job.Error,
+		&job.ScheduledAt,
+		&job.Timezone,
+		&job.Sent,
+		&job.Failed,
+		&job.Pending,
+		&job.Cancelled,
+		&job.Blocked,
+	)...)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(segment, &job.Segment); err != nil {
+		return nil, fmt.Errorf("failed to parse broadcast segment: %w", err)
+	}
+	if err := content.parse(); err != nil {
+		return nil, err
+	}
+	job.SegmentLabel = job.Segment.String()
+	return &job, nil
+}
+
+// createBroadcast - сохраняет задание рассылки. Без scheduledAt список получателей сегмента фиксируется сразу
+// и задание ставится в очередь, запланированное задание получает получателей в момент запуска.
+// Возвращает ID задания, число получателей и сколько пользователей сегмента пропущено, потому что заблокировали бота.
+func (s *Server) createBroadcast(ctx context.Context, session *Session, req BroadcastRequest, scheduledAt *time.Time, timezone string) (int64, int, int, error) {
+	now := time.Now()
+	if _, err := req.Segment.filter(now); err != nil {
+		return 0, 0, 0, err
+	}
+
+	segmentJSON, err := json.Marshal(req.Segment)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("failed to marshal segment: %w", err)
+	}
+	content, err := req.BroadcastContent.columns()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	status := BroadcastQueued
+	if scheduledAt != nil {
+		status = BroadcastScheduled
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var id int64
+	err = tx.QueryRow(ctx,
+		`INSERT INTO admin_broadcast (message, messages, fallback_language, media, buttons,
+		                              segment, status, created_by, created_by_name, scheduled_at, timezone)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		 RETURNING id`,
+		append(content, string(segmentJSON), status, session.AdminID, session.Username, scheduledAt, timezone)...,
+	).Scan(&id)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("failed to insert broadcast: %w", err)
+	}
+
+	var total, blocked int
+	if scheduledAt == nil {
+		total, blocked, err = snapshotRecipients(ctx, tx, id, req.Segment, now)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+
+		if _, err := tx.Exec(ctx, `UPDATE admin_broadcast SET total = $2 WHERE id = $1`, id, total); err != nil {
+			return 0, 0, 0, fmt.Errorf("failed to update broadcast total: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, 0, 0, fmt.Errorf("failed to commit broadcast: %w", err)
+	}
+
+	if scheduledAt == nil {
+		s.wakeBroadcastWorker()
+	}
+	return id, total, blocked, nil
+}
+
+// snapshotRecipients - сохраняет получателей сегмента на момент now для задания id.
+// Возвращает число получателей и сколько пользователей сегмента пропущено, потому что заблокировали бота.
+func snapshotRecipients(ctx context.Context, tx pgx.Tx, id int64, segment BroadcastSegment, now time.Time) (int, int, error) {
+	filter, err := segment.filter(now)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Параметры $1-$9 - фильтр сегмента, $10 - ID задания
+	args := append(filter.args(), id)
+	tag, err := tx.Exec(ctx,
+		`INSERT INTO admin_broadcast_recipient (broadcast_id, telegram_id, language)
+		 SELECT $10, telegram_id, language FROM customer WHERE `+customerFilterWhere+`
+		 ON CONFLICT DO NOTHING`,
+		args...,
+	)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to insert broadcast recipients: %w", err)
+	}
+
+	total := int(tag.RowsAffected())
+	if total == 0 {
+		return 0, 0, errNoRecipients
+	}
+
+	var blocked int
+	if filter.Blocked == CustomerBlockedExclude {
+		filter.Blocked = CustomerBlockedOnly
+		err := tx.QueryRow(ctx, `SELECT COUNT(*) FROM customer WHERE `+customerFilterWhere, filter.args()...).Scan(&blocked)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to count blocked recipients: %w", err)
+		}
+	}
+
+	return total, blocked, nil
+}
+
+// getBroadcast - задание рассылки с текущими счетчиками
+func (s *Server) getBroadcast(ctx context.Context, id int64) (*BroadcastJob, error) {
+	query := `SELECT ` + broadcastJobColumns + `
+			  FROM admin_broadcast AS b
+			  LEFT JOIN admin_broadcast_recipient AS r ON r.broadcast_id = b.id
+			  WHERE b.id = $1
+			  GROUP BY b.id`
+
+	job, err := scanBroadcastJob(s.db.QueryRow(ctx, query, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errBroadcastNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query broadcast: %w", err)
+	}
+	return job, nil
+}
+
+// listBroadcasts - история рассылок под фильтром, от новых к старым
+func (s *Server) listBroadcasts(ctx context.Context, filter BroadcastFilter) ([]BroadcastJob, error) {
+	query := `SELECT ` + broadcastJobColumns + `
+			  FROM admin_broadcast AS b
+			  LEFT JOIN admin_broadcast_recipient AS r ON r.broadcast_id = b.id
+			  WHERE ($1::text = '' OR b.status = $1)
+			    AND ($2::text = '' OR b.created_by_name = $2)
+			    AND ($3::timestamptz IS NULL OR b.created_at >= $3)
+			    AND ($4::timestamptz IS NULL OR b.created_at < $4)
+			    AND ($5::bigint = 0 OR b.id < $5)
+			  GROUP BY b.id
+			  ORDER BY b.id DESC
+			  LIMIT $6`
+
+	rows, err := s.db.Query(ctx, query,
+		filter.Status,
+		filter.Author,
+		optionalTime(filter.From),
+		optionalTime(filter.To),
+		filter.Before,
+		filter.Limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query broadcasts: %w", err)
+	}
+	defer rows.Close()
+
+	var jobs []BroadcastJob
+	for rows.Next() {
+		job, err := scanBroadcastJob(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan broadcast: %w", err)
+		}
+		jobs = append(jobs, *job)
+	}
+
+	return jobs, rows.Err()
+}
+
+// wakeBroadcastWorker - сообщает воркеру, что появилась работа, не дожидаясь очередного опроса
+func (s *Server) wakeBroadcastWorker() {
+	select {
+	case s.broadcastWake <- struct{}{}:
+	default:
+	}
+}
+
+// runBroadcastWorker - фоновый воркер: по одному выполняет задания рассылки из БД.
+// Прерванные перезапуском задания остаются в статусе running и продолжаются с неотправленных получателей.
+func (s *Server) runBroadcastWorker(ctx context.Context) {
+	log.Printf("📨 Воркер рассылок запущен")
+
+	if err := s.recoverInterruptedRecipients(ctx); err != nil {
+		slog.Error("Failed to recover interrupted broadcast recipients", "error", err)
+	}
+
+	for {
+		processed, err := s.processNextBroadcast(ctx)
+		if err == nil && !processed {
+			processed, err = s.processNextBroadcastOperation(ctx)
+		}
+		if err != nil {
+			slog.Error("Broadcast worker error", "error", err)
+		}
+		if ctx.Err() != nil {
+			log.Printf("📨 Воркер рассылок остановлен")
+			return
+		}
+		if processed && err == nil {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Printf("📨 Воркер рассылок остановлен")
+			return
+		case <-s.broadcastWake:
+		case <-time.After(broadcastPollInterval):
+		}
+	}
+}
+
+// processNextBroadcast - берет самое старое незавершенное задание и рассылает его; false - заданий нет
+func (s *Server) processNextBroadcast(ctx context.Context) (bool, error) {
+	var id int64
+	var content broadcastContent
+	row := scanContent(&content.BroadcastContent)
+	err := s.db.QueryRow(ctx,
+		`UPDATE admin_broadcast
+		 SET status = $1, started_at = COALESCE(started_at, now())
+		 WHERE id = (
+		   SELECT id FROM admin_broadcast
+		   WHERE status IN ($2, $1)
+		   ORDER BY id
+		   LIMIT 1
+		 )
+		 RETURNING id, message, messages, fallback_language, media, buttons`,
+		BroadcastRunning, BroadcastQueued,
+	).Scan(append([]interface{}{&id}, row.dest()...)...)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to pick broadcast: %w", err)
+	}
+
+	if !s.telegram.Configured() {
+		return true, s.finishBroadcast(ctx, id, BroadcastFailed, errTelegramNotConfigured.Error())
+	}
+
+	if err := row.parse(); err != nil {
+		return true, s.finishBroadcast(ctx, id, BroadcastFailed, err.Error())
+	}
+
+	log.Printf("📨 Рассылка #%d: отправка начата", id)
+
+	for {
+		recipients, err := s.pendingRecipients(ctx, id, broadcastBatchSize)
+		if err != nil {
+			return true, err
+		}
+		if len(recipients) == 0 {
+			break
+		}
+
+		for i := range recipients {
+			recipient := &recipients[i]
+			telegramID := recipient.TelegramID
+			if ctx.Err() != nil {
+				return true, nil
+			}
+
+			// Получатель захватывается только пока задание в статусе running, поэтому пауза
+			// и отмена срабатывают до следующего сообщения
+			claimed, err := s.claimRecipient(ctx, id, telegramID)
+			if err != nil {
+				return true, err
+			}
+			if !claimed {
+				log.Printf("⏸️ Рассылка #%d остановлена", id)
+				return true, nil
+			}
+
+			// Частоту отправки и повторы после 429/5xx обеспечивает клиент Telegram
+			messageID, sendErr := s.sendBroadcastContent(ctx, recipient, &content)
+			if sendErr != nil {
+				slog.Error("Failed to send broadcast message",
+					"broadcast_id", id,
+					"telegram_id", telegramID,
+					"error", sendErr)
+			}
+			// Результат уже отправленного сообщения сохраняем, даже если сервер останавливается
+			if err := s.markRecipient(context.WithoutCancel(ctx), id, telegramID, messageID, sendErr); err != nil {
+				return true, err
+			}
+		}
+	}
+
+	_, err = s.db.Exec(ctx,
+		`UPDATE admin_broadcast SET status = $2, finished_at = now() WHERE id = $1 AND status = $3`,
+		id, BroadcastCompleted, BroadcastRunning,
+	)
+	if err != nil {
+		return true, fmt.Errorf("failed to complete broadcast %d: %w", id, err)
+	}
+
+	log.Printf("✅ Рассылка #%d завершена", id)
+	return true, nil
+}
+
+// claimRecipient - помечает получателя как отправляемого; false - задание уже не running или получатель обработан
+func (s *Server) claimRecipient(ctx context.Context, id, telegramID int64) (bool, error) {
+	tag, err := s.db.Exec(ctx,
+		`UPDATE admin_broadcast_recipient AS r
+		 SET status = $3
+		 FROM admin_broadcast AS b
+		 WHERE b.id = r.broadcast_id
+		   AND b.status = $5
+		   AND r.broadcast_id = $1
+		   AND r.telegram_id = $2
+		   AND r.status = $4`,
+		id, telegramID, RecipientSending, RecipientPending, BroadcastRunning,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to claim broadcast recipient: %w", err)
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// recoverInterruptedRecipients - получатели, застрявшие в sending после падения панели. Было ли сообщение
+// доставлено, неизвестно, поэтому повторно не отправляем, а отмечаем как неудачу.
+func (s *Server) recoverInterruptedRecipients(ctx context.Context) error {
+	tag, err := s.db.Exec(ctx,
+		`UPDATE admin_broadcast_recipient
+		 SET status = $2, error = $3, sent_at = now()
+		 WHERE status = $1`,
+		RecipientSending, RecipientFailed, "interrupted: delivery unknown, not retried",
+	)
+	if err != nil {
+		return fmt.Errorf("failed to recover interrupted recipients: %w", err)
+	}
+	if n := tag.RowsAffected(); n > 0 {
+		log.Printf("⚠️ %d получателей рассылок остались в неизвестном состоянии после перезапуска и не будут отправлены повторно", n)
+	}
+	return nil
+}
+
+// controlBroadcast - пауза, продолжение или отмена задания рассылки
+func (s *Server) controlBroadcast(ctx context.Context, id int64, action string) (string, error) {
+	transition, ok := broadcastTransitions[action]
+	if !ok {
+		return "", fmt.Errorf("unknown action %q", action)
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var status string
+	err = tx.QueryRow(ctx, `SELECT status FROM admin_broadcast WHERE id = $1 FOR UPDATE`, id).Scan(&status)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", errBroadcastNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to query broadcast: %w", err)
+	}
+
+	allowed := false
+	for _, from := range transition.from {
+		if status == from {
+			allowed = true
+		}
+	}
+	if !allowed {
+		return "", fmt.Errorf("%w: %s", errBroadcastState, status)
+	}
+
+	if transition.to == BroadcastCancelled {
+		_, err = tx.Exec(ctx, `UPDATE admin_broadcast SET status = $2, finished_at = now() WHERE id = $1`, id, transition.to)
+		if err == nil {
+			_, err = tx.Exec(ctx,
+				`UPDATE admin_broadcast_recipient SET status = $3 WHERE broadcast_id = $1 AND status = $2`,
+				id, RecipientPending, RecipientCancelled,
+			)
+		}
+	} else {
+		_, err = tx.Exec(ctx, `UPDATE admin_broadcast SET status = $2 WHERE id = $1`, id, transition.to)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to update broadcast: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return "", fmt.Errorf("failed to commit broadcast update: %w", err)
+	}
+
+	if transition.to == BroadcastQueued {
+		s.wakeBroadcastWorker()
+	}
+	return transition.to, nil
+}
+
+// pendingRecipients - очередная пачка получателей, которым сообщение еще не отправлялось, с данными клиента
+// для подстановки в текст. Язык берется из снимка получателей, чтобы текст не зависел от изменений после запуска.
+func (s *Server) pendingRecipients(ctx context.Context, id int64, limit int) ([]Customer, error) {
+	rows, err := s.db.Query(ctx,
+		`SELECT r.telegram_id, r.language, COALESCE(c.id, 0), c.expire_at, c.subscription_link
+		 FROM admin_broadcast_recipient AS r
+		 LEFT JOIN customer AS c ON c.telegram_id = r.telegram_id
+		 WHERE r.broadcast_id = $1 AND r.status = $2
+		 ORDER BY r.telegram_id
+		 LIMIT $3`,
+		id, RecipientPending, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query broadcast recipients: %w", err)
+	}
+	defer rows.Close()
+
+	var recipients []Customer
+	for rows.Next() {
+		var recipient Customer
+		err := rows.Scan(&recipient.TelegramID, &recipient.Language, &recipient.ID, &recipient.ExpireAt, &recipient.SubscriptionLink)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan broadcast recipient: %w", err)
+		}
+		recipients = append(recipients, recipient)
+	}
+
+	return recipients, rows.Err()
+}
+
+// markRecipient - сохраняет результат доставки получателю и ID сообщения для последующего изменения или удаления.
+// Заблокировавший бота пользователь отмечается в admin_blocked_user, а успешная доставка снимает эту отметку.
+func (s *Server) markRecipient(ctx context.Context, id, telegramID, messageID int64, sendErr error) error {
+	status, errMsg := RecipientSent, ""
+	if sendErr != nil {
+		status, errMsg = RecipientFailed, sendErr.Error()
+		if isRecipientUnreachable(sendErr) {
+			status = RecipientBlocked
+		}
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx,
+		`UPDATE admin_broadcast_recipient
+		 SET status = $3, error = $4, sent_at = now(), message_id = NULLIF($5::bigint, 0)
+		 WHERE broadcast_id = $1 AND telegram_id = $2`,
+		id, telegramID, status, errMsg, messageID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update broadcast recipient: %w", err)
+	}
+
+	switch status {
+	case RecipientBlocked:
+		_, err = tx.Exec(ctx,
+			`INSERT INTO admin_blocked_user (telegram_id, reason, broadcast_id)
+			 VALUES ($1, $2, $3)
+			 ON CONFLICT (telegram_id) DO UPDATE SET blocked_at = now(), reason = $2, broadcast_id = $3`,
+			telegramID, errMsg, id,
+		)
+	case RecipientSent:
+		_, err = tx.Exec(ctx, `DELETE FROM admin_blocked_user WHERE telegram_id = $1`, telegramID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update blocked user: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit broadcast recipient: %w", err)
+	}
+	return nil
+}
+
+// finishBroadcast - переводит задание в конечный статус
+func (s *Server) finishBroadcast(ctx context.Context, id int64, status, errMsg string) error {
+	_, err := s.db.Exec(ctx,
+		`UPDATE admin_broadcast SET status = $2, error = $3, finished_at = now() WHERE id = $1`,
+		id, status, errMsg,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to finish broadcast %d: %w", id, err)
+	}
+	return nil
+}
+
+// broadcastsHandler - история рассылок; фильтры status, created_by, from, to, курсор before, limit
+func (s *Server) broadcastsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	filter, err := parseBroadcastFilter(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	jobs, err := s.listBroadcasts(r.Context(), filter)
+
+	response := BroadcastsResponse{
+		Success:    err == nil,
+		Broadcasts: jobs,
+	}
+	if len(jobs) == filter.Limit {
+		response.NextBefore = jobs[len(jobs)-1].ID
+	}
+
+	if err != nil {
+		slog.Error("Failed to list broadcasts", "error", err)
+		response.Error = err.Error()
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// broadcastJobHandler - состояние задания рассылки /admin/broadcasts/{id}
+func (s *Server) broadcastJobHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id <= 0 {
+		writeJSONError(w, http.StatusBadRequest, "Invalid broadcast ID")
+		return
+	}
+
+	job, err := s.getBroadcast(r.Context(), id)
+
+	response := BroadcastJobResponse{
+		Success:   err == nil,
+		Broadcast: job,
+	}
+
+	status := http.StatusOK
+
+	if err != nil {
+		if errors.Is(err, errBroadcastNotFound) {
+			status = http.StatusNotFound
+		} else {
+			slog.Error("Failed to load broadcast", "broadcast_id", id, "error", err)
+		}
+		response.Error = err.Error()
+	}
+
+	writeJSON(w, status, response)
+}
+
+// broadcastControlHandler - POST /admin/broadcasts/{id}/pause|resume|cancel
+func (s *Server) broadcastControlHandler(action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil || id <= 0 {
+			writeJSONError(w, http.StatusBadRequest, "Invalid broadcast ID")
+			return
+		}
+
+		setAuditTarget(r.Context(), fmt.Sprintf("broadcast:%d", id))
+
+		newStatus, err := s.controlBroadcast(r.Context(), id, action)
+
+		response := map[string]interface{}{
+			"success": err == nil,
+		}
+
+		status := http.StatusOK
+
+		if err != nil {
+			switch {
+			case errors.Is(err, errBroadcastNotFound):
+				status = http.StatusNotFound
+			case errors.Is(err, errBroadcastState):
+				status = http.StatusConflict
+			default:
+				slog.Error("Failed to control broadcast", "broadcast_id", id, "action", action, "error", err)
+			}
+			response["error"] = err.Error()
+		} else {
+			current := sessionFromContext(r.Context())
+			log.Printf("📨 %s: рассылка #%d -> %s", current.Username, id, newStatus)
+			response["status"] = newStatus
+			response["message"] = fmt.Sprintf("Рассылка #%d: %s", id, newStatus)
+		}
+
+		writeJSON(w, status, response)
+	}
+}
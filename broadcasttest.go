@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// broadcastTestValidFor - сколько действует успешная тестовая отправка при BROADCAST_REQUIRE_TEST=true
+const broadcastTestValidFor = 24 * time.Hour
+
+var (
+	errBroadcastTestChatsNotConfigured = errors.New("не заданы BROADCAST_TEST_CHAT_IDS для тестовой отправки")
+	errBroadcastTestRequired           = errors.New("сначала отправьте эту рассылку тестом администраторам")
+)
+
+// broadcastTestChatIDs - Telegram ID администраторов для тестовой отправки (BROADCAST_TEST_CHAT_IDS через запятую)
+func broadcastTestChatIDs() ([]int64, error) {
+	var ids []int64
+	for _, part := range strings.FieldsFunc(getEnv("BROADCAST_TEST_CHAT_IDS", ""), func(r rune) bool {
+		return r == ',' || r == ' ' || r == ';'
+	}) {
+		id, err := strconv.ParseInt(part, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid BROADCAST_TEST_CHAT_IDS value %q", part)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// broadcastTestRequired - запускать рассылку можно только после успешной тестовой отправки (BROADCAST_REQUIRE_TEST=true)
+func broadcastTestRequired() bool {
+	value := getEnv("BROADCAST_REQUIRE_TEST", "false")
+	return value == "true" || value == "1"
+}
+
+// contentHash - отпечаток проверенного содержимого рассылки: тест засчитывается только для того же текста,
+// вложения и кнопок
+func contentHash(content BroadcastContent) (string, error) {
+	data, err := json.Marshal(content)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal broadcast content: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// checkBroadcastTested - при BROADCAST_REQUIRE_TEST=true требует недавнюю успешную тестовую отправку того же содержимого
+func (s *Server) checkBroadcastTested(ctx context.Context, content BroadcastContent) error {
+	if !broadcastTestRequired() {
+		return nil
+	}
+
+	tested, err := contentTested(ctx, s.db, content, time.Now().Add(-broadcastTestValidFor))
+	if err != nil {
+		return err
+	}
+	if !tested {
+		return errBroadcastTestRequired
+	}
+	return nil
+}
+
+// contentTested - было ли это содержимое успешно отправлено тестом после since (нулевое - за все время)
+func contentTested(ctx context.Context, q pgxQuerier, content BroadcastContent, since time.Time) (bool, error) {
+	hash, err := contentHash(content)
+	if err != nil {
+		return false, err
+	}
+
+	var tested bool
+	err = q.QueryRow(ctx,
+		`SELECT EXISTS (SELECT 1 FROM admin_broadcast_test WHERE content_hash = $1 AND created_at > $2)`,
+		hash, since,
+	).Scan(&tested)
+	if err != nil {
+		return false, fmt.Errorf("failed to check broadcast test: %w", err)
+	}
+	return tested, nil
+}
+
+// testRecipient - администратор как получатель теста: данные клиента с тем же Telegram ID, если он есть,
+// иначе пример клиента на fallback языке рассылки
+func (s *Server) testRecipient(ctx context.Context, telegramID int64, content BroadcastContent) (*Customer, error) {
+	var customer Customer
+	err := s.db.QueryRow(ctx,
+		`SELECT id, telegram_id, expire_at, created_at, subscription_link, language
+		 FROM customer
+		 WHERE telegram_id = $1`,
+		telegramID,
+	).Scan(&customer.ID, &customer.TelegramID, &customer.ExpireAt, &customer.CreatedAt, &customer.SubscriptionLink, &customer.Language)
+	if errors.Is(err, pgx.ErrNoRows) {
+		sample := sampleCustomer(time.Now())
+		sample.TelegramID, sample.Language = telegramID, content.FallbackLanguage
+		return sample, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query customer: %w", err)
+	}
+	return &customer, nil
+}
+
+// BroadcastTestResult - результат тестовой отправки одному администратору
+type BroadcastTestResult struct {
+	TelegramID int64  `json:"telegram_id"`
+	MessageID  int64  `json:"message_id,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// sendBroadcastTest - отправляет содержимое рассылки администраторам так же, как его получат клиенты.
+// Успешная отправка всем администраторам запоминается для BROADCAST_REQUIRE_TEST.
+func (s *Server) sendBroadcastTest(ctx context.Context, session *Session, content BroadcastContent) ([]BroadcastTestResult, error) {
+	chatIDs, err := broadcastTestChatIDs()
+	if err != nil {
+		return nil, err
+	}
+	if len(chatIDs) == 0 {
+		return nil, errBroadcastTestChatsNotConfigured
+	}
+
+	hash, err := contentHash(content)
+	if err != nil {
+		return nil, err
+	}
+
+	sending := broadcastContent{BroadcastContent: content}
+	results := make([]BroadcastTestResult, 0, len(chatIDs))
+	failed := false
+	for _, chatID := range chatIDs {
+		result := BroadcastTestResult{TelegramID: chatID}
+		customer, err := s.testRecipient(ctx, chatID, content)
+		if err == nil {
+			result.MessageID, err = s.sendBroadcastContent(ctx, customer, &sending)
+		}
+		if err != nil {
+			slog.Error("Failed to send broadcast test", "telegram_id", chatID, "error", err)
+			result.Error = err.Error()
+			failed = true
+		}
+		results = append(results, result)
+	}
+
+	if !failed {
+		_, err = s.db.Exec(ctx,
+			`INSERT INTO admin_broadcast_test (content_hash, admin_id, created_by_name) VALUES ($1, $2, $3)`,
+			hash, session.AdminID, session.Username,
+		)
+		if err != nil {
+			return results, fmt.Errorf("failed to save broadcast test: %w", err)
+		}
+	}
+	return results, nil
+}
+
+// broadcastTestHandler - POST /admin/broadcast/test: отправка рассылки (текст, вложение, кнопки) администраторам
+// из BROADCAST_TEST_CHAT_IDS
+func (s *Server) broadcastTestHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var content BroadcastContent
+	if err := json.NewDecoder(r.Body).Decode(&content); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.validateContent(r.Context(), &content); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !s.telegram.Configured() {
+		http.Error(w, "Telegram token not configured", http.StatusInternalServerError)
+		return
+	}
+
+	current := sessionFromContext(r.Context())
+	results, err := s.sendBroadcastTest(r.Context(), current, content)
+
+	sent := 0
+	for _, result := range results {
+		if result.Error == "" {
+			sent++
+		}
+	}
+	setAuditDetail(r.Context(), "sent", sent)
+
+	response := map[string]interface{}{
+		"success": err == nil && sent == len(results),
+		"results": results,
+	}
+
+	if err != nil {
+		response["error"] = err.Error()
+	} else {
+		log.Printf("🧪 %s отправил тест рассылки: %d из %d", current.Username, sent, len(results))
+		response["message"] = fmt.Sprintf("Тест отправлен: %d из %d", sent, len(results))
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+)
+
+// trustedProxies - адреса обратных прокси из TRUSTED_PROXIES (IP или CIDR через запятую). Только им панель
+// верит в X-Forwarded-For и X-Forwarded-Proto; без настройки оба заголовка игнорируются
+var trustedProxies = sync.OnceValue(func() []netip.Prefix {
+	prefixes, invalid := parseTrustedProxies(getEnv("TRUSTED_PROXIES", ""))
+	for _, entry := range invalid {
+		log.Printf("⚠️ TRUSTED_PROXIES: не удалось разобрать %q, адрес пропущен", entry)
+	}
+	return prefixes
+})
+
+// parseTrustedProxies - разбирает список IP и подсетей; нераспознанные элементы возвращаются отдельно
+func parseTrustedProxies(value string) (prefixes []netip.Prefix, invalid []string) {
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		if addr, err := netip.ParseAddr(entry); err == nil {
+			addr = addr.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		invalid = append(invalid, entry)
+	}
+	return prefixes, invalid
+}
+
+// isTrustedProxy - входит ли адрес в список доверенных прокси
+func isTrustedProxy(ip string, trusted []netip.Prefix) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteIP - адрес соединения без порта
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// clientIP - IP адрес клиента: счетчики попыток входа, сессии и журнал аудита используют только его
+func clientIP(r *http.Request) string {
+	return resolveClientIP(r, trustedProxies())
+}
+
+// resolveClientIP - адрес соединения, а если оно пришло от доверенного прокси - первый справа адрес
+// X-Forwarded-For, не являющийся доверенным прокси (левые элементы клиент может подделать)
+func resolveClientIP(r *http.Request, trusted []netip.Prefix) string {
+	ip := remoteIP(r)
+	if !isTrustedProxy(ip, trusted) {
+		return ip
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if _, err := netip.ParseAddr(hop); err != nil {
+			break
+		}
+		ip = hop
+		if !isTrustedProxy(hop, trusted) {
+			break
+		}
+	}
+	return ip
+}
+
+// requestIsHTTPS - пришел ли запрос по HTTPS; X-Forwarded-Proto учитывается только от доверенного прокси
+func requestIsHTTPS(r *http.Request, trusted []netip.Prefix) bool {
+	if r.TLS != nil {
+		return true
+	}
+	return isTrustedProxy(remoteIP(r), trusted) && r.Header.Get("X-Forwarded-Proto") == "https"
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	prefixes, invalid := parseTrustedProxies(" 10.0.0.1, 172.18.0.0/16 ,,::1, 192.168.1.7/24, proxy.local")
+
+	var got []string
+	for _, prefix := range prefixes {
+		got = append(got, prefix.String())
+	}
+	want := []string{"10.0.0.1/32", "172.18.0.0/16", "::1/128", "192.168.1.0/24"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("prefixes = %v, want %v", got, want)
+	}
+	if !reflect.DeepEqual(invalid, []string{"proxy.local"}) {
+		t.Errorf("invalid = %v, want [proxy.local]", invalid)
+	}
+}
+
+func TestResolveClientIP(t *testing.T) {
+	trusted, _ := parseTrustedProxies("10.0.0.0/8")
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor []string
+		trusted      bool
+		want         string
+	}{
+		{"direct connection", "203.0.113.5:4321", nil, true, "203.0.113.5"},
+		{"untrusted peer cannot spoof", "203.0.113.5:4321", []string{"198.51.100.1"}, true, "203.0.113.5"},
+		{"no proxies configured", "10.0.0.2:4321", []string{"198.51.100.1"}, false, "10.0.0.2"},
+		{"trusted proxy", "10.0.0.2:4321", []string{"198.51.100.1"}, true, "198.51.100.1"},
+		{"spoofed left entries are ignored", "10.0.0.2:4321", []string{"1.1.1.1, 198.51.100.1"}, true, "198.51.100.1"},
+		{"chain of trusted proxies", "10.0.0.2:4321", []string{"198.51.100.1, 10.0.0.3", "10.0.0.4"}, true, "198.51.100.1"},
+		{"garbage stops the walk", "10.0.0.2:4321", []string{"198.51.100.1, junk"}, true, "10.0.0.2"},
+		{"trusted proxy without header", "10.0.0.2:4321", nil, true, "10.0.0.2"},
+		{"ipv6 peer", "[2001:db8::1]:4321", nil, true, "2001:db8::1"},
+		{"remote addr without port", "203.0.113.5", nil, true, "203.0.113.5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwardedFor {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+
+			proxies := trusted
+			if !tt.trusted {
+				proxies = nil
+			}
+			if got := resolveClientIP(r, proxies); got != tt.want {
+				t.Errorf("resolveClientIP = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestIsHTTPS(t *testing.T) {
+	trusted, _ := parseTrustedProxies("10.0.0.0/8")
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		proto      string
+		tls        bool
+		want       bool
+	}{
+		{"plain http", "203.0.113.5:1", "", false, false},
+		{"direct tls", "203.0.113.5:1", "", true, true},
+		{"header from untrusted peer", "203.0.113.5:1", "https", false, false},
+		{"header from trusted proxy", "10.0.0.2:1", "https", false, true},
+		{"trusted proxy over http", "10.0.0.2:1", "http", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.proto != "" {
+				r.Header.Set("X-Forwarded-Proto", tt.proto)
+			}
+			if tt.tls {
+				r.TLS = &tls.ConnectionState{}
+			}
+			if got := requestIsHTTPS(r, trusted); got != tt.want {
+				t.Errorf("requestIsHTTPS = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"text/template"
+)
+
+// BroadcastContent - что получают пользователи: текст (или тексты по языкам), вложение и кнопки
+type BroadcastContent struct {
+	Message          string            `json:"message"`            // текст или подпись к вложению; с вариантами - текст fallback языка
+	Messages         map[string]string `json:"messages,omitempty"` // тексты по коду языка клиента
+	FallbackLanguage string            `json:"fallback_language,omitempty"`
+	Media            *BroadcastMedia   `json:"media,omitempty"`
+	Buttons          [][]InlineButton  `json:"buttons,omitempty"`
+}
+
+// validateContent - проверяет текст, варианты, вложение и кнопки; загруженный файл должен существовать
+func (s *Server) validateContent(ctx context.Context, content *BroadcastContent) error {
+	if err := content.normalizeVariants(); err != nil {
+		return err
+	}
+
+	if strings.TrimSpace(content.Message) == "" && content.Media == nil {
+		return fmt.Errorf("message or media is required")
+	}
+
+	limit := telegramMaxMessageLength
+	if content.Media != nil {
+		limit = telegramMaxCaptionLength
+		if err := content.Media.validate(); err != nil {
+			return err
+		}
+		if content.Media.UploadID != 0 {
+			upload, err := s.getMediaUpload(ctx, content.Media.UploadID)
+			if err != nil {
+				return err
+			}
+			content.Media.Type = upload.Type
+			content.Media.FileName = upload.FileName
+		}
+	}
+
+	if err := validateMessageTemplate(content.Message, limit); err != nil {
+		return err
+	}
+	for language, message := range content.Messages {
+		if err := validateMessageTemplate(message, limit); err != nil {
+			return fmt.Errorf("%s: %w", language, err)
+		}
+	}
+
+	return validateButtons(content.Buttons)
+}
+
+// columns - значения колонок message, messages, fallback_language, media, buttons
+func (c BroadcastContent) columns() ([]interface{}, error) {
+	messages, err := nullableJSON("messages", c.Messages, len(c.Messages) == 0)
+	if err != nil {
+		return nil, err
+	}
+	media, err := nullableJSON("media", c.Media, c.Media == nil)
+	if err != nil {
+		return nil, err
+	}
+	buttons, err := nullableJSON("buttons", c.Buttons, len(c.Buttons) == 0)
+	if err != nil {
+		return nil, err
+	}
+	return []interface{}{c.Message, messages, c.FallbackLanguage, media, buttons}, nil
+}
+
+// nullableJSON - значение для колонки JSONB; пустое сохраняется как NULL
+func nullableJSON(name string, value interface{}, empty bool) (*string, error) {
+	if empty {
+		return nil, nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal %s: %w", name, err)
+	}
+	result := string(data)
+	return &result, nil
+}
+
+// contentRow - приемник для колонок message, messages, fallback_language, media, buttons
+type contentRow struct {
+	content                  *BroadcastContent
+	messages, media, buttons []byte
+}
+
+func scanContent(content *BroadcastContent) *contentRow {
+	return &contentRow{content: content}
+}
+
+// dest - указатели для Scan в порядке колонок
+func (r *contentRow) dest() []interface{} {
+	return []interface{}{&r.content.Message, &r.messages, &r.content.FallbackLanguage, &r.media, &r.buttons}
+}
+
+// parse - разбирает JSONB колонки после Scan
+func (r *contentRow) parse() error {
+	for _, field := range []struct {
+		name string
+		data []byte
+		dst  interface{}
+	}{
+		{"messages", r.messages, &r.content.Messages},
+		{"media", r.media, &r.content.Media},
+		{"buttons", r.buttons, &r.content.Buttons},
+	} {
+		if len(field.data) == 0 {
+			continue
+		}
+		if err := json.Unmarshal(field.data, field.dst); err != nil {
+			return fmt.Errorf("failed to parse broadcast %s: %w", field.name, err)
+		}
+	}
+	return nil
+}
+
+// broadcastContent - содержимое рассылки в воркере. Загруженный через панель файл читается из БД один раз,
+// а после первой успешной отправки вместо него используется file_id.
+type broadcastContent struct {
+	BroadcastContent
+
+	upload    *MediaUpload
+	data      []byte
+	templates map[string]*template.Template // разобранные тексты по исходному тексту
+}
+
+// sendBroadcastContent - отправляет содержимое рассылки клиенту на его языке и с его данными, возвращает ID сообщения
+func (s *Server) sendBroadcastContent(ctx context.Context, customer *Customer, content *broadcastContent) (int64, error) {
+	chatID := customer.TelegramID
+	text, err := content.render(customer)
+	if err != nil {
+		return 0, err
+	}
+	markup := buttonsMarkup(content.Buttons)
+	if content.Media == nil {
+		return s.telegram.SendMessage(ctx, chatID, text, markup)
+	}
+
+	media := TelegramMedia{Type: content.Media.Type, Source: content.Media.Source}
+	if content.Media.UploadID != 0 {
+		if content.upload == nil {
+			upload, data, err := s.loadMediaUpload(ctx, content.Media.UploadID)
+			if err != nil {
+				return 0, err
+			}
+			content.upload, content.data = upload, data
+		}
+		if content.upload.FileID != "" {
+			media.Source = content.upload.FileID
+		} else {
+			media.FileName, media.Data = content.upload.FileName, content.data
+		}
+	}
+
+	message, err := s.telegram.SendMedia(ctx, chatID, media, text, markup)
+	if err != nil {
+		return 0, err
+	}
+
+	// Файл загружен в Telegram: дальше отправляем по file_id
+	if media.Data != nil {
+		if fileID := message.fileID(); fileID != "" {
+			content.upload.FileID, content.data = fileID, nil
+			if err := s.saveMediaFileID(context.WithoutCancel(ctx), content.upload.ID, fileID); err != nil {
+				slog.Error("Failed to save media file_id", "upload_id", content.upload.ID, "error", err)
+			}
+		}
+	}
+	return message.MessageID, nil
+}
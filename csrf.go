@@ -0,0 +1,61 @@
+package main
+
+import (
+	"crypto/subtle"
+	"log"
+	"mime"
+	"net/http"
+)
+
+// CSRF защита по схеме synchronizer token: токен выдается вместе с сессией при входе,
+// хранится в admin_session и встраивается в страницу панели. Любой изменяющий запрос
+// должен вернуть его в заголовке X-CSRF-Token (AJAX) или в поле csrf_token (HTML формы).
+const (
+	csrfHeaderName = "X-CSRF-Token"
+	csrfFormField  = "csrf_token"
+)
+
+// csrfSafeMethod - методы, которые ничего не меняют и не требуют токена
+func csrfSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// validCSRFToken - совпадает ли токен запроса с токеном сессии
+func validCSRFToken(r *http.Request, session *Session) bool {
+	if session.CSRFToken == "" {
+		return false
+	}
+
+	token := r.Header.Get(csrfHeaderName)
+	if token == "" && isURLEncodedForm(r) {
+		token = r.PostFormValue(csrfFormField)
+	}
+
+	return subtle.ConstantTimeCompare([]byte(token), []byte(session.CSRFToken)) == 1
+}
+
+// isURLEncodedForm - поле csrf_token ищется только в обычной HTML форме: multipart (загрузка медиа)
+// передает токен в заголовке, и его тело не должно разбираться до проверки токена
+func isURLEncodedForm(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "application/x-www-form-urlencoded"
+}
+
+// checkCSRF - проверяет токен у изменяющих запросов и сам отвечает 403, если он не подошел
+func checkCSRF(w http.ResponseWriter, r *http.Request, session *Session) bool {
+	if csrfSafeMethod(r.Method) || validCSRFToken(r, session) {
+		return true
+	}
+
+	log.Printf("⛔ Отклонен %s %s от %s: неверный CSRF токен", r.Method, r.URL.Path, session.Username)
+	if isAPIRequest(r) {
+		writeJSONError(w, http.StatusForbidden, "Неверный CSRF токен, обновите страницу")
+	} else {
+		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+	}
+	return false
+}
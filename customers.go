@@ -0,0 +1,428 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	customersDefaultLimit = 50
+	customersMaxLimit     = 200
+)
+
+// Статусы подписки клиента для фильтра
+const (
+	CustomerStatusActive  = "active"
+	CustomerStatusExpired = "expired"
+	CustomerStatusNever   = "never"
+)
+
+// Фильтр по пользователям, заблокировавшим бота
+const (
+	CustomerBlockedExclude = "exclude"
+	CustomerBlockedOnly    = "only"
+)
+
+// customerSortColumns - допустимые поля сортировки и выражения для них.
+// expire_at может быть NULL, поэтому сортируем по COALESCE, чтобы курсор всегда был сравним.
+var customerSortColumns = map[string]struct {
+	expr     string
+	castType string
+}{
+	"created_at":  {"created_at", "timestamptz"},
+	"expire_at":   {"COALESCE(expire_at, '-infinity'::timestamptz)", "timestamptz"},
+	"telegram_id": {"telegram_id", "bigint"},
+}
+
+// CustomerFilter - условия выборки клиентов, общие для списка и других выборок по клиентам
+type CustomerFilter struct {
+	Search      string // префикс Telegram ID
+	Language    string
+	Status      string // active, expired, never или пусто
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	ExpireFrom  time.Time
+	ExpireTo    time.Time
+	TelegramIDs []int64 // nil - без ограничения по списку
+	Blocked     string  // exclude, only или пусто
+}
+
+// customerFilterWhere - условие WHERE для CustomerFilter, параметры $1-$9 берутся из CustomerFilter.args
+const customerFilterWhere = `($1::text = '' OR language = $1)
+	  AND ($2::text = ''
+	       OR ($2 = 'active' AND expire_at > now())
+	       OR ($2 = 'expired' AND expire_at <= now())
+	       OR ($2 = 'never' AND expire_at IS NULL))
+	  AND ($3::text = '' OR telegram_id::text LIKE $3 || '%')
+	  AND ($4::timestamptz IS NULL OR created_at >= $4)
+	  AND ($5::timestamptz IS NULL OR created_at < $5)
+	  AND ($6::timestamptz IS NULL OR expire_at >= $6)
+	  AND ($7::timestamptz IS NULL OR expire_at < $7)
+	  AND ($8::bigint[] IS NULL OR telegram_id = ANY($8))
+	  AND ($9::text = ''
+	       OR ($9 = 'exclude' AND NOT EXISTS (SELECT 1 FROM admin_blocked_user AS bu WHERE bu.telegram_id = customer.telegram_id))
+	       OR ($9 = 'only' AND EXISTS (SELECT 1 FROM admin_blocked_user AS bu WHERE bu.telegram_id = customer.telegram_id)))`
+
+// customerBlockedAtColumn - когда клиент заблокировал бота (NULL - не блокировал)
+const customerBlockedAtColumn = `(SELECT blocked_at FROM admin_blocked_user AS bu WHERE bu.telegram_id = customer.telegram_id)`
+
+// args - параметры для customerFilterWhere
+func (f CustomerFilter) args() []interface{} {
+	return []interface{}{
+		f.Language,
+		f.Status,
+		f.Search,
+		optionalTime(f.CreatedFrom),
+		optionalTime(f.CreatedTo),
+		optionalTime(f.ExpireFrom),
+		optionalTime(f.ExpireTo),
+		f.TelegramIDs,
+		f.Blocked,
+	}
+}
+
+// optionalTime - NULL для незаданного времени
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// CustomerPage - параметры постраничной выборки: сортировка и курсор
+type CustomerPage struct {
+	Sort   string
+	Desc   bool
+	Cursor string
+	Limit  int
+}
+
+type CustomersResponse struct {
+	Success    bool       `json:"success"`
+	Customers  []Customer `json:"customers,omitempty"`
+	Total      int64      `json:"total"`
+	NextCursor string     `json:"next_cursor,omitempty"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// customerCursor - позиция в списке: сортировка, для которой выдан курсор, значение поля сортировки
+// последней строки и её ID
+type customerCursor struct {
+	Sort  string
+	Desc  bool
+	Value string
+	ID    int64
+}
+
+// encodeCustomerCursor - курсор: поле и порядок сортировки, значение поля последней строки и её ID
+func encodeCustomerCursor(c customerCursor) string {
+	order := "asc"
+	if c.Desc {
+		order = "desc"
+	}
+	raw := c.Sort + "|" + order + "|" + c.Value + "|" + strconv.FormatInt(c.ID, 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeCustomerCursor - разбирает курсор, выданный encodeCustomerCursor
+func decodeCustomerCursor(cursor string) (customerCursor, error) {
+	var c customerCursor
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return c, fmt.Errorf("invalid cursor")
+	}
+
+	parts := strings.SplitN(string(raw), "|", 3)
+	if len(parts) != 3 {
+		return c, fmt.Errorf("invalid cursor")
+	}
+	c.Sort = parts[0]
+	switch parts[1] {
+	case "asc":
+	case "desc":
+		c.Desc = true
+	default:
+		return c, fmt.Errorf("invalid cursor")
+	}
+
+	rest := parts[2]
+	idx := strings.LastIndex(rest, "|")
+	if idx < 0 {
+		return c, fmt.Errorf("invalid cursor")
+	}
+
+	c.ID, err = strconv.ParseInt(rest[idx+1:], 10, 64)
+	if err != nil {
+		return c, fmt.Errorf("invalid cursor")
+	}
+	c.Value = rest[:idx]
+
+	return c, nil
+}
+
+// customerCursorTimeLayouts - timestamptz::text в DateStyle ISO; смещение пояса бывает +03, +05:30 и +02:30:17
+var customerCursorTimeLayouts = []string{
+	"2006-01-02 15:04:05.999999-07",
+	"2006-01-02 15:04:05.999999-07:00",
+	"2006-01-02 15:04:05.999999-07:00:00",
+}
+
+// validCustomerCursorValue - значение из курсора должно разбираться как тип поля сортировки
+func validCustomerCursorValue(castType, value string) bool {
+	switch castType {
+	case "bigint":
+		_, err := strconv.ParseInt(value, 10, 64)
+		return err == nil
+	case "timestamptz":
+		if value == "-infinity" || value == "infinity" {
+			return true
+		}
+		for _, layout := range customerCursorTimeLayouts {
+			if _, err := time.Parse(layout, value); err == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseCustomerCursor - курсор страницы: выдан для той же сортировки, значение подходит по типу полю.
+// nil - первая страница
+func parseCustomerCursor(page CustomerPage) (*customerCursor, error) {
+	if page.Cursor == "" {
+		return nil, nil
+	}
+
+	cursor, err := decodeCustomerCursor(page.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	if cursor.Sort != page.Sort || cursor.Desc != page.Desc {
+		return nil, fmt.Errorf("cursor was issued for another sort, request the first page again")
+	}
+	if !validCustomerCursorValue(customerSortColumns[page.Sort].castType, cursor.Value) {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	return &cursor, nil
+}
+
+// listCustomers - страница клиентов по фильтру и курсор следующей страницы
+func (s *Server) listCustomers(ctx context.Context, filter CustomerFilter, page CustomerPage) ([]Customer, string, error) {
+	column, ok := customerSortColumns[page.Sort]
+	if !ok {
+		return nil, "", fmt.Errorf("unknown sort field %q", page.Sort)
+	}
+
+	direction, comparison := "ASC", ">"
+	if page.Desc {
+		direction, comparison = "DESC", "<"
+	}
+
+	args := filter.args()
+
+	// Keyset пагинация: строки строго после курсора в порядке (поле сортировки, id)
+	cursorCond := "TRUE"
+	cursor, err := parseCustomerCursor(page)
+	if err != nil {
+		return nil, "", err
+	}
+	if cursor != nil {
+		args = append(args, cursor.Value, cursor.ID)
+		cursorCond = fmt.Sprintf("(%s, id) %s ($%d::%s, $%d::bigint)",
+			column.expr, comparison, len(args)-1, column.castType, len(args))
+	}
+
+	args = append(args, page.Limit)
+	query := fmt.Sprintf(`SELECT id, telegram_id, expire_at, created_at, subscription_link, language, %s, (%s)::text
+			  FROM customer
+			  WHERE %s AND %s
+			  ORDER BY %s %s, id %s
+			  LIMIT $%d`,
+		customerBlockedAtColumn, column.expr, customerFilterWhere, cursorCond, column.expr, direction, direction, len(args))
+
+	rows, err := s.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to query customers: %w", err)
+	}
+	defer rows.Close()
+
+	var customers []Customer
+	var lastValue string
+	for rows.Next() {
+		var customer Customer
+		err := rows.Scan(
+			&customer.ID,
+			&customer.TelegramID,
+			&customer.ExpireAt,
+			&customer.CreatedAt,
+			&customer.SubscriptionLink,
+			&customer.Language,
+			&customer.BlockedAt,
+			&lastValue,
+		)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to scan customer: %w", err)
+		}
+		customers = append(customers, customer)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", fmt.Errorf("failed to read customers: %w", err)
+	}
+
+	nextCursor := ""
+	if len(customers) == page.Limit {
+		nextCursor = encodeCustomerCursor(customerCursor{
+			Sort:  page.Sort,
+			Desc:  page.Desc,
+			Value: lastValue,
+			ID:    customers[len(customers)-1].ID,
+		})
+	}
+
+	return customers, nextCursor, nil
+}
+
+// countCustomers - сколько клиентов подходит под фильтр
+func (s *Server) countCustomers(ctx context.Context, filter CustomerFilter) (int64, error) {
+	var total int64
+	err := s.db.QueryRow(ctx, `SELECT COUNT(*) FROM customer WHERE `+customerFilterWhere, filter.args()...).Scan(&total)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count customers: %w", err)
+	}
+	return total, nil
+}
+
+// parseCustomerFilter - фильтр клиентов из query string: q, language, status, blocked, created_from/to, expire_from/to
+func parseCustomerFilter(r *http.Request) (CustomerFilter, error) {
+	q := r.URL.Query()
+	filter := CustomerFilter{
+		Search:   strings.TrimSpace(q.Get("q")),
+		Language: strings.TrimSpace(q.Get("language")),
+		Status:   q.Get("status"),
+		Blocked:  q.Get("blocked"),
+	}
+
+	if filter.Search != "" {
+		if _, err := strconv.ParseUint(filter.Search, 10, 64); err != nil {
+			return filter, fmt.Errorf("search must be a Telegram ID (digits only)")
+		}
+	}
+
+	switch filter.Status {
+	case "", CustomerStatusActive, CustomerStatusExpired, CustomerStatusNever:
+	default:
+		return filter, fmt.Errorf("invalid status %q (available: active, expired, never)", filter.Status)
+	}
+
+	switch filter.Blocked {
+	case "", CustomerBlockedExclude, CustomerBlockedOnly:
+	default:
+		return filter, fmt.Errorf("invalid blocked %q (available: exclude, only)", filter.Blocked)
+	}
+
+	for _, param := range []struct {
+		name string
+		dst  *time.Time
+	}{
+		{"created_from", &filter.CreatedFrom},
+		{"created_to", &filter.CreatedTo},
+		{"expire_from", &filter.ExpireFrom},
+		{"expire_to", &filter.ExpireTo},
+	} {
+		value := q.Get(param.name)
+		if value == "" {
+			continue
+		}
+		t, err := parseFilterTime(value)
+		if err != nil {
+			return filter, fmt.Errorf("invalid %s value %q", param.name, value)
+		}
+		*param.dst = t
+	}
+
+	return filter, nil
+}
+
+// parseCustomerPage - сортировка и курсор из query string: sort, order, cursor, limit
+func parseCustomerPage(r *http.Request) (CustomerPage, error) {
+	q := r.URL.Query()
+	page := CustomerPage{
+		Sort:   q.Get("sort"),
+		Desc:   q.Get("order") != "asc",
+		Cursor: q.Get("cursor"),
+		Limit:  customersDefaultLimit,
+	}
+
+	if page.Sort == "" {
+		page.Sort = "created_at"
+	}
+	if _, ok := customerSortColumns[page.Sort]; !ok {
+		return page, fmt.Errorf("invalid sort %q (available: created_at, expire_at, telegram_id)", page.Sort)
+	}
+
+	if order := q.Get("order"); order != "" && order != "asc" && order != "desc" {
+		return page, fmt.Errorf("invalid order %q (available: asc, desc)", order)
+	}
+
+	if _, err := parseCustomerCursor(page); err != nil {
+		return page, err
+	}
+
+	if value := q.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			return page, fmt.Errorf("invalid limit value %q", value)
+		}
+		page.Limit = min(limit, customersMaxLimit)
+	}
+
+	return page, nil
+}
+
+// customersHandler - список клиентов бота с поиском, фильтрами, сортировкой и курсорной пагинацией
+func (s *Server) customersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	filter, err := parseCustomerFilter(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	page, err := parseCustomerPage(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	customers, nextCursor, err := s.listCustomers(r.Context(), filter, page)
+
+	var total int64
+	if err == nil {
+		total, err = s.countCustomers(r.Context(), filter)
+	}
+
+	response := CustomersResponse{
+		Success:    err == nil,
+		Customers:  customers,
+		Total:      total,
+		NextCursor: nextCursor,
+	}
+
+	if err != nil {
+		slog.Error("Failed to list customers", "error", err)
+		response.Error = err.Error()
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestCustomerCursorRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		cursor customerCursor
+	}{
+		{"timestamp", customerCursor{Sort: "created_at", Desc: true, Value: "2024-05-01 12:30:00.123456+00", ID: 42}},
+		{"never expires", customerCursor{Sort: "expire_at", Value: "-infinity", ID: 7}},
+		{"telegram id", customerCursor{Sort: "telegram_id", Value: "123456789", ID: 1}},
+		{"separator inside the value", customerCursor{Sort: "created_at", Value: "a|b|c", ID: 3}},
+		{"empty value", customerCursor{Sort: "created_at", Desc: true, Value: "", ID: 5}},
+		{"max id", customerCursor{Sort: "telegram_id", Value: "x", ID: 1<<63 - 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeCustomerCursor(encodeCustomerCursor(tt.cursor))
+			if err != nil {
+				t.Fatalf("decodeCustomerCursor: %v", err)
+			}
+			if got != tt.cursor {
+				t.Errorf("got %+v, want %+v", got, tt.cursor)
+			}
+		})
+	}
+}
+
+// При равных значениях поля сортировки страницы разделяет ID, поэтому курсоры строк-дублей различаются
+func TestCustomerCursorTies(t *testing.T) {
+	const value = "2024-05-01 00:00:00+00"
+
+	first := encodeCustomerCursor(customerCursor{Sort: "created_at", Value: value, ID: 10})
+	second := encodeCustomerCursor(customerCursor{Sort: "created_at", Value: value, ID: 11})
+	if first == second {
+		t.Fatal("cursors for rows with equal sort values must differ")
+	}
+
+	for cursor, wantID := range map[string]int64{first: 10, second: 11} {
+		got, err := decodeCustomerCursor(cursor)
+		if err != nil {
+			t.Fatalf("decodeCustomerCursor(%q): %v", cursor, err)
+		}
+		if got.Value != value || got.ID != wantID {
+			t.Errorf("decodeCustomerCursor(%q) = (%q, %d), want (%q, %d)", cursor, got.Value, got.ID, value, wantID)
+		}
+	}
+}
+
+func TestDecodeCustomerCursorTampered(t *testing.T) {
+	encode := func(raw string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(raw))
+	}
+
+	tests := []struct {
+		name   string
+		cursor string
+	}{
+		{"not base64", "%%%"},
+		{"standard base64 with padding", base64.StdEncoding.EncodeToString([]byte("created_at|asc|value|12"))},
+		{"no separator", encode("created_at|asc|value12")},
+		{"empty id", encode("created_at|asc|value|")},
+		{"id is not a number", encode("created_at|asc|value|abc")},
+		{"id with spaces", encode("created_at|asc|value| 12")},
+		{"id overflows int64", encode("created_at|asc|value|99999999999999999999")},
+		{"id swapped with value", encode("created_at|asc|12|value")},
+		{"cursor without sort", encode("value|12")},
+		{"unknown order", encode("created_at|up|value|12")},
+		{"truncated cursor", encodeCustomerCursor(customerCursor{Sort: "created_at", Value: "2024-05-01", ID: 12})[:6]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := decodeCustomerCursor(tt.cursor); err == nil {
+				t.Errorf("decodeCustomerCursor(%q) = %+v, want an error", tt.cursor, got)
+			}
+		})
+	}
+}
+
+// Курсор принимается только для той сортировки, для которой выдан, и только со значением типа поля сортировки
+func TestParseCustomerPageCursor(t *testing.T) {
+	encode := func(raw string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(raw))
+	}
+	createdDesc := encodeCustomerCursor(customerCursor{Sort: "created_at", Desc: true, Value: "2024-05-01 12:30:00.123456+00", ID: 42})
+
+	tests := []struct {
+		name    string
+		query   url.Values
+		wantErr bool
+	}{
+		{"first page", url.Values{"sort": {"expire_at"}}, false},
+		{"same sort", url.Values{"sort": {"created_at"}, "order": {"desc"}, "cursor": {createdDesc}}, false},
+		{"default sort and order", url.Values{"cursor": {createdDesc}}, false},
+		{"sort switched", url.Values{"sort": {"telegram_id"}, "cursor": {createdDesc}}, true},
+		{"order switched", url.Values{"sort": {"created_at"}, "order": {"asc"}, "cursor": {createdDesc}}, true},
+		{"expire_at never expires", url.Values{"sort": {"expire_at"}, "cursor": {encode("expire_at|desc|-infinity|7")}}, false},
+		{"timestamp with a half-hour zone", url.Values{"cursor": {encode("created_at|desc|2024-05-01 18:00:00+05:30|7")}}, false},
+		{"telegram_id", url.Values{"sort": {"telegram_id"}, "cursor": {encode("telegram_id|desc|123456789|7")}}, false},
+		{"hand-edited timestamp", url.Values{"cursor": {encode("created_at|desc|yesterday|7")}}, true},
+		{"SQL in the value", url.Values{"cursor": {encode("created_at|desc|2024-05-01' OR '1'='1|7")}}, true},
+		{"timestamp for telegram_id", url.Values{"sort": {"telegram_id"}, "cursor": {encode("telegram_id|desc|2024-05-01 12:30:00+00|7")}}, true},
+		{"text for telegram_id", url.Values{"sort": {"telegram_id"}, "cursor": {encode("telegram_id|desc|abc|7")}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/admin/customers?"+tt.query.Encode(), nil)
+			_, err := parseCustomerPage(r)
+			if gotErr := err != nil; gotErr != tt.wantErr {
+				t.Errorf("parseCustomerPage error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// Курсор, выданный для другой сортировки, отклоняется обработчиком с 400 до запроса к базе
+func TestCustomersHandlerRejectsStaleCursor(t *testing.T) {
+	cursor := encodeCustomerCursor(customerCursor{Sort: "created_at", Desc: true, Value: "2024-05-01 12:30:00+00", ID: 42})
+	r := httptest.NewRequest(http.MethodGet, "/admin/customers?sort=expire_at&cursor="+cursor, nil)
+	w := httptest.NewRecorder()
+
+	(&Server{}).customersHandler(w, r)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", w.Code)
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fakeBotAPIMaxBody - ограничение тела запроса к fake-bot-api (как у загрузки файлов в Bot API)
+const fakeBotAPIMaxBody = 50 << 20
+
+// fakeBotAPIDescriptions - тексты ошибок Telegram для внедряемых ответов
+var fakeBotAPIDescriptions = map[int]string{
+	http.StatusBadRequest:          "Bad Request: chat not found",
+	http.StatusForbidden:           "Forbidden: bot was blocked by the user",
+	http.StatusTooManyRequests:     "Too Many Requests: retry after %d",
+	http.StatusInternalServerError: "Internal Server Error",
+}
+
+// FakeBotCall - запрос к fake-bot-api. Параметры записываются строками, у файла - имя и размер.
+type FakeBotCall struct {
+	ID     int               `json:"id"`
+	Method string            `json:"method"`
+	ChatID int64             `json:"chat_id"`
+	Params map[string]string `json:"params"`
+	Status int               `json:"status"`
+	At     time.Time         `json:"at"`
+}
+
+// FakeBotFault - внедряемая ошибка: для метода и/или чата (пусто и 0 - для всех) вернуть status
+// следующие count раз (0 - всегда). Для 429 retry_after передается в parameters.
+type FakeBotFault struct {
+	Method      string `json:"method,omitempty"`
+	ChatID      int64  `json:"chat_id,omitempty"`
+	Status      int    `json:"status"`
+	Description string `json:"description,omitempty"`
+	RetryAfter  int    `json:"retry_after,omitempty"`
+	Count       int    `json:"count,omitempty"`
+}
+
+// FakeBotAPI - поддельный Bot API для проверки рассылок: отвечает как Telegram на sendMessage, sendPhoto,
+// sendVideo, sendDocument, editMessageText, editMessageCaption, deleteMessage и getMe, записывает вызовы
+// и по запросу возвращает 403, 429, 500 и другие ошибки. Управление:
+//
+//	GET /calls (?method=) - записанные вызовы, DELETE /calls - очистить
+//	GET /faults, POST /faults (FakeBotFault) - добавить ошибку, DELETE /faults - убрать все
+type FakeBotAPI struct {
+	mu            sync.Mutex
+	calls         []FakeBotCall
+	faults        []*FakeBotFault
+	nextMessageID int64
+}
+
+func newFakeBotAPI() *FakeBotAPI {
+	return &FakeBotAPI{}
+}
+
+// Handler - маршруты Bot API и управления
+func (f *FakeBotAPI) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /{bot}/{method}", f.botMethodHandler)
+	mux.HandleFunc("GET /calls", f.callsHandler)
+	mux.HandleFunc("DELETE /calls", f.resetCallsHandler)
+	mux.HandleFunc("GET /faults", f.faultsHandler)
+	mux.HandleFunc("POST /faults", f.addFaultHandler)
+	mux.HandleFunc("DELETE /faults", f.resetFaultsHandler)
+	return mux
+}
+
+// Calls - копия записанных вызовов
+func (f *FakeBotAPI) Calls() []FakeBotCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]FakeBotCall(nil), f.calls...)
+}
+
+// AddFault - добавляет внедряемую ошибку
+func (f *FakeBotAPI) AddFault(fault FakeBotFault) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.faults = append(f.faults, &fault)
+}
+
+// Reset - забывает вызовы и ошибки
+func (f *FakeBotAPI) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls, f.faults = nil, nil
+}
+
+// record - записывает вызов и выбирает для него ошибку; nil - ответить успешно
+func (f *FakeBotAPI) record(method string, chatID int64, params map[string]string) (*FakeBotFault, int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var fault *FakeBotFault
+	for i, candidate := range f.faults {
+		if (candidate.Method != "" && candidate.Method != method) || (candidate.ChatID != 0 && candidate.ChatID != chatID) {
+			continue
+		}
+		fault = candidate
+		if candidate.Count > 0 {
+			if candidate.Count--; candidate.Count == 0 {
+				f.faults = append(f.faults[:i], f.faults[i+1:]...)
+			}
+		}
+		break
+	}
+
+	call := FakeBotCall{ID: len(f.calls) + 1, Method: method, ChatID: chatID, Params: params, Status: http.StatusOK, At: time.Now()}
+	if fault != nil {
+		call.Status = fault.Status
+	} else {
+		f.nextMessageID++
+	}
+	f.calls = append(f.calls, call)
+	return fault, f.nextMessageID
+}
+
+// botMethodHandler - POST /bot<token>/<method>: параметры JSON, form или multipart, как у настоящего Bot API
+func (f *FakeBotAPI) botMethodHandler(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.PathValue("bot"), "bot") {
+		http.NotFound(w, r)
+		return
+	}
+	method := r.PathValue("method")
+
+	params, err := fakeBotParams(r)
+	if err != nil {
+		writeFakeBotError(w, http.StatusBadRequest, "Bad Request: "+err.Error(), 0)
+		return
+	}
+	chatID, _ := strconv.ParseInt(params["chat_id"], 10, 64)
+
+	fault, messageID := f.record(method, chatID, params)
+	if fault != nil {
+		description := fault.Description
+		if description == "" {
+			description = fakeBotAPIDescriptions[fault.Status]
+			if fault.Status == http.StatusTooManyRequests {
+				description = fmt.Sprintf(description, fault.RetryAfter)
+			}
+		}
+		writeFakeBotError(w, fault.Status, description, fault.RetryAfter)
+		return
+	}
+
+	var result interface{}
+	switch method {
+	case "getMe":
+		result = map[string]interface{}{"id": 1, "is_bot": true, "first_name": "Fake", "username": "fake_bot"}
+	case "sendMessage", "sendPhoto", "sendVideo", "sendDocument":
+		message := map[string]interface{}{
+			"message_id": messageID,
+			"date":       time.Now().Unix(),
+			"chat":       map[string]interface{}{"id": chatID, "type": "private"},
+		}
+		fileID := fmt.Sprintf("fake-file-%d", messageID)
+		switch method {
+		case "sendMessage":
+			message["text"] = params["text"]
+		case "sendPhoto":
+			message["photo"] = []map[string]string{{"file_id": fileID}}
+		case "sendVideo":
+			message["video"] = map[string]string{"file_id": fileID}
+		case "sendDocument":
+			message["document"] = map[string]string{"file_id": fileID}
+		}
+		if caption, ok := params["caption"]; ok {
+			message["caption"] = caption
+		}
+		result = message
+	case "editMessageText", "editMessageCaption", "deleteMessage":
+		result = true
+	default:
+		writeFakeBotError(w, http.StatusNotFound, "Not Found: method not found", 0)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "result": result})
+}
+
+// fakeBotParams - параметры запроса строками; вложенные объекты (reply_markup) остаются JSON
+func fakeBotParams(r *http.Request) (map[string]string, error) {
+	r.Body = http.MaxBytesReader(nil, r.Body, fakeBotAPIMaxBody)
+	params := make(map[string]string)
+
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch contentType {
+	case "application/json":
+		var body map[string]json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+		for key, raw := range body {
+			var value string
+			if json.Unmarshal(raw, &value) != nil {
+				value = string(raw)
+			}
+			params[key] = value
+		}
+	case "multipart/form-data":
+		if err := r.ParseMultipartForm(fakeBotAPIMaxBody); err != nil {
+			return nil, err
+		}
+		for key, values := range r.MultipartForm.Value {
+			params[key] = values[0]
+		}
+		for key, files := range r.MultipartForm.File {
+			params[key] = fmt.Sprintf("%s (%d bytes)", files[0].Filename, files[0].Size)
+		}
+	default:
+		if err := r.ParseForm(); err != nil {
+			return nil, err
+		}
+		for key, values := range r.Form {
+			params[key] = values[0]
+		}
+	}
+	return params, nil
+}
+
+func writeFakeBotError(w http.ResponseWriter, status int, description string, retryAfter int) {
+	response := map[string]interface{}{
+		"ok":          false,
+		"error_code":  status,
+		"description": description,
+	}
+	if retryAfter > 0 {
+		response["parameters"] = map[string]int{"retry_after": retryAfter}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+func (f *FakeBotAPI) callsHandler(w http.ResponseWriter, r *http.Request) {
+	method := r.URL.Query().Get("method")
+	calls := []FakeBotCall{}
+	for _, call := range f.Calls() {
+		if method == "" || call.Method == method {
+			calls = append(calls, call)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(calls)
+}
+
+func (f *FakeBotAPI) resetCallsHandler(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.calls = nil
+	f.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (f *FakeBotAPI) faultsHandler(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	faults := make([]FakeBotFault, 0, len(f.faults))
+	for _, fault := range f.faults {
+		faults = append(faults, *fault)
+	}
+	f.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(faults)
+}
+
+func (f *FakeBotAPI) addFaultHandler(w http.ResponseWriter, r *http.Request) {
+	var fault FakeBotFault
+	if err := json.NewDecoder(r.Body).Decode(&fault); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if fault.Status < 400 || fault.Status > 599 {
+		http.Error(w, "status must be an HTTP error code", http.StatusBadRequest)
+		return
+	}
+	f.AddFault(fault)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (f *FakeBotAPI) resetFaultsHandler(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.faults = nil
+	f.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// runFakeBotAPICommand - запускает fake-bot-api: fake-bot-api [-addr :8090]. Панель направляется на него
+// через TELEGRAM_API_URL=http://localhost:8090
+func runFakeBotAPICommand(args []string) error {
+	fs := flag.NewFlagSet("fake-bot-api", flag.ContinueOnError)
+	addr := fs.String("addr", ":8090", "адрес, на котором слушает fake-bot-api")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	log.Printf("🤖 Fake Bot API слушает %s", *addr)
+	return http.ListenAndServe(*addr, newFakeBotAPI().Handler())
+}
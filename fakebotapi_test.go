@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// fakeBotClient - FakeBotAPI на httptest и клиент к нему с поддельными часами
+func fakeBotClient(t *testing.T) (*FakeBotAPI, *TelegramClient, *fakeClock) {
+	t.Helper()
+
+	api := newFakeBotAPI()
+	server := httptest.NewServer(api.Handler())
+	t.Cleanup(server.Close)
+
+	clk := newFakeClock()
+	return api, newTelegramClientWithClock("token", server.URL, clk.clock()), clk
+}
+
+// callStatuses - статусы ответов на записанные вызовы
+func callStatuses(calls []FakeBotCall) []int {
+	statuses := make([]int, len(calls))
+	for i, call := range calls {
+		statuses[i] = call.Status
+	}
+	return statuses
+}
+
+func TestFakeBotAPISendMessage(t *testing.T) {
+	tests := []struct {
+		name          string
+		faults        []FakeBotFault
+		wantStatuses  []int
+		wantSleeps    []time.Duration
+		wantErrStatus int // 0 - сообщение доставлено
+		wantBlocked   bool
+	}{
+		{
+			name:         "delivered",
+			wantStatuses: []int{http.StatusOK},
+		},
+		{
+			name:         "429 is retried after retry_after",
+			faults:       []FakeBotFault{{Method: "sendMessage", Status: http.StatusTooManyRequests, RetryAfter: 7, Count: 1}},
+			wantStatuses: []int{http.StatusTooManyRequests, http.StatusOK},
+			wantSleeps:   []time.Duration{7 * time.Second},
+		},
+		{
+			name:         "5xx is retried with backoff",
+			faults:       []FakeBotFault{{Method: "sendMessage", Status: http.StatusBadGateway, Count: 2}},
+			wantStatuses: []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusOK},
+			wantSleeps:   []time.Duration{time.Second, 2 * time.Second},
+		},
+		{
+			name:          "blocked by the user",
+			faults:        []FakeBotFault{{ChatID: 42, Status: http.StatusForbidden}},
+			wantStatuses:  []int{http.StatusForbidden},
+			wantErrStatus: http.StatusForbidden,
+			wantBlocked:   true,
+		},
+		{
+			name:          "chat not found",
+			faults:        []FakeBotFault{{Status: http.StatusBadRequest}},
+			wantStatuses:  []int{http.StatusBadRequest},
+			wantErrStatus: http.StatusBadRequest,
+			wantBlocked:   true,
+		},
+		{
+			name:         "fault for another chat",
+			faults:       []FakeBotFault{{ChatID: 7, Status: http.StatusForbidden}},
+			wantStatuses: []int{http.StatusOK},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api, client, clk := fakeBotClient(t)
+			for _, fault := range tt.faults {
+				api.AddFault(fault)
+			}
+
+			messageID, err := client.SendMessage(context.Background(), 42, "Привет", nil)
+
+			var tgErr *TelegramError
+			switch {
+			case tt.wantErrStatus == 0 && err != nil:
+				t.Fatalf("SendMessage: %v", err)
+			case tt.wantErrStatus == 0 && messageID == 0:
+				t.Error("SendMessage returned no message ID")
+			case tt.wantErrStatus != 0 && (!errors.As(err, &tgErr) || tgErr.StatusCode != tt.wantErrStatus):
+				t.Fatalf("SendMessage error = %v, want Telegram error %d", err, tt.wantErrStatus)
+			}
+			if got := isRecipientUnreachable(err); got != tt.wantBlocked {
+				t.Errorf("isRecipientUnreachable = %v, want %v", got, tt.wantBlocked)
+			}
+
+			calls := api.Calls()
+			if got := callStatuses(calls); !reflect.DeepEqual(got, tt.wantStatuses) {
+				t.Errorf("call statuses = %v, want %v", got, tt.wantStatuses)
+			}
+			for _, call := range calls {
+				if call.Method != "sendMessage" || call.ChatID != 42 || call.Params["text"] != "Привет" {
+					t.Errorf("recorded call = %+v, want sendMessage to chat 42", call)
+				}
+			}
+			if got := clk.Sleeps(); !reflect.DeepEqual(got, tt.wantSleeps) {
+				t.Errorf("sleeps = %v, want %v", got, tt.wantSleeps)
+			}
+		})
+	}
+}
+
+func TestFakeBotAPIReset(t *testing.T) {
+	api, client, clk := fakeBotClient(t)
+	ctx := context.Background()
+
+	api.AddFault(FakeBotFault{Status: http.StatusForbidden})
+	if _, err := client.SendMessage(ctx, 1, "x", nil); !isRecipientUnreachable(err) {
+		t.Fatalf("SendMessage error = %v, want unreachable recipient", err)
+	}
+
+	api.Reset()
+	if calls := api.Calls(); len(calls) != 0 {
+		t.Fatalf("calls after Reset = %d, want 0", len(calls))
+	}
+
+	clk.Advance(time.Second)
+	if _, err := client.SendMessage(ctx, 1, "x", nil); err != nil {
+		t.Fatalf("SendMessage after Reset: %v", err)
+	}
+	if got := callStatuses(api.Calls()); !reflect.DeepEqual(got, []int{http.StatusOK}) {
+		t.Errorf("call statuses after Reset = %v, want [200]", got)
+	}
+}
+
+// Воркер рассылки против FakeBotAPI: заблокировавший бота получатель отмечается blocked и попадает
+// в admin_blocked_user, остальные получают сообщение
+func TestBroadcastWorkerMarksBlockedRecipient(t *testing.T) {
+	db := testDB(t)
+	api, client, _ := fakeBotClient(t)
+	s := &Server{db: db, telegram: client, broadcastWake: make(chan struct{}, 1)}
+	ctx := context.Background()
+
+	const delivered, blocked = 100, 200
+	api.AddFault(FakeBotFault{ChatID: blocked, Status: http.StatusForbidden})
+
+	var id int64
+	err := db.QueryRow(ctx,
+		`INSERT INTO admin_broadcast (message, status, total) VALUES ('Привет', $1, 2) RETURNING id`,
+		BroadcastQueued,
+	).Scan(&id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, telegramID := range []int64{delivered, blocked} {
+		_, err := db.Exec(ctx,
+			`INSERT INTO admin_broadcast_recipient (broadcast_id, telegram_id, language) VALUES ($1, $2, 'ru')`,
+			id, telegramID,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	processed, err := s.processNextBroadcast(ctx)
+	if err != nil || !processed {
+		t.Fatalf("processNextBroadcast = (%v, %v), want (true, nil)", processed, err)
+	}
+
+	wantStatuses := map[int64]string{delivered: RecipientSent, blocked: RecipientBlocked}
+	for telegramID, want := range wantStatuses {
+		var status string
+		err := db.QueryRow(ctx,
+			`SELECT status FROM admin_broadcast_recipient WHERE broadcast_id = $1 AND telegram_id = $2`,
+			id, telegramID,
+		).Scan(&status)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status != want {
+			t.Errorf("recipient %d status = %q, want %q", telegramID, status, want)
+		}
+	}
+
+	var blockedIDs []int64
+	var broadcastIDs []int64
+	rows, err := db.Query(ctx, `SELECT telegram_id, broadcast_id FROM admin_blocked_user ORDER BY telegram_id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+		var telegramID, broadcastID int64
+		if err := rows.Scan(&telegramID, &broadcastID); err != nil {
+			t.Fatal(err)
+		}
+		blockedIDs = append(blockedIDs, telegramID)
+		broadcastIDs = append(broadcastIDs, broadcastID)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(blockedIDs, []int64{blocked}) || !reflect.DeepEqual(broadcastIDs, []int64{id}) {
+		t.Errorf("admin_blocked_user = %v (broadcasts %v), want [%d] from broadcast %d", blockedIDs, broadcastIDs, blocked, id)
+	}
+
+	var status string
+	if err := db.QueryRow(ctx, `SELECT status FROM admin_broadcast WHERE id = $1`, id).Scan(&status); err != nil {
+		t.Fatal(err)
+	}
+	if status != BroadcastCompleted {
+		t.Errorf("broadcast status = %q, want %q", status, BroadcastCompleted)
+	}
+
+	sent := 0
+	for _, call := range api.Calls() {
+		if call.Method == "sendMessage" && call.Params["chat_id"] == strconv.Itoa(delivered) && call.Status == http.StatusOK {
+			sent++
+		}
+	}
+	if sent != 1 {
+		t.Errorf("delivered messages to %d = %d, want 1", delivered, sent)
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	broadcastRecipientsLimit    = 100
+	broadcastRecipientsMaxLimit = 1000
+)
+
+// BroadcastFilter - фильтр истории рассылок
+type BroadcastFilter struct {
+	Status string
+	Author string
+	From   time.Time
+	To     time.Time
+	Before int64 // курсор: рассылки с ID меньше этого
+	Limit  int
+}
+
+// parseBroadcastFilter - фильтр истории из query string: status, created_by, from, to, before, limit
+func parseBroadcastFilter(r *http.Request) (BroadcastFilter, error) {
+	q := r.URL.Query()
+	filter := BroadcastFilter{
+		Status: strings.TrimSpace(q.Get("status")),
+		Author: strings.TrimSpace(q.Get("created_by")),
+		Limit:  broadcastsListLimit,
+	}
+
+	for _, param := range []struct {
+		name string
+		dst  *time.Time
+	}{{"from", &filter.From}, {"to", &filter.To}} {
+		value := q.Get(param.name)
+		if value == "" {
+			continue
+		}
+		t, err := parseFilterTime(value)
+		if err != nil {
+			return filter, fmt.Errorf("invalid %s value %q", param.name, value)
+		}
+		*param.dst = t
+	}
+
+	if value := q.Get("before"); value != "" {
+		before, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || before < 0 {
+			return filter, fmt.Errorf("invalid before value %q", value)
+		}
+		filter.Before = before
+	}
+
+	if value := q.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			return filter, fmt.Errorf("invalid limit value %q", value)
+		}
+		filter.Limit = min(limit, broadcastsMaxLimit)
+	}
+
+	return filter, nil
+}
+
+// BroadcastRecipient - состояние доставки рассылки одному получателю; error - ответ Telegram
+type BroadcastRecipient struct {
+	TelegramID int64      `json:"telegram_id"`
+	Language   string     `json:"language"`
+	Status     string     `json:"status"`
+	Error      string     `json:"error,omitempty"`
+	SentAt     *time.Time `json:"sent_at"`
+}
+
+type BroadcastRecipientsResponse struct {
+	Success    bool                 `json:"success"`
+	Recipients []BroadcastRecipient `json:"recipients,omitempty"`
+	NextAfter  int64                `json:"next_after,omitempty"` // курсор следующей страницы
+	Error      string               `json:"error,omitempty"`
+}
+
+// listBroadcastRecipients - получатели рассылки по Telegram ID; status - только в этом статусе,
+// after - курсор, limit 0 - все получатели
+func (s *Server) listBroadcastRecipients(ctx context.Context, id int64, status string, after int64, limit int, fn func(BroadcastRecipient) error) error {
+	query := `SELECT telegram_id, language, status, error, sent_at
+			  FROM admin_broadcast_recipient
+			  WHERE broadcast_id = $1
+			    AND ($2::text = '' OR status = $2)
+			    AND telegram_id > $3
+			  ORDER BY telegram_id`
+	args := []interface{}{id, status, after}
+	if limit > 0 {
+		query += ` LIMIT $4`
+		args = append(args, limit)
+	}
+
+	rows, err := s.db.Query(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to query broadcast recipients: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var recipient BroadcastRecipient
+		if err := rows.Scan(&recipient.TelegramID, &recipient.Language, &recipient.Status, &recipient.Error, &recipient.SentAt); err != nil {
+			return fmt.Errorf("failed to scan broadcast recipient: %w", err)
+		}
+		if err := fn(recipient); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// broadcastRecipientsParams - ID рассылки из пути и фильтр получателей из query string: status, after, limit
+func broadcastRecipientsParams(r *http.Request) (int64, string, int64, int, error) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id <= 0 {
+		return 0, "", 0, 0, errors.New("Invalid broadcast ID")
+	}
+
+	q := r.URL.Query()
+	status := strings.TrimSpace(q.Get("status"))
+
+	var after int64
+	if value := q.Get("after"); value != "" {
+		if after, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return 0, "", 0, 0, fmt.Errorf("invalid after value %q", value)
+		}
+	}
+
+	limit := broadcastRecipientsLimit
+	if value := q.Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit <= 0 {
+			return 0, "", 0, 0, fmt.Errorf("invalid limit value %q", value)
+		}
+		limit = min(limit, broadcastRecipientsMaxLimit)
+	}
+
+	return id, status, after, limit, nil
+}
+
+// broadcastRecipientsHandler - GET /admin/broadcasts/{id}/recipients: отчет о доставке по получателям
+func (s *Server) broadcastRecipientsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, status, after, limit, err := broadcastRecipientsParams(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var recipients []BroadcastRecipient
+	err = s.listBroadcastRecipients(r.Context(), id, status, after, limit, func(recipient BroadcastRecipient) error {
+		recipients = append(recipients, recipient)
+		return nil
+	})
+
+	response := BroadcastRecipientsResponse{
+		Success:    err == nil,
+		Recipients: recipients,
+	}
+	if len(recipients) == limit {
+		response.NextAfter = recipients[len(recipients)-1].TelegramID
+	}
+
+	if err != nil {
+		slog.Error("Failed to list broadcast recipients", "broadcast_id", id, "error", err)
+		response.Error = err.Error()
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// broadcastExportHandler - GET /admin/broadcasts/{id}/recipients.csv: отчет о доставке в CSV (фильтр status)
+func (s *Server) broadcastExportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, status, _, _, err := broadcastRecipientsParams(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Несуществующая рассылка - 404, а не пустой файл
+	if _, err := s.getBroadcast(r.Context(), id); err != nil {
+		if errors.Is(err, errBroadcastNotFound) {
+			writeJSONError(w, http.StatusNotFound, err.Error())
+		} else {
+			slog.Error("Failed to load broadcast", "broadcast_id", id, "error", err)
+			writeJSONError(w, http.StatusInternalServerError, "Failed to load broadcast")
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="broadcast-%d-recipients.csv"`, id))
+
+	out := csv.NewWriter(w)
+	out.Write([]string{"telegram_id", "language", "status", "error", "sent_at"})
+
+	err = s.listBroadcastRecipients(r.Context(), id, status, 0, 0, func(recipient BroadcastRecipient) error {
+		sentAt := ""
+		if recipient.SentAt != nil {
+			sentAt = recipient.SentAt.UTC().Format(time.RFC3339)
+		}
+		return out.Write([]string{
+			strconv.FormatInt(recipient.TelegramID, 10),
+			recipient.Language,
+			recipient.Status,
+			recipient.Error,
+			sentAt,
+		})
+	})
+	out.Flush()
+
+	// Заголовки уже отправлены, поэтому ошибку можно только записать в лог
+	if err == nil {
+		err = out.Error()
+	}
+	if err != nil {
+		slog.Error("Failed to export broadcast recipients", "broadcast_id", id, "error", err)
+	}
+}
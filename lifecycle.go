@@ -0,0 +1,656 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jackc/pgx/v4"
+)
+
+const (
+	lifecycleInterval       = time.Minute
+	lifecycleBatchSize      = 100
+	lifecycleMaxOffsetDays  = 365
+	lifecycleNameMaxLength  = 100
+	lifecycleRulesListLimit = 200
+	// lifecycleMaxDelay - напоминание, которое не успели отправить за это время (панель была выключена
+	// или правило только что создано), уже не отправляется: клиенту незачем получать его с опозданием
+	lifecycleMaxDelay = 24 * time.Hour
+)
+
+var (
+	errLifecycleRuleNotFound = errors.New("lifecycle rule not found")
+	errLifecycleDraft        = errors.New("черновик нельзя отправлять автоматически, сохраните его как шаблон")
+	errMessageTemplateInUse  = errors.New("шаблон используется в автоматических сообщениях")
+	errLifecycleNotTested    = errors.New("сначала отправьте этот шаблон тестом администраторам: без теста правило нельзя включить")
+)
+
+// LifecycleRule - автоматическое сообщение: шаблон отправляется клиенту через offset_days дней после окончания
+// подписки (отрицательное значение - до окончания, 0 - в момент окончания). Счетчики - по всем срокам подписки.
+type LifecycleRule struct {
+	ID           int64      `json:"id"`
+	Name         string     `json:"name"`
+	TemplateID   int64      `json:"template_id"`
+	TemplateName string     `json:"template_name"`
+	OffsetDays   int        `json:"offset_days"`
+	Enabled      bool       `json:"enabled"`
+	CreatedBy    string     `json:"created_by"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedBy    string     `json:"updated_by"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+	Sent         int        `json:"sent"`
+	Failed       int        `json:"failed"`
+	Blocked      int        `json:"blocked"`
+	LastSentAt   *time.Time `json:"last_sent_at"`
+}
+
+type LifecycleRuleRequest struct {
+	Name       string `json:"name"`
+	TemplateID int64  `json:"template_id"`
+	OffsetDays int    `json:"offset_days"`
+	Enabled    bool   `json:"enabled"`
+}
+
+type LifecycleRulesResponse struct {
+	Success bool            `json:"success"`
+	Rules   []LifecycleRule `json:"rules,omitempty"`
+	Error   string          `json:"error,omitempty"`
+}
+
+// validate - проверка правила без обращения к БД
+func (req *LifecycleRuleRequest) validate() error {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if utf8.RuneCountInString(req.Name) > lifecycleNameMaxLength {
+		return fmt.Errorf("name is longer than %d characters", lifecycleNameMaxLength)
+	}
+	if req.OffsetDays < -lifecycleMaxOffsetDays || req.OffsetDays > lifecycleMaxOffsetDays {
+		return fmt.Errorf("offset_days must be within -%d..%d", lifecycleMaxOffsetDays, lifecycleMaxOffsetDays)
+	}
+	return nil
+}
+
+// checkLifecycleTemplate - отправлять автоматически можно только шаблон из библиотеки, не черновик, а при
+// BROADCAST_REQUIRE_TEST=true включенное правило - только с протестированным содержимым шаблона.
+// Шаблон остается заблокированным FOR SHARE до конца транзакции, чтобы его не удалили и не сделали черновиком,
+// пока правило сохраняется
+func checkLifecycleTemplate(ctx context.Context, q pgxQuerier, id int64, enabled bool) error {
+	var kind string
+	var content BroadcastContent
+	row := scanContent(&content)
+	err := q.QueryRow(ctx,
+		`SELECT kind, message, messages, fallback_language, media, buttons
+		 FROM admin_message_template
+		 WHERE id = $1
+		 FOR SHARE`,
+		id,
+	).Scan(append([]interface{}{&kind}, row.dest()...)...)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return errMessageTemplateNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load message template %d: %w", id, err)
+	}
+	if kind != MessageTemplateKind {
+		return errLifecycleDraft
+	}
+
+	if !enabled || !broadcastTestRequired() {
+		return nil
+	}
+	if err := row.parse(); err != nil {
+		return err
+	}
+	tested, err := lifecycleContentTested(ctx, q, content)
+	if err != nil {
+		return err
+	}
+	if !tested {
+		return errLifecycleNotTested
+	}
+	return nil
+}
+
+// lifecycleContentTested - тест шаблона для автоматических сообщений не устаревает: правило работает
+// без ограничения по времени, а любое изменение шаблона меняет отпечаток и требует нового теста
+func lifecycleContentTested(ctx context.Context, q pgxQuerier, content BroadcastContent) (bool, error) {
+	return contentTested(ctx, q, content, time.Time{})
+}
+
+// lifecycleRuleColumns - поля правила со счетчиками; запрос должен соединять admin_lifecycle_rule lr,
+// admin_message_template t и admin_lifecycle_delivery d
+const lifecycleRuleColumns = `lr.id, lr.name, lr.template_id, t.name, lr.offset_days, lr.enabled,
+	lr.created_by_name, lr.created_at, lr.updated_by_name, lr.updated_at,
+	COUNT(d.rule_id) FILTER (WHERE d.status = 'sent'),
+	COUNT(d.rule_id) FILTER (WHERE d.status = 'failed'),
+	COUNT(d.rule_id) FILTER (WHERE d.status = 'blocked'),
+	MAX(d.sent_at) FILTER (WHERE d.status = 'sent')`
+
+func (s *Server) listLifecycleRules(ctx context.Context) ([]LifecycleRule, error) {
+	rows, err := s.db.Query(ctx,
+		`SELECT `+lifecycleRuleColumns+`
+		 FROM admin_lifecycle_rule AS lr
+		 JOIN admin_message_template AS t ON t.id = lr.template_id
+		 LEFT JOIN admin_lifecycle_delivery AS d ON d.rule_id = lr.id
+		 GROUP BY lr.id, t.name
+		 ORDER BY lr.offset_days, lr.id
+		 LIMIT $1`,
+		lifecycleRulesListLimit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query lifecycle rules: %w", err)
+	}
+	defer rows.Close()
+
+	var rules []LifecycleRule
+	for rows.Next() {
+		var rule LifecycleRule
+		err := rows.Scan(
+			&rule.ID,
+			&rule.Name,
+			&rule.TemplateID,
+			&rule.TemplateName,
+			&rule.OffsetDays,
+			&rule.Enabled,
+			&rule.CreatedBy,
+			&rule.CreatedAt,
+			&rule.UpdatedBy,
+			&rule.UpdatedAt,
+			&rule.Sent,
+			&rule.Failed,
+			&rule.Blocked,
+			&rule.LastSentAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan lifecycle rule: %w", err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+func (s *Server) createLifecycleRule(ctx context.Context, session *Session, req LifecycleRuleRequest) (int64, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := checkLifecycleTemplate(ctx, tx, req.TemplateID, req.Enabled); err != nil {
+		return 0, err
+	}
+
+	var id int64
+	err = tx.QueryRow(ctx,
+		`INSERT INTO admin_lifecycle_rule (name, template_id, offset_days, enabled, created_by, created_by_name, updated_by_name)
+		 VALUES ($1, $2, $3, $4, $5, $6, $6)
+		 RETURNING id`,
+		req.Name, req.TemplateID, req.OffsetDays, req.Enabled, session.AdminID, session.Username,
+	).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert lifecycle rule: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("failed to commit lifecycle rule: %w", err)
+	}
+	return id, nil
+}
+
+// updateLifecycleRule - изменяет правило. Уже отправленные напоминания остаются учтенными: после смены срока
+// клиент не получит то же напоминание второй раз за ту же подписку.
+func (s *Server) updateLifecycleRule(ctx context.Context, session *Session, id int64, req LifecycleRuleRequest) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := checkLifecycleTemplate(ctx, tx, req.TemplateID, req.Enabled); err != nil {
+		return err
+	}
+
+	tag, err := tx.Exec(ctx,
+		`UPDATE admin_lifecycle_rule
+		 SET name = $2, template_id = $3, offset_days = $4, enabled = $5, updated_by_name = $6, updated_at = now()
+		 WHERE id = $1`,
+		id, req.Name, req.TemplateID, req.OffsetDays, req.Enabled, session.Username,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update lifecycle rule %d: %w", id, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return errLifecycleRuleNotFound
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit lifecycle rule %d: %w", id, err)
+	}
+	return nil
+}
+
+func (s *Server) deleteLifecycleRule(ctx context.Context, id int64) error {
+	tag, err := s.db.Exec(ctx, `DELETE FROM admin_lifecycle_rule WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete lifecycle rule %d: %w", id, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return errLifecycleRuleNotFound
+	}
+	return nil
+}
+
+// checkMessageTemplateUnused - шаблон, который отправляют автоматические сообщения, нельзя удалить
+// или превратить в черновик
+func checkMessageTemplateUnused(ctx context.Context, q pgxQuerier, id int64) error {
+	var used bool
+	err := q.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM admin_lifecycle_rule WHERE template_id = $1)`, id).Scan(&used)
+	if err != nil {
+		return fmt.Errorf("failed to check template usage: %w", err)
+	}
+	if used {
+		return errMessageTemplateInUse
+	}
+	return nil
+}
+
+// runLifecycleScheduler - отправляет автоматические сообщения, срок которых наступил
+func (s *Server) runLifecycleScheduler(ctx context.Context) {
+	log.Printf("⏰ Планировщик автоматических сообщений запущен")
+
+	if err := s.recoverInterruptedDeliveries(ctx); err != nil {
+		slog.Error("Failed to recover interrupted lifecycle deliveries", "error", err)
+	}
+
+	for {
+		if err := s.runLifecycleRules(ctx); err != nil && ctx.Err() == nil {
+			slog.Error("Lifecycle scheduler error", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Printf("⏰ Планировщик автоматических сообщений остановлен")
+			return
+		case <-time.After(lifecycleInterval):
+		}
+	}
+}
+
+// lifecycleJob - включенное правило с содержимым шаблона
+type lifecycleJob struct {
+	id         int64
+	name       string
+	offsetDays int
+	content    broadcastContent
+}
+
+// runLifecycleRules - проходит по включенным правилам; ошибка одного правила не останавливает остальные
+func (s *Server) runLifecycleRules(ctx context.Context) error {
+	if !s.telegram.Configured() {
+		return nil
+	}
+
+	rows, err := s.db.Query(ctx,
+		`SELECT lr.id, lr.name, lr.offset_days, t.message, t.messages, t.fallback_language, t.media, t.buttons
+		 FROM admin_lifecycle_rule AS lr
+		 JOIN admin_message_template AS t ON t.id = lr.template_id
+		 WHERE lr.enabled
+		 ORDER BY lr.id`,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to query lifecycle rules: %w", err)
+	}
+
+	var jobs []*lifecycleJob
+	for rows.Next() {
+		job := &lifecycleJob{}
+		content := scanContent(&job.content.BroadcastContent)
+		if err := rows.Scan(append([]interface{}{&job.id, &job.name, &job.offsetDays}, content.dest()...)...); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan lifecycle rule: %w", err)
+		}
+		if err := content.parse(); err != nil {
+			slog.Error("Failed to parse lifecycle template", "rule_id", job.id, "error", err)
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to query lifecycle rules: %w", err)
+	}
+
+	for _, job := range jobs {
+		// Шаблон могли изменить после включения правила: без теста нового содержимого правило не отправляется
+		if broadcastTestRequired() {
+			tested, err := lifecycleContentTested(ctx, s.db, job.content.BroadcastContent)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				slog.Error("Failed to check lifecycle template test", "rule_id", job.id, "error", err)
+				continue
+			}
+			if !tested {
+				slog.Warn("Skipping lifecycle rule with untested template", "rule_id", job.id)
+				continue
+			}
+		}
+
+		if err := s.processLifecycleRule(ctx, job, time.Now()); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			slog.Error("Failed to process lifecycle rule", "rule_id", job.id, "error", err)
+		}
+	}
+	return nil
+}
+
+// processLifecycleRule - отправляет сообщение правила всем клиентам, чей срок наступил не раньше lifecycleMaxDelay назад
+func (s *Server) processLifecycleRule(ctx context.Context, job *lifecycleJob, now time.Time) error {
+	sent := 0
+	for {
+		customers, err := s.dueLifecycleCustomers(ctx, job, now, lifecycleBatchSize)
+		if err != nil {
+			return err
+		}
+		if len(customers) == 0 {
+			break
+		}
+
+		for i := range customers {
+			customer := &customers[i]
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			claimed, err := s.claimLifecycleDelivery(ctx, job.id, customer)
+			if err != nil {
+				return err
+			}
+			if !claimed {
+				continue
+			}
+
+			// Частоту отправки и повторы после 429/5xx обеспечивает клиент Telegram
+			messageID, sendErr := s.sendBroadcastContent(ctx, customer, &job.content)
+			if sendErr != nil {
+				slog.Error("Failed to send lifecycle message",
+					"rule_id", job.id,
+					"telegram_id", customer.TelegramID,
+					"error", sendErr)
+			} else {
+				sent++
+			}
+			if err := s.markLifecycleDelivery(context.WithoutCancel(ctx), job.id, customer, messageID, sendErr); err != nil {
+				return err
+			}
+		}
+	}
+
+	if sent > 0 {
+		log.Printf("⏰ Автоматическое сообщение «%s»: отправлено %d", job.name, sent)
+	}
+	return nil
+}
+
+// lifecycleDueWindow - окончания подписки (from, to], для которых срок правила наступил не раньше
+// lifecycleMaxDelay назад: expire_at + offset_days попадает в (now - lifecycleMaxDelay, now]
+func lifecycleDueWindow(offsetDays int, now time.Time) (from, to time.Time) {
+	to = now.AddDate(0, 0, -offsetDays)
+	return to.Add(-lifecycleMaxDelay), to
+}
+
+// dueLifecycleCustomers - клиенты, для которых наступил срок правила в текущем сроке подписки и которым
+// сообщение за этот срок еще не отправлялось. Заблокировавшие бота пропускаются.
+func (s *Server) dueLifecycleCustomers(ctx context.Context, job *lifecycleJob, now time.Time, limit int) ([]Customer, error) {
+	from, to := lifecycleDueWindow(job.offsetDays, now)
+	rows, err := s.db.Query(ctx,
+		`SELECT c.id, c.telegram_id, c.expire_at, c.created_at, c.subscription_link, c.language
+		 FROM customer AS c
+		 WHERE c.expire_at > $2
+		   AND c.expire_at <= $3
+		   AND NOT EXISTS (SELECT 1 FROM admin_blocked_user AS bu WHERE bu.telegram_id = c.telegram_id)
+		   AND NOT EXISTS (
+		     SELECT 1 FROM admin_lifecycle_delivery AS d
+		     WHERE d.rule_id = $1 AND d.customer_id = c.id AND d.expire_at = c.expire_at
+		   )
+		 ORDER BY c.id
+		 LIMIT $4`,
+		job.id, from, to, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query lifecycle customers: %w", err)
+	}
+	defer rows.Close()
+
+	var customers []Customer
+	for rows.Next() {
+		var customer Customer
+		err := rows.Scan(&customer.ID, &customer.TelegramID, &customer.ExpireAt, &customer.CreatedAt, &customer.SubscriptionLink, &customer.Language)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan lifecycle customer: %w", err)
+		}
+		customers = append(customers, customer)
+	}
+	return customers, rows.Err()
+}
+
+// claimLifecycleDelivery - запоминает доставку до обращения к Telegram; false - клиенту за этот срок подписки
+// сообщение уже отправлялось
+func (s *Server) claimLifecycleDelivery(ctx context.Context, ruleID int64, customer *Customer) (bool, error) {
+	tag, err := s.db.Exec(ctx,
+		`INSERT INTO admin_lifecycle_delivery (rule_id, customer_id, expire_at, telegram_id, status)
+		 VALUES ($1, $2, $3, $4, $5)
+		 ON CONFLICT DO NOTHING`,
+		ruleID, customer.ID, customer.ExpireAt, customer.TelegramID, RecipientSending,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to claim lifecycle delivery: %w", err)
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// markLifecycleDelivery - результат доставки; заблокировавший бота клиент отмечается как и в рассылках
+func (s *Server) markLifecycleDelivery(ctx context.Context, ruleID int64, customer *Customer, messageID int64, sendErr error) error {
+	status, errMsg := RecipientSent, ""
+	if sendErr != nil {
+		status, errMsg = RecipientFailed, sendErr.Error()
+		if isRecipientUnreachable(sendErr) {
+			status = RecipientBlocked
+		}
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx,
+		`UPDATE admin_lifecycle_delivery
+		 SET status = $4, error = $5, sent_at = now(), message_id = NULLIF($6::bigint, 0)
+		 WHERE rule_id = $1 AND customer_id = $2 AND expire_at = $3`,
+		ruleID, customer.ID, customer.ExpireAt, status, errMsg, messageID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update lifecycle delivery: %w", err)
+	}
+
+	switch status {
+	case RecipientBlocked:
+		_, err = tx.Exec(ctx,
+			`INSERT INTO admin_blocked_user (telegram_id, reason)
+			 VALUES ($1, $2)
+			 ON CONFLICT (telegram_id) DO UPDATE SET blocked_at = now(), reason = $2, broadcast_id = NULL`,
+			customer.TelegramID, errMsg,
+		)
+	case RecipientSent:
+		_, err = tx.Exec(ctx, `DELETE FROM admin_blocked_user WHERE telegram_id = $1`, customer.TelegramID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update blocked user: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit lifecycle delivery: %w", err)
+	}
+	return nil
+}
+
+// recoverInterruptedDeliveries - доставки, застрявшие в sending после падения панели; как и в рассылках,
+// повторно не отправляем
+func (s *Server) recoverInterruptedDeliveries(ctx context.Context) error {
+	tag, err := s.db.Exec(ctx,
+		`UPDATE admin_lifecycle_delivery
+		 SET status = $2, error = $3, sent_at = now()
+		 WHERE status = $1`,
+		RecipientSending, RecipientFailed, "interrupted: delivery unknown, not retried",
+	)
+	if err != nil {
+		return fmt.Errorf("failed to recover interrupted lifecycle deliveries: %w", err)
+	}
+	if n := tag.RowsAffected(); n > 0 {
+		log.Printf("⚠️ %d автоматических сообщений остались в неизвестном состоянии после перезапуска и не будут отправлены повторно", n)
+	}
+	return nil
+}
+
+// lifecycleRulesHandler - GET /admin/lifecycle-rules: правила автоматических сообщений со счетчиками доставки
+func (s *Server) lifecycleRulesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	rules, err := s.listLifecycleRules(r.Context())
+
+	response := LifecycleRulesResponse{
+		Success: err == nil,
+		Rules:   rules,
+	}
+
+	if err != nil {
+		slog.Error("Failed to list lifecycle rules", "error", err)
+		response.Error = err.Error()
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// saveLifecycleRuleHandler - POST /admin/lifecycle-rules/create и /admin/lifecycle-rules/{id}/update
+func (s *Server) saveLifecycleRuleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var id int64
+	if value := r.PathValue("id"); value != "" {
+		var err error
+		if id, err = strconv.ParseInt(value, 10, 64); err != nil || id <= 0 {
+			writeJSONError(w, http.StatusBadRequest, "Invalid rule ID")
+			return
+		}
+	}
+
+	var req LifecycleRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if err := req.validate(); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	current := sessionFromContext(r.Context())
+	var err error
+	if id == 0 {
+		id, err = s.createLifecycleRule(r.Context(), current, req)
+	} else {
+		err = s.updateLifecycleRule(r.Context(), current, id, req)
+	}
+	setAuditTarget(r.Context(), fmt.Sprintf("lifecycle_rule:%d", id))
+
+	response := map[string]interface{}{
+		"success": err == nil,
+	}
+
+	status := http.StatusOK
+
+	if err != nil {
+		switch {
+		case errors.Is(err, errLifecycleRuleNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, errMessageTemplateNotFound), errors.Is(err, errLifecycleDraft):
+			status = http.StatusBadRequest
+		case errors.Is(err, errLifecycleNotTested):
+			status = http.StatusConflict
+		default:
+			slog.Error("Failed to save lifecycle rule", "rule_id", id, "error", err)
+		}
+		response["error"] = err.Error()
+	} else {
+		setAuditDetail(r.Context(), "template_id", req.TemplateID)
+		setAuditDetail(r.Context(), "offset_days", req.OffsetDays)
+		setAuditDetail(r.Context(), "enabled", req.Enabled)
+		log.Printf("⏰ %s сохранил автоматическое сообщение #%d «%s»", current.Username, id, req.Name)
+		response["id"] = id
+		response["message"] = fmt.Sprintf("Сохранено: «%s»", req.Name)
+	}
+
+	writeJSON(w, status, response)
+}
+
+// deleteLifecycleRuleHandler - POST /admin/lifecycle-rules/{id}/delete; история доставки удаляется вместе с правилом
+func (s *Server) deleteLifecycleRuleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id <= 0 {
+		writeJSONError(w, http.StatusBadRequest, "Invalid rule ID")
+		return
+	}
+
+	setAuditTarget(r.Context(), fmt.Sprintf("lifecycle_rule:%d", id))
+
+	err = s.deleteLifecycleRule(r.Context(), id)
+
+	response := map[string]interface{}{
+		"success": err == nil,
+	}
+
+	status := http.StatusOK
+
+	if err != nil {
+		if errors.Is(err, errLifecycleRuleNotFound) {
+			status = http.StatusNotFound
+		} else {
+			slog.Error("Failed to delete lifecycle rule", "rule_id", id, "error", err)
+		}
+		response["error"] = err.Error()
+	} else {
+		current := sessionFromContext(r.Context())
+		log.Printf("🗑️ %s удалил автоматическое сообщение #%d", current.Username, id)
+		response["message"] = fmt.Sprintf("Автоматическое сообщение #%d удалено", id)
+	}
+
+	writeJSON(w, status, response)
+}
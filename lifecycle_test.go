@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// testMessageTemplate - шаблон или черновик в тестовой базе
+func testMessageTemplate(t *testing.T, s *Server, session *Session, kind, name string) int64 {
+	t.Helper()
+
+	id, err := s.createMessageTemplate(context.Background(), session, MessageTemplateRequest{
+		Kind:             kind,
+		Name:             name,
+		BroadcastContent: BroadcastContent{Message: "Подписка заканчивается {{.ExpireAt}}"},
+	})
+	if err != nil {
+		t.Fatalf("create template: %v", err)
+	}
+	return id
+}
+
+func TestMessageTemplateUsedByLifecycleRule(t *testing.T) {
+	db := testDB(t)
+	s := &Server{db: db}
+	session := testSession(t, db)
+	ctx := context.Background()
+
+	templateID := testMessageTemplate(t, s, session, MessageTemplateKind, "Напоминание")
+	rule := LifecycleRuleRequest{Name: "За 3 дня", TemplateID: templateID, OffsetDays: -3, Enabled: true}
+	if _, err := s.createLifecycleRule(ctx, session, rule); err != nil {
+		t.Fatalf("createLifecycleRule: %v", err)
+	}
+
+	if err := s.deleteMessageTemplate(ctx, templateID); !errors.Is(err, errMessageTemplateInUse) {
+		t.Errorf("deleteMessageTemplate error = %v, want errMessageTemplateInUse", err)
+	}
+	draft := MessageTemplateRequest{Kind: MessageDraftKind, BroadcastContent: BroadcastContent{Message: "x"}}
+	if err := s.updateMessageTemplate(ctx, session, templateID, draft); !errors.Is(err, errMessageTemplateInUse) {
+		t.Errorf("updateMessageTemplate to draft error = %v, want errMessageTemplateInUse", err)
+	}
+
+	draftID := testMessageTemplate(t, s, session, MessageDraftKind, "")
+	rule.TemplateID = draftID
+	if _, err := s.createLifecycleRule(ctx, session, rule); !errors.Is(err, errLifecycleDraft) {
+		t.Errorf("createLifecycleRule with a draft error = %v, want errLifecycleDraft", err)
+	}
+	if err := s.deleteMessageTemplate(ctx, draftID); err != nil {
+		t.Errorf("deleteMessageTemplate(unused draft): %v", err)
+	}
+	rule.TemplateID = draftID
+	if _, err := s.createLifecycleRule(ctx, session, rule); !errors.Is(err, errMessageTemplateNotFound) {
+		t.Errorf("createLifecycleRule with a deleted template error = %v, want errMessageTemplateNotFound", err)
+	}
+}
+
+// Удаление шаблона ждет транзакцию, которая сохраняет правило с этим шаблоном, и видит новое правило
+func TestDeleteMessageTemplateWaitsForRule(t *testing.T) {
+	db := testDB(t)
+	s := &Server{db: db}
+	session := testSession(t, db)
+	ctx := context.Background()
+
+	templateID := testMessageTemplate(t, s, session, MessageTemplateKind, "Напоминание")
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+	if err := checkLifecycleTemplate(ctx, tx, templateID, true); err != nil {
+		t.Fatalf("checkLifecycleTemplate: %v", err)
+	}
+	_, err = tx.Exec(ctx,
+		`INSERT INTO admin_lifecycle_rule (name, template_id, offset_days, created_by_name, updated_by_name)
+		 VALUES ('r', $1, 0, '', '')`,
+		templateID,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deleted := make(chan error, 1)
+	go func() { deleted <- s.deleteMessageTemplate(ctx, templateID) }()
+
+	select {
+	case err := <-deleted:
+		t.Fatalf("deleteMessageTemplate returned %v before the rule was committed", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-deleted; !errors.Is(err, errMessageTemplateInUse) {
+		t.Errorf("deleteMessageTemplate error = %v, want errMessageTemplateInUse", err)
+	}
+}
+
+// testCustomer - клиент бота в тестовой базе
+func testCustomer(t *testing.T, s *Server, telegramID int64, expireAt time.Time) int64 {
+	t.Helper()
+
+	var id int64
+	err := s.db.QueryRow(context.Background(),
+		`INSERT INTO customer (telegram_id, expire_at) VALUES ($1, $2) RETURNING id`,
+		telegramID, expireAt,
+	).Scan(&id)
+	if err != nil {
+		t.Fatalf("create customer: %v", err)
+	}
+	return id
+}
+
+// С BROADCAST_REQUIRE_TEST правило включается только с протестированным шаблоном, а после изменения
+// шаблона планировщик его пропускает до нового теста
+func TestLifecycleRuleRequiresTest(t *testing.T) {
+	t.Setenv("BROADCAST_REQUIRE_TEST", "true")
+	db := testDB(t)
+	api, client, _ := fakeBotClient(t)
+	s := &Server{db: db, telegram: client}
+	session := testSession(t, db)
+	ctx := context.Background()
+
+	templateID := testMessageTemplate(t, s, session, MessageTemplateKind, "Напоминание")
+	rule := LifecycleRuleRequest{Name: "В день окончания", TemplateID: templateID, Enabled: true}
+	if _, err := s.createLifecycleRule(ctx, session, rule); !errors.Is(err, errLifecycleNotTested) {
+		t.Fatalf("createLifecycleRule without a test error = %v, want errLifecycleNotTested", err)
+	}
+
+	rule.Enabled = false
+	ruleID, err := s.createLifecycleRule(ctx, session, rule)
+	if err != nil {
+		t.Fatalf("createLifecycleRule(disabled): %v", err)
+	}
+
+	// Тест месячной давности засчитывается: ограничение в 24 часа действует только для рассылок
+	tpl, err := s.getMessageTemplate(ctx, templateID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := contentHash(tpl.BroadcastContent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(ctx,
+		`INSERT INTO admin_broadcast_test (content_hash, created_at) VALUES ($1, now() - interval '30 days')`,
+		hash,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rule.Enabled = true
+	if err := s.updateLifecycleRule(ctx, session, ruleID, rule); err != nil {
+		t.Fatalf("updateLifecycleRule after the test: %v", err)
+	}
+
+	testCustomer(t, s, 100, time.Now().Add(-time.Hour))
+	if err := s.runLifecycleRules(ctx); err != nil {
+		t.Fatalf("runLifecycleRules: %v", err)
+	}
+	if calls := len(api.Calls()); calls != 1 {
+		t.Fatalf("messages sent by a tested rule = %d, want 1", calls)
+	}
+
+	// Измененный шаблон не проверен - новому клиенту ничего не уходит
+	update := MessageTemplateRequest{Kind: MessageTemplateKind, Name: tpl.Name, BroadcastContent: BroadcastContent{Message: "Новый текст"}}
+	if err := s.updateMessageTemplate(ctx, session, templateID, update); err != nil {
+		t.Fatalf("updateMessageTemplate: %v", err)
+	}
+	testCustomer(t, s, 200, time.Now().Add(-time.Hour))
+	if err := s.runLifecycleRules(ctx); err != nil {
+		t.Fatalf("runLifecycleRules: %v", err)
+	}
+	if calls := len(api.Calls()); calls != 1 {
+		t.Errorf("messages sent after the template changed = %d, want 1", calls)
+	}
+}
+
+func TestLifecycleDueWindow(t *testing.T) {
+	now := time.Date(2024, 6, 10, 12, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+
+	tests := []struct {
+		name       string
+		offsetDays int
+		expireAt   time.Time
+		want       bool
+	}{
+		{"expires right now", 0, now, true},
+		{"expired an hour ago", 0, now.Add(-time.Hour), true},
+		{"expires in a second", 0, now.Add(time.Second), false},
+		{"expired just inside the max delay", 0, now.Add(-lifecycleMaxDelay + time.Second), true},
+		{"expired exactly max delay ago", 0, now.Add(-lifecycleMaxDelay), false},
+		{"expired long ago", 0, now.Add(-30 * day), false},
+
+		// За 3 дня до окончания: срок наступает, когда до expire_at осталось 3 дня
+		{"3 days before: expires in 3 days", -3, now.Add(3 * day), true},
+		{"3 days before: expires in 2 days 13 hours", -3, now.Add(3*day - 11*time.Hour), true},
+		{"3 days before: expires in 3 days and a minute", -3, now.Add(3*day + time.Minute), false},
+		{"3 days before: expires in 2 days", -3, now.Add(2 * day), false},
+		{"3 days before: already expired", -3, now.Add(-time.Hour), false},
+
+		// Через 5 дней после окончания
+		{"5 days after: expired 5 days ago", 5, now.Add(-5 * day), true},
+		{"5 days after: expired 5 days 23 hours ago", 5, now.Add(-6*day + time.Hour), true},
+		{"5 days after: expired 6 days ago", 5, now.Add(-6 * day), false},
+		{"5 days after: expired 4 days ago", 5, now.Add(-4 * day), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from, to := lifecycleDueWindow(tt.offsetDays, now)
+			if got := tt.expireAt.After(from) && !tt.expireAt.After(to); got != tt.want {
+				t.Errorf("expire_at %s in window (%s, %s] = %v, want %v", tt.expireAt, from, to, got, tt.want)
+			}
+		})
+	}
+}
+
+// Клиент получает напоминание один раз за срок подписки: повторный проход ничего не отправляет,
+// а после продления (новый expire_at) напоминание приходит снова
+func TestLifecycleDeliveryOncePerExpiry(t *testing.T) {
+	db := testDB(t)
+	api, client, _ := fakeBotClient(t)
+	s := &Server{db: db, telegram: client}
+	session := testSession(t, db)
+	ctx := context.Background()
+
+	templateID := testMessageTemplate(t, s, session, MessageTemplateKind, "Подписка закончилась")
+	ruleID, err := s.createLifecycleRule(ctx, session, LifecycleRuleRequest{Name: "В день окончания", TemplateID: templateID, Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := &lifecycleJob{id: ruleID, name: "В день окончания", content: broadcastContent{
+		BroadcastContent: BroadcastContent{Message: "Подписка закончилась"},
+	}}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	customerID := testCustomer(t, s, 100, now.Add(-time.Hour))
+	renewed := now.AddDate(0, 1, 0)
+
+	steps := []struct {
+		name      string
+		renew     bool
+		now       time.Time
+		wantSent  int
+		wantTotal int
+	}{
+		{name: "first pass", now: now, wantSent: 1, wantTotal: 1},
+		{name: "same expiry again", now: now.Add(time.Minute), wantSent: 1, wantTotal: 1},
+		{name: "after renewal, before the new expiry", renew: true, now: now.Add(2 * time.Minute), wantSent: 1, wantTotal: 1},
+		{name: "new expiry reached", now: renewed.Add(time.Hour), wantSent: 2, wantTotal: 2},
+		{name: "new expiry again", now: renewed.Add(2 * time.Hour), wantSent: 2, wantTotal: 2},
+	}
+
+	for _, step := range steps {
+		if step.renew {
+			if _, err := db.Exec(ctx, `UPDATE customer SET expire_at = $2 WHERE id = $1`, customerID, renewed); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := s.processLifecycleRule(ctx, job, step.now); err != nil {
+			t.Fatalf("%s: processLifecycleRule: %v", step.name, err)
+		}
+
+		if sent := len(api.Calls()); sent != step.wantSent {
+			t.Errorf("%s: messages sent = %d, want %d", step.name, sent, step.wantSent)
+		}
+		var total int
+		err := db.QueryRow(ctx,
+			`SELECT COUNT(*) FROM admin_lifecycle_delivery WHERE rule_id = $1 AND customer_id = $2 AND status = $3`,
+			ruleID, customerID, RecipientSent,
+		).Scan(&total)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if total != step.wantTotal {
+			t.Errorf("%s: deliveries = %d, want %d", step.name, total, step.wantTotal)
+		}
+	}
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Политика защиты от перебора: первые попытки без задержки, дальше задержка удваивается до блокировки на час
+const (
+	loginFreeAttempts  = 3
+	loginBaseDelay     = 5 * time.Second
+	loginMaxLockout    = time.Hour
+	loginFailureWindow = 24 * time.Hour
+)
+
+const (
+	loginBlockIP       = "ip"
+	loginBlockUsername = "username"
+)
+
+type LoginBlock struct {
+	Kind          string     `json:"kind"`
+	Key           string     `json:"key"`
+	Failures      int        `json:"failures"`
+	LastFailureAt time.Time  `json:"last_failure_at"`
+	BlockedUntil  *time.Time `json:"blocked_until"`
+}
+
+type LoginFailure struct {
+	ID        int64     `json:"id"`
+	IP        string    `json:"ip"`
+	Username  string    `json:"username"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type LoginBlocksResponse struct {
+	Success  bool           `json:"success"`
+	Blocks   []LoginBlock   `json:"blocks,omitempty"`
+	Failures []LoginFailure `json:"failures,omitempty"`
+	Error    string         `json:"error,omitempty"`
+}
+
+type UnblockLoginRequest struct {
+	Kind string `json:"kind"`
+	Key  string `json:"key"`
+}
+
+// loginBackoff - на сколько блокируется вход после failures неудачных попыток подряд
+func loginBackoff(failures int) time.Duration {
+	if failures < loginFreeAttempts {
+		return 0
+	}
+
+	delay := loginBaseDelay
+	for i := loginFreeAttempts; i < failures; i++ {
+		delay *= 2
+		if delay >= loginMaxLockout {
+			return loginMaxLockout
+		}
+	}
+	return delay
+}
+
+// normalizeLoginUsername - логин как ключ счетчика попыток
+func normalizeLoginUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// loginBlockedFor - сколько еще действует блокировка IP или логина (0 - вход разрешен)
+func (s *Server) loginBlockedFor(ctx context.Context, ip, username string) (time.Duration, error) {
+	query := `SELECT MAX(blocked_until)
+			  FROM admin_login_block
+			  WHERE (kind = $1 AND key = $2) OR (kind = $3 AND key = $4)`
+
+	var blockedUntil *time.Time
+	err := s.db.QueryRow(ctx, query,
+		loginBlockIP, ip,
+		loginBlockUsername, normalizeLoginUsername(username),
+	).Scan(&blockedUntil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query login blocks: %w", err)
+	}
+
+	if blockedUntil == nil {
+		return 0, nil
+	}
+	remaining := time.Until(*blockedUntil)
+	if remaining < 0 {
+		return 0, nil
+	}
+	return remaining, nil
+}
+
+// checkLoginAllowed - показывает форму с ошибкой, если вход для IP или логина временно заблокирован
+func (s *Server) checkLoginAllowed(w http.ResponseWriter, r *http.Request, ip, username string) bool {
+	blockedFor, err := s.loginBlockedFor(r.Context(), ip, username)
+	if err != nil {
+		slog.Error("Failed to check login block", "ip", ip, "username", username, "error", err)
+		s.showLoginForm(w, "Ошибка сервера, попробуйте позже")
+		return false
+	}
+
+	if blockedFor > 0 {
+		s.logLoginFailure(r.Context(), ip, username, "blocked")
+		s.showLoginForm(w, fmt.Sprintf("Слишком много неудачных попыток. Повторите через %s", blockedFor.Round(time.Second)))
+		return false
+	}
+
+	return true
+}
+
+// logLoginFailure - запись в журнал неудачных входов
+func (s *Server) logLoginFailure(ctx context.Context, ip, username, reason string) {
+	log.Printf("⚠️ Неудачный вход: логин %q, IP %s, причина %s", username, ip, reason)
+
+	_, err := s.db.Exec(ctx,
+		`INSERT INTO admin_login_failure (ip, username, reason) VALUES ($1, $2, $3)`,
+		ip, username, reason,
+	)
+	if err != nil {
+		slog.Error("Failed to log login failure", "ip", ip, "username", username, "error", err)
+	}
+}
+
+// recordLoginFailure - учитывает неудачную попытку для IP и логина и при необходимости блокирует их
+func (s *Server) recordLoginFailure(ctx context.Context, ip, username, reason string) {
+	s.logLoginFailure(ctx, ip, username, reason)
+
+	keys := map[string]string{
+		loginBlockIP:       ip,
+		loginBlockUsername: normalizeLoginUsername(username),
+	}
+
+	for kind, key := range keys {
+		if key == "" {
+			continue
+		}
+		if err := s.bumpLoginFailures(ctx, ip, kind, key, reason); err != nil {
+			slog.Error("Failed to record login failure", "kind", kind, "key", key, "error", err)
+		}
+	}
+}
+
+// bumpLoginFailures - увеличивает счетчик неудач и выставляет время блокировки; блокировка попадает в журнал аудита
+func (s *Server) bumpLoginFailures(ctx context.Context, ip, kind, key, reason string) error {
+	query := `INSERT INTO admin_login_block (kind, key, failures, last_failure_at)
+			  VALUES ($1, $2, 1, now())
+			  ON CONFLICT (kind, key) DO UPDATE SET
+			    failures = CASE
+			      WHEN admin_login_block.last_failure_at < $3 THEN 1
+			      ELSE admin_login_block.failures + 1
+			    END,
+			    last_failure_at = now()
+			  RETURNING failures`
+
+	var failures int
+	if err := s.db.QueryRow(ctx, query, kind, key, time.Now().Add(-loginFailureWindow)).Scan(&failures); err != nil {
+		return fmt.Errorf("failed to upsert login failures: %w", err)
+	}
+
+	delay := loginBackoff(failures)
+	if delay == 0 {
+		return nil
+	}
+
+	_, err := s.db.Exec(ctx,
+		`UPDATE admin_login_block SET blocked_until = $3 WHERE kind = $1 AND key = $2`,
+		kind, key, time.Now().Add(delay),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to set login block: %w", err)
+	}
+
+	log.Printf("🚧 Вход для %s %s заблокирован на %s после %d неудачных попыток", kind, key, delay, failures)
+
+	details, _ := json.Marshal(map[string]interface{}{
+		"failures":    failures,
+		"blocked_for": delay.String(),
+		"reason":      reason,
+	})
+	s.recordAudit(ctx, nil, ip, AuditEntry{
+		Action:  AuditLoginLockout,
+		Target:  kind + ":" + key,
+		Details: details,
+		Status:  http.StatusTooManyRequests,
+		Error:   fmt.Sprintf("вход заблокирован на %s после %d неудачных попыток", delay, failures),
+	})
+	return nil
+}
+
+// resetLoginFailures - после успешного входа счетчики IP и логина обнуляются
+func (s *Server) resetLoginFailures(ctx context.Context, ip, username string) {
+	_, err := s.db.Exec(ctx,
+		`DELETE FROM admin_login_block WHERE (kind = $1 AND key = $2) OR (kind = $3 AND key = $4)`,
+		loginBlockIP, ip,
+		loginBlockUsername, normalizeLoginUsername(username),
+	)
+	if err != nil {
+		slog.Error("Failed to reset login failures", "ip", ip, "username", username, "error", err)
+	}
+}
+
+// listLoginBlocks - действующие блокировки и счетчики за последние сутки
+func (s *Server) listLoginBlocks(ctx context.Context) ([]LoginBlock, error) {
+	query := `SELECT kind, key, failures, last_failure_at, blocked_until
+			  FROM admin_login_block
+			  WHERE blocked_until > now() OR last_failure_at > $1
+			  ORDER BY blocked_until DESC NULLS LAST, last_failure_at DESC`
+
+	rows, err := s.db.Query(ctx, query, time.Now().Add(-loginFailureWindow))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query login blocks: %w", err)
+	}
+	defer rows.Close()
+
+	var blocks []LoginBlock
+	for rows.Next() {
+		var block LoginBlock
+		if err := rows.Scan(&block.Kind, &block.Key, &block.Failures, &block.LastFailureAt, &block.BlockedUntil); err != nil {
+			return nil, fmt.Errorf("failed to scan login block: %w", err)
+		}
+		blocks = append(blocks, block)
+	}
+
+	return blocks, rows.Err()
+}
+
+// listLoginFailures - последние неудачные попытки входа
+func (s *Server) listLoginFailures(ctx context.Context, limit int) ([]LoginFailure, error) {
+	query := `SELECT id, ip, username, reason, created_at
+			  FROM admin_login_failure
+			  ORDER BY id DESC
+			  LIMIT $1`
+
+	rows, err := s.db.Query(ctx, query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query login failures: %w", err)
+	}
+	defer rows.Close()
+
+	var failures []LoginFailure
+	for rows.Next() {
+		var failure LoginFailure
+		if err := rows.Scan(&failure.ID, &failure.IP, &failure.Username, &failure.Reason, &failure.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan login failure: %w", err)
+		}
+		failures = append(failures, failure)
+	}
+
+	return failures, rows.Err()
+}
+
+// loginBlocksHandler - список блокировок и последних неудачных входов
+func (s *Server) loginBlocksHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	blocks, err := s.listLoginBlocks(r.Context())
+
+	var failures []LoginFailure
+	if err == nil {
+		failures, err = s.listLoginFailures(r.Context(), 100)
+	}
+
+	response := LoginBlocksResponse{
+		Success:  err == nil,
+		Blocks:   blocks,
+		Failures: failures,
+	}
+
+	if err != nil {
+		slog.Error("Failed to list login blocks", "error", err)
+		response.Error = err.Error()
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// unblockLoginHandler - ручное снятие блокировки IP или логина
+func (s *Server) unblockLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req UnblockLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if (req.Kind != loginBlockIP && req.Kind != loginBlockUsername) || req.Key == "" {
+		http.Error(w, "Kind (ip or username) and key are required", http.StatusBadRequest)
+		return
+	}
+
+	setAuditTarget(r.Context(), req.Kind+":"+req.Key)
+
+	tag, err := s.db.Exec(r.Context(), `DELETE FROM admin_login_block WHERE kind = $1 AND key = $2`, req.Kind, req.Key)
+	if err == nil && tag.RowsAffected() == 0 {
+		err = fmt.Errorf("блокировка %s %s не найдена", req.Kind, req.Key)
+	}
+
+	response := map[string]interface{}{
+		"success": err == nil,
+	}
+
+	if err != nil {
+		response["error"] = err.Error()
+	} else {
+		current := sessionFromContext(r.Context())
+		log.Printf("🔓 %s снял блокировку входа для %s %s", current.Username, req.Kind, req.Key)
+		response["message"] = fmt.Sprintf("Блокировка %s %s снята", req.Kind, req.Key)
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoginBackoff(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{1, 0},
+		{loginFreeAttempts - 1, 0},
+		{loginFreeAttempts, loginBaseDelay},
+		{loginFreeAttempts + 1, 2 * loginBaseDelay},
+		{loginFreeAttempts + 2, 4 * loginBaseDelay},
+		{loginFreeAttempts + 9, 512 * loginBaseDelay}, // 42m40s - последняя задержка до потолка
+		{loginFreeAttempts + 10, loginMaxLockout},
+		{loginFreeAttempts + 11, loginMaxLockout},
+		{1000, loginMaxLockout}, // без переполнения при большом счетчике
+	}
+
+	for _, tt := range tests {
+		if got := loginBackoff(tt.failures); got != tt.want {
+			t.Errorf("loginBackoff(%d) = %s, want %s", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestLoginBackoffMonotonic(t *testing.T) {
+	previous := time.Duration(0)
+	for failures := 0; failures < 100; failures++ {
+		delay := loginBackoff(failures)
+		if delay < previous {
+			t.Fatalf("loginBackoff(%d) = %s is less than loginBackoff(%d) = %s", failures, delay, failures-1, previous)
+		}
+		if delay > loginMaxLockout {
+			t.Fatalf("loginBackoff(%d) = %s exceeds the cap %s", failures, delay, loginMaxLockout)
+		}
+		previous = delay
+	}
+}
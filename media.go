@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// Типы вложений рассылки
+const (
+	MediaPhoto    = "photo"
+	MediaVideo    = "video"
+	MediaDocument = "document"
+)
+
+// Ограничения Bot API на сообщения, вложения и кнопки
+const (
+	telegramMaxMessageLength = 4096
+	telegramMaxCaptionLength = 1024
+	telegramMaxCallbackBytes = 64
+
+	mediaMaxPhotoSize = 10 << 20
+	mediaMaxFileSize  = 50 << 20
+
+	broadcastMaxButtonRows    = 10
+	broadcastMaxButtonsPerRow = 8
+)
+
+var errMediaUploadNotFound = errors.New("загруженный файл не найден")
+
+// BroadcastMedia - вложение рассылки: URL или file_id в source, либо файл, загруженный через панель
+type BroadcastMedia struct {
+	Type     string `json:"type"` // photo, video, document
+	Source   string `json:"source,omitempty"`
+	UploadID int64  `json:"upload_id,omitempty"`
+	FileName string `json:"file_name,omitempty"`
+}
+
+// MediaUpload - файл, загруженный через панель. После первой отправки Telegram возвращает file_id,
+// и остальным получателям уходит уже он, без повторной загрузки.
+type MediaUpload struct {
+	ID          int64     `json:"id"`
+	Type        string    `json:"type"`
+	FileName    string    `json:"file_name"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	FileID      string    `json:"file_id,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// validate - проверка вложения без обращения к БД
+func (m *BroadcastMedia) validate() error {
+	if _, ok := telegramMediaMethods[m.Type]; !ok {
+		return fmt.Errorf("invalid media type %q (available: photo, video, document)", m.Type)
+	}
+
+	m.Source = strings.TrimSpace(m.Source)
+	if (m.Source == "") == (m.UploadID == 0) {
+		return fmt.Errorf("media requires either source (URL or file_id) or upload_id")
+	}
+
+	if strings.Contains(m.Source, "://") {
+		u, err := url.Parse(m.Source)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid media URL %q", m.Source)
+		}
+	}
+	return nil
+}
+
+// validateButtons - проверка inline клавиатуры: у кнопки есть текст и ровно одно из url или callback_data
+func validateButtons(rows [][]InlineButton) error {
+	if len(rows) > broadcastMaxButtonRows {
+		return fmt.Errorf("too many button rows (max %d)", broadcastMaxButtonRows)
+	}
+
+	for i, row := range rows {
+		if len(row) == 0 {
+			return fmt.Errorf("button row %d is empty", i+1)
+		}
+		if len(row) > broadcastMaxButtonsPerRow {
+			return fmt.Errorf("too many buttons in row %d (max %d)", i+1, broadcastMaxButtonsPerRow)
+		}
+
+		for j := range row {
+			button := &row[j]
+			button.Text = strings.TrimSpace(button.Text)
+			button.URL = strings.TrimSpace(button.URL)
+
+			if button.Text == "" {
+				return fmt.Errorf("button %d in row %d has no text", j+1, i+1)
+			}
+			if (button.URL == "") == (button.CallbackData == "") {
+				return fmt.Errorf("button %q needs either url or callback_data", button.Text)
+			}
+			if button.URL != "" {
+				u, err := url.Parse(button.URL)
+				if err != nil || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "tg") {
+					return fmt.Errorf("button %q has invalid url %q", button.Text, button.URL)
+				}
+			}
+			if len(button.CallbackData) > telegramMaxCallbackBytes {
+				return fmt.Errorf("button %q callback_data is longer than %d bytes", button.Text, telegramMaxCallbackBytes)
+			}
+		}
+	}
+	return nil
+}
+
+// buttonsMarkup - reply_markup для кнопок; nil, если кнопок нет
+func buttonsMarkup(rows [][]InlineButton) *InlineKeyboardMarkup {
+	if len(rows) == 0 {
+		return nil
+	}
+	return &InlineKeyboardMarkup{InlineKeyboard: rows}
+}
+
+// getMediaUpload - сведения о загруженном файле без содержимого
+func (s *Server) getMediaUpload(ctx context.Context, id int64) (*MediaUpload, error) {
+	var upload MediaUpload
+	err := s.db.QueryRow(ctx,
+		`SELECT id, type, file_name, content_type, size, file_id, created_at
+		 FROM admin_broadcast_media
+		 WHERE id = $1`,
+		id,
+	).Scan(&upload.ID, &upload.Type, &upload.FileName, &upload.ContentType, &upload.Size, &upload.FileID, &upload.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errMediaUploadNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query media upload: %w", err)
+	}
+	return &upload, nil
+}
+
+// loadMediaUpload - загруженный файл; содержимое читается, только если file_id еще не известен
+func (s *Server) loadMediaUpload(ctx context.Context, id int64) (*MediaUpload, []byte, error) {
+	upload, err := s.getMediaUpload(ctx, id)
+	if err != nil || upload.FileID != "" {
+		return upload, nil, err
+	}
+
+	var data []byte
+	if err := s.db.QueryRow(ctx, `SELECT data FROM admin_broadcast_media WHERE id = $1`, id).Scan(&data); err != nil {
+		return nil, nil, fmt.Errorf("failed to load media upload: %w", err)
+	}
+	return upload, data, nil
+}
+
+// saveMediaFileID - запоминает file_id загруженного файла
+func (s *Server) saveMediaFileID(ctx context.Context, id int64, fileID string) error {
+	_, err := s.db.Exec(ctx, `UPDATE admin_broadcast_media SET file_id = $2 WHERE id = $1`, id, fileID)
+	if err != nil {
+		return fmt.Errorf("failed to update media file_id: %w", err)
+	}
+	return nil
+}
+
+// detectMediaType - тип вложения по содержимому файла: картинки отправляются как фото, mp4 как видео, остальное документом
+func detectMediaType(contentType string) string {
+	switch contentType {
+	case "image/jpeg", "image/png", "image/webp":
+		return MediaPhoto
+	case "video/mp4":
+		return MediaVideo
+	}
+	return MediaDocument
+}
+
+// broadcastMediaUploadHandler - POST /admin/broadcast/media: загрузка файла для рассылки (multipart, поле file,
+// необязательное поле type)
+func (s *Server) broadcastMediaUploadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, mediaMaxFileSize+1<<20)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("File is required (max %d MB)", mediaMaxFileSize>>20))
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Failed to read file")
+		return
+	}
+
+	contentType := http.DetectContentType(data)
+	mediaType := r.FormValue("type")
+	if mediaType == "" {
+		mediaType = detectMediaType(contentType)
+	}
+	if _, ok := telegramMediaMethods[mediaType]; !ok {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Invalid media type %q (available: photo, video, document)", mediaType))
+		return
+	}
+
+	maxSize := mediaMaxFileSize
+	if mediaType == MediaPhoto {
+		maxSize = mediaMaxPhotoSize
+	}
+	if len(data) == 0 || len(data) > maxSize {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("File must be non-empty and at most %d MB for %s", maxSize>>20, mediaType))
+		return
+	}
+
+	fileName := filepath.Base(header.Filename)
+	setAuditTarget(r.Context(), fileName)
+	setAuditDetail(r.Context(), "size", len(data))
+
+	current := sessionFromContext(r.Context())
+	upload := MediaUpload{Type: mediaType, FileName: fileName, ContentType: contentType, Size: int64(len(data))}
+	err = s.db.QueryRow(r.Context(),
+		`INSERT INTO admin_broadcast_media (type, file_name, content_type, size, data, created_by_name)
+		 VALUES ($1, $2, $3, $4, $5, $6)
+		 RETURNING id, created_at`,
+		upload.Type, upload.FileName, upload.ContentType, upload.Size, data, current.Username,
+	).Scan(&upload.ID, &upload.CreatedAt)
+
+	response := map[string]interface{}{
+		"success": err == nil,
+	}
+
+	if err != nil {
+		slog.Error("Failed to save media upload", "file_name", fileName, "error", err)
+		response["error"] = err.Error()
+	} else {
+		log.Printf("📎 %s загрузил файл для рассылки: %s (%d байт)", current.Username, fileName, len(data))
+		response["upload"] = upload
+		response["media"] = BroadcastMedia{Type: upload.Type, UploadID: upload.ID, FileName: upload.FileName}
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
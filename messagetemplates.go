@@ -0,0 +1,579 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// Виды сохраненных сообщений: именованный шаблон из библиотеки или черновик рассылки
+const (
+	MessageTemplateKind = "template"
+	MessageDraftKind    = "draft"
+)
+
+const (
+	messageTemplateNameMaxLength = 100
+	messageTemplatesListLimit    = 200
+	// messageDraftMaxTextLength - черновик не проверяется как готовое сообщение, ограничиваем только размер
+	messageDraftMaxTextLength = 4 * telegramMaxMessageLength
+)
+
+var (
+	errMessageTemplateNotFound  = errors.New("template not found")
+	errMessageTemplateNameTaken = errors.New("шаблон с таким названием уже есть")
+)
+
+// MessageTemplate - сохраненное содержимое рассылки. Шаблон проверяется как готовое к отправке сообщение,
+// черновик сохраняется как есть и дополнительно помнит сегмент получателей.
+type MessageTemplate struct {
+	ID   int64  `json:"id"`
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	BroadcastContent
+	Segment   *BroadcastSegment `json:"segment,omitempty"`
+	CreatedBy string            `json:"created_by"`
+	CreatedAt time.Time         `json:"created_at"`
+	UpdatedBy string            `json:"updated_by"`
+	UpdatedAt time.Time         `json:"updated_at"`
+}
+
+type MessageTemplateRequest struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	BroadcastContent
+	Segment *BroadcastSegment `json:"segment,omitempty"`
+}
+
+type MessageTemplateResponse struct {
+	Success  bool             `json:"success"`
+	Template *MessageTemplate `json:"template,omitempty"`
+	Error    string           `json:"error,omitempty"`
+}
+
+type MessageTemplatesResponse struct {
+	Success   bool              `json:"success"`
+	Templates []MessageTemplate `json:"templates,omitempty"`
+	Error     string            `json:"error,omitempty"`
+}
+
+const messageTemplateColumns = `id, kind, name, message, messages, fallback_language, media, buttons, segment,
+	created_by_name, created_at, updated_by_name, updated_at`
+
+func scanMessageTemplate(row pgx.Row) (*MessageTemplate, error) {
+	var tpl MessageTemplate
+	var segment []byte
+	content := scanContent(&tpl.BroadcastContent)
+	dest := append([]interface{}{&tpl.ID, &tpl.Kind, &tpl.Name}, content.dest()...)
+	err := row.Scan(append(dest,
+		&segment,
+		&tpl.CreatedBy,
+		&tpl.CreatedAt,
+		&tpl.UpdatedBy,
+		&tpl.UpdatedAt,
+	)...)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(segment) > 0 {
+		if err := json.Unmarshal(segment, &tpl.Segment); err != nil {
+			return nil, fmt.Errorf("failed to parse template segment: %w", err)
+		}
+	}
+	if err := content.parse(); err != nil {
+		return nil, err
+	}
+	return &tpl, nil
+}
+
+// checkMessageTemplate - проверяет запрос: шаблон - как содержимое рассылки, у черновика только размер.
+// Черновик без названия называется по первой строке текста.
+func (s *Server) checkMessageTemplate(ctx context.Context, req *MessageTemplateRequest) error {
+	req.Name = strings.TrimSpace(req.Name)
+	if utf8.RuneCountInString(req.Name) > messageTemplateNameMaxLength {
+		return fmt.Errorf("name is longer than %d characters", messageTemplateNameMaxLength)
+	}
+
+	switch req.Kind {
+	case MessageTemplateKind:
+		if req.Name == "" {
+			return fmt.Errorf("name is required")
+		}
+		req.Segment = nil
+		return s.validateContent(ctx, &req.BroadcastContent)
+
+	case MessageDraftKind:
+		if strings.TrimSpace(req.Message) == "" && len(req.Messages) == 0 && req.Media == nil {
+			return fmt.Errorf("message or media is required")
+		}
+		if len(req.Messages) > broadcastMaxVariants {
+			return fmt.Errorf("too many language variants (max %d)", broadcastMaxVariants)
+		}
+		for _, text := range append([]string{req.Message}, mapValues(req.Messages)...) {
+			if utf8.RuneCountInString(text) > messageDraftMaxTextLength {
+				return fmt.Errorf("draft text is longer than %d characters", messageDraftMaxTextLength)
+			}
+		}
+		if len(req.Buttons) > broadcastMaxButtonRows {
+			return fmt.Errorf("too many button rows (max %d)", broadcastMaxButtonRows)
+		}
+		if req.Name == "" {
+			req.Name = draftName(req.BroadcastContent)
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("invalid kind %q (available: template, draft)", req.Kind)
+	}
+}
+
+// draftName - название черновика по умолчанию: начало первой строки текста
+func draftName(content BroadcastContent) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(content.Message), "\n")
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return "Черновик"
+	}
+	if runes := []rune(line); len(runes) > 60 {
+		line = string(runes[:60]) + "…"
+	}
+	return line
+}
+
+func mapValues(m map[string]string) []string {
+	values := make([]string, 0, len(m))
+	for _, value := range m {
+		values = append(values, value)
+	}
+	return values
+}
+
+// values - значения колонок message, messages, fallback_language, media, buttons, segment
+func (req MessageTemplateRequest) values() ([]interface{}, error) {
+	values, err := req.BroadcastContent.columns()
+	if err != nil {
+		return nil, err
+	}
+	segment, err := nullableJSON("segment", req.Segment, req.Segment == nil)
+	if err != nil {
+		return nil, err
+	}
+	return append(values, segment), nil
+}
+
+// checkMessageTemplateName - название шаблона свободно (кроме самого шаблона exceptID); для черновиков не проверяется.
+// Уникальный индекс защищает от гонки, проверка нужна для понятной ошибки.
+func checkMessageTemplateName(ctx context.Context, q pgxQuerier, kind, name string, exceptID int64) error {
+	if kind != MessageTemplateKind {
+		return nil
+	}
+	var taken bool
+	err := q.QueryRow(ctx,
+		`SELECT EXISTS (SELECT 1 FROM admin_message_template WHERE kind = 'template' AND lower(name) = lower($1) AND id <> $2)`,
+		name, exceptID,
+	).Scan(&taken)
+	if err != nil {
+		return fmt.Errorf("failed to check template name: %w", err)
+	}
+	if taken {
+		return errMessageTemplateNameTaken
+	}
+	return nil
+}
+
+// createMessageTemplate - сохраняет шаблон или черновик, возвращает ID
+func (s *Server) createMessageTemplate(ctx context.Context, session *Session, req MessageTemplateRequest) (int64, error) {
+	if err := checkMessageTemplateName(ctx, s.db, req.Kind, req.Name, 0); err != nil {
+		return 0, err
+	}
+	values, err := req.values()
+	if err != nil {
+		return 0, err
+	}
+
+	var id int64
+	err = s.db.QueryRow(ctx,
+		`INSERT INTO admin_message_template
+			(kind, name, message, messages, fallback_language, media, buttons, segment, created_by, created_by_name, updated_by_name)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $10)
+		 RETURNING id`,
+		append(append([]interface{}{req.Kind, req.Name}, values...), session.AdminID, session.Username)...,
+	).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert message template: %w", err)
+	}
+	return id, nil
+}
+
+// updateMessageTemplate - заменяет содержимое; черновик можно сохранить как шаблон, сменив kind.
+// Строка шаблона блокируется, чтобы правило автоматических сообщений не появилось между проверкой и изменением.
+func (s *Server) updateMessageTemplate(ctx context.Context, session *Session, id int64, req MessageTemplateRequest) error {
+	values, err := req.values()
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := lockMessageTemplate(ctx, tx, id); err != nil {
+		return err
+	}
+	if err := checkMessageTemplateName(ctx, tx, req.Kind, req.Name, id); err != nil {
+		return err
+	}
+	if req.Kind != MessageTemplateKind {
+		if err := checkMessageTemplateUnused(ctx, tx, id); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(ctx,
+		`UPDATE admin_message_template
+		 SET kind = $2, name = $3, message = $4, messages = $5, fallback_language = $6, media = $7, buttons = $8, segment = $9,
+		     updated_by_name = $10, updated_at = now()
+		 WHERE id = $1`,
+		append(append([]interface{}{id, req.Kind, req.Name}, values...), session.Username)...,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update message template %d: %w", id, err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit message template %d: %w", id, err)
+	}
+	return nil
+}
+
+// cloneMessageTemplate - копия шаблона или черновика под новым названием (по умолчанию «<название> (копия)»)
+func (s *Server) cloneMessageTemplate(ctx context.Context, session *Session, id int64, name string) (int64, error) {
+	tpl, err := s.getMessageTemplate(ctx, id)
+	if err != nil {
+		return 0, err
+	}
+
+	req := MessageTemplateRequest{
+		Kind:             tpl.Kind,
+		Name:             strings.TrimSpace(name),
+		BroadcastContent: tpl.BroadcastContent,
+		Segment:          tpl.Segment,
+	}
+	if req.Name == "" {
+		req.Name = tpl.Name + " (копия)"
+	}
+	if utf8.RuneCountInString(req.Name) > messageTemplateNameMaxLength {
+		return 0, fmt.Errorf("name is longer than %d characters", messageTemplateNameMaxLength)
+	}
+	return s.createMessageTemplate(ctx, session, req)
+}
+
+// deleteMessageTemplate - удаляет неиспользуемый шаблон; проверка и удаление идут в одной транзакции
+// под блокировкой строки
+func (s *Server) deleteMessageTemplate(ctx context.Context, id int64) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := lockMessageTemplate(ctx, tx, id); err != nil {
+		return err
+	}
+	if err := checkMessageTemplateUnused(ctx, tx, id); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM admin_message_template WHERE id = $1`, id); err != nil {
+		return fmt.Errorf("failed to delete message template %d: %w", id, err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit message template deletion %d: %w", id, err)
+	}
+	return nil
+}
+
+// lockMessageTemplate - блокирует шаблон до конца транзакции; правила автоматических сообщений берут
+// FOR SHARE в checkLifecycleTemplate и ждут, пока шаблон изменят или удалят
+func lockMessageTemplate(ctx context.Context, tx pgx.Tx, id int64) error {
+	err := tx.QueryRow(ctx, `SELECT id FROM admin_message_template WHERE id = $1 FOR UPDATE`, id).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return errMessageTemplateNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to lock message template %d: %w", id, err)
+	}
+	return nil
+}
+
+func (s *Server) getMessageTemplate(ctx context.Context, id int64) (*MessageTemplate, error) {
+	tpl, err := scanMessageTemplate(s.db.QueryRow(ctx,
+		`SELECT `+messageTemplateColumns+` FROM admin_message_template WHERE id = $1`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errMessageTemplateNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load message template %d: %w", id, err)
+	}
+	return tpl, nil
+}
+
+// listMessageTemplates - шаблоны и черновики, недавно измененные первыми; kind и поиск по названию необязательны
+func (s *Server) listMessageTemplates(ctx context.Context, kind, search string) ([]MessageTemplate, error) {
+	rows, err := s.db.Query(ctx,
+		`SELECT `+messageTemplateColumns+`
+		 FROM admin_message_template
+		 WHERE ($1::text = '' OR kind = $1)
+		   AND ($2::text = '' OR name ILIKE '%' || $2 || '%')
+		 ORDER BY updated_at DESC, id DESC
+		 LIMIT $3`,
+		kind, search, messageTemplatesListLimit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query message templates: %w", err)
+	}
+	defer rows.Close()
+
+	var templates []MessageTemplate
+	for rows.Next() {
+		tpl, err := scanMessageTemplate(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan message template: %w", err)
+		}
+		templates = append(templates, *tpl)
+	}
+	return templates, rows.Err()
+}
+
+// messageTemplatesHandler - GET /admin/message-templates: библиотека шаблонов и черновики; фильтры kind, q
+func (s *Server) messageTemplatesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	kind := r.URL.Query().Get("kind")
+	if kind != "" && kind != MessageTemplateKind && kind != MessageDraftKind {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid kind %q (available: template, draft)", kind))
+		return
+	}
+
+	templates, err := s.listMessageTemplates(r.Context(), kind, strings.TrimSpace(r.URL.Query().Get("q")))
+
+	response := MessageTemplatesResponse{
+		Success:   err == nil,
+		Templates: templates,
+	}
+
+	if err != nil {
+		slog.Error("Failed to list message templates", "error", err)
+		response.Error = err.Error()
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// messageTemplateHandler - GET /admin/message-templates/{id}: содержимое для загрузки в форму рассылки
+func (s *Server) messageTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id <= 0 {
+		writeJSONError(w, http.StatusBadRequest, "Invalid template ID")
+		return
+	}
+
+	tpl, err := s.getMessageTemplate(r.Context(), id)
+
+	response := MessageTemplateResponse{
+		Success:  err == nil,
+		Template: tpl,
+	}
+
+	status := http.StatusOK
+
+	if err != nil {
+		if errors.Is(err, errMessageTemplateNotFound) {
+			status = http.StatusNotFound
+		} else {
+			slog.Error("Failed to load message template", "template_id", id, "error", err)
+		}
+		response.Error = err.Error()
+	}
+
+	writeJSON(w, status, response)
+}
+
+// saveMessageTemplateHandler - POST /admin/message-templates/create и /admin/message-templates/{id}/update
+func (s *Server) saveMessageTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var id int64
+	if value := r.PathValue("id"); value != "" {
+		var err error
+		if id, err = strconv.ParseInt(value, 10, 64); err != nil || id <= 0 {
+			writeJSONError(w, http.StatusBadRequest, "Invalid template ID")
+			return
+		}
+	}
+
+	var req MessageTemplateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.checkMessageTemplate(r.Context(), &req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	current := sessionFromContext(r.Context())
+	var err error
+	if id == 0 {
+		id, err = s.createMessageTemplate(r.Context(), current, req)
+	} else {
+		err = s.updateMessageTemplate(r.Context(), current, id, req)
+	}
+	setAuditTarget(r.Context(), fmt.Sprintf("%s:%d", req.Kind, id))
+
+	response := map[string]interface{}{
+		"success": err == nil,
+	}
+
+	status := http.StatusOK
+
+	if err != nil {
+		switch {
+		case errors.Is(err, errMessageTemplateNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, errMessageTemplateNameTaken), errors.Is(err, errMessageTemplateInUse):
+			status = http.StatusConflict
+		default:
+			slog.Error("Failed to save message template", "template_id", id, "error", err)
+		}
+		response["error"] = err.Error()
+	} else {
+		setAuditDetail(r.Context(), "name", req.Name)
+		log.Printf("📚 %s сохранил %s #%d «%s»", current.Username, req.Kind, id, req.Name)
+		response["id"] = id
+		response["name"] = req.Name
+		response["message"] = fmt.Sprintf("Сохранено: «%s»", req.Name)
+	}
+
+	writeJSON(w, status, response)
+}
+
+// cloneMessageTemplateHandler - POST /admin/message-templates/{id}/clone, необязательное новое название в name
+func (s *Server) cloneMessageTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id <= 0 {
+		writeJSONError(w, http.StatusBadRequest, "Invalid template ID")
+		return
+	}
+
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	setAuditTarget(r.Context(), fmt.Sprintf("template:%d", id))
+
+	current := sessionFromContext(r.Context())
+	cloneID, err := s.cloneMessageTemplate(r.Context(), current, id, req.Name)
+
+	response := map[string]interface{}{
+		"success": err == nil,
+	}
+
+	status := http.StatusOK
+
+	if err != nil {
+		switch {
+		case errors.Is(err, errMessageTemplateNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, errMessageTemplateNameTaken):
+			status = http.StatusConflict
+		default:
+			slog.Error("Failed to clone message template", "template_id", id, "error", err)
+		}
+		response["error"] = err.Error()
+	} else {
+		setAuditDetail(r.Context(), "clone_id", cloneID)
+		log.Printf("📚 %s скопировал шаблон #%d в #%d", current.Username, id, cloneID)
+		response["id"] = cloneID
+		response["message"] = fmt.Sprintf("Создана копия #%d", cloneID)
+	}
+
+	writeJSON(w, status, response)
+}
+
+// deleteMessageTemplateHandler - POST /admin/message-templates/{id}/delete
+func (s *Server) deleteMessageTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id <= 0 {
+		writeJSONError(w, http.StatusBadRequest, "Invalid template ID")
+		return
+	}
+
+	setAuditTarget(r.Context(), fmt.Sprintf("template:%d", id))
+
+	err = s.deleteMessageTemplate(r.Context(), id)
+
+	response := map[string]interface{}{
+		"success": err == nil,
+	}
+
+	status := http.StatusOK
+
+	if err != nil {
+		switch {
+		case errors.Is(err, errMessageTemplateNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, errMessageTemplateInUse):
+			status = http.StatusConflict
+		default:
+			slog.Error("Failed to delete message template", "template_id", id, "error", err)
+		}
+		response["error"] = err.Error()
+	} else {
+		current := sessionFromContext(r.Context())
+		log.Printf("🗑️ %s удалил шаблон #%d", current.Username, id)
+		response["message"] = fmt.Sprintf("Шаблон #%d удален", id)
+	}
+
+	writeJSON(w, status, response)
+}
@@ -0,0 +1,488 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// Действия над уже отправленной рассылкой
+const (
+	OperationEdit   = "edit"
+	OperationDelete = "delete"
+)
+
+// Статусы операции над отправленной рассылкой
+const (
+	OperationQueued    = "queued"
+	OperationRunning   = "running"
+	OperationCompleted = "completed"
+	OperationFailed    = "failed"
+)
+
+// telegramDeleteWindow - сколько времени после отправки Telegram разрешает боту удалить сообщение у получателя
+const telegramDeleteWindow = 48 * time.Hour
+
+var errDeleteWindowExpired = errors.New("message is older than 48 hours, Telegram no longer lets the bot delete it")
+
+// deleteWindowCutoff - сообщения, отправленные не позже этого момента, удалить уже нельзя. Одна граница
+// для подсчета в SQL (sent_at <= cutoff) и для проверки перед запросом к Telegram
+func deleteWindowCutoff(now time.Time) time.Time {
+	return now.Add(-telegramDeleteWindow)
+}
+
+// deleteWindowExpired - вышло ли окно удаления у сообщения, отправленного в sentAt
+func deleteWindowExpired(sentAt, now time.Time) bool {
+	return !sentAt.After(deleteWindowCutoff(now))
+}
+
+// operationSourceStatuses - после каких статусов рассылки можно изменить или удалить отправленные сообщения:
+// пока рассылка идет, часть получателей получила бы уже исправленный текст, а часть - нет
+var operationSourceStatuses = []string{BroadcastPaused, BroadcastCompleted, BroadcastCancelled, BroadcastFailed}
+
+// BroadcastOperation - изменение или удаление отправленных сообщений рассылки у всех получателей
+type BroadcastOperation struct {
+	ID          int64      `json:"id"`
+	BroadcastID int64      `json:"broadcast_id"`
+	Action      string     `json:"action"`
+	Status      string     `json:"status"`
+	CreatedBy   string     `json:"created_by"`
+	CreatedAt   time.Time  `json:"created_at"`
+	StartedAt   *time.Time `json:"started_at"`
+	FinishedAt  *time.Time `json:"finished_at"`
+	Total       int        `json:"total"`
+	Done        int        `json:"done"`
+	Failed      int        `json:"failed"`
+	Error       string     `json:"error,omitempty"`
+}
+
+// broadcastOperationColumns - поля операции для scanBroadcastOperation
+const broadcastOperationColumns = `id, broadcast_id, action, status, created_by_name, created_at, started_at, finished_at, total, done, failed, error`
+
+func scanBroadcastOperation(row pgx.Row) (*BroadcastOperation, error) {
+	var op BroadcastOperation
+	err := row.Scan(
+		&op.ID,
+		&op.BroadcastID,
+		&op.Action,
+		&op.Status,
+		&op.CreatedBy,
+		&op.CreatedAt,
+		&op.StartedAt,
+		&op.FinishedAt,
+		&op.Total,
+		&op.Done,
+		&op.Failed,
+		&op.Error,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &op, nil
+}
+
+// createBroadcastOperation - ставит в очередь изменение (content != nil) или удаление отправленных сообщений.
+// Новый текст сразу сохраняется в рассылке, чтобы оставшиеся получатели приостановленной рассылки получили его же.
+// expired - у скольких сообщений вышло окно удаления (deleteWindowCutoff): удалить их не получится, а если таких все, удаление отклоняется.
+func (s *Server) createBroadcastOperation(ctx context.Context, session *Session, id int64, action string, content *BroadcastContent) (opID int64, total, expired int, err error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var status string
+	var active bool
+	err = tx.QueryRow(ctx,
+		`SELECT status, EXISTS (SELECT 1 FROM admin_broadcast_operation
+		                        WHERE broadcast_id = $1 AND status IN ($2, $3))
+		 FROM admin_broadcast
+		 WHERE id = $1
+		 FOR UPDATE`,
+		id, OperationQueued, OperationRunning,
+	).Scan(&status, &active)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, 0, 0, errBroadcastNotFound
+	}
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("failed to query broadcast: %w", err)
+	}
+	if !slices.Contains(operationSourceStatuses, status) {
+		return 0, 0, 0, fmt.Errorf("%w: %s", errBroadcastState, status)
+	}
+	if active {
+		return 0, 0, 0, fmt.Errorf("%w: предыдущее изменение или удаление еще выполняется", errBroadcastState)
+	}
+
+	err = tx.QueryRow(ctx,
+		`SELECT COUNT(*), COUNT(*) FILTER (WHERE sent_at <= $2)
+		 FROM admin_broadcast_recipient
+		 WHERE broadcast_id = $1 AND message_id IS NOT NULL`,
+		id, deleteWindowCutoff(time.Now()),
+	).Scan(&total, &expired)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("failed to count sent messages: %w", err)
+	}
+	if total == 0 {
+		return 0, 0, 0, fmt.Errorf("%w: нет отправленных сообщений", errBroadcastState)
+	}
+	if action != OperationDelete {
+		expired = 0
+	} else if expired == total {
+		return 0, 0, 0, fmt.Errorf("%w: все сообщения отправлены больше 48 часов назад, Telegram не дает боту их удалить", errBroadcastState)
+	}
+
+	// Колонки содержимого: message, messages, fallback_language, media, buttons; у удаления пустые
+	values := []interface{}{"", nil, "", nil, nil}
+	if content != nil {
+		if values, err = content.columns(); err != nil {
+			return 0, 0, 0, err
+		}
+		_, err = tx.Exec(ctx,
+			`UPDATE admin_broadcast SET message = $2, messages = $3, fallback_language = $4, buttons = $5 WHERE id = $1`,
+			id, values[0], values[1], values[2], values[4],
+		)
+		if err != nil {
+			return 0, 0, 0, fmt.Errorf("failed to update broadcast content: %w", err)
+		}
+	}
+
+	err = tx.QueryRow(ctx,
+		`INSERT INTO admin_broadcast_operation (broadcast_id, action, status, total, created_by, created_by_name,
+		                                        message, messages, fallback_language, media, buttons)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		 RETURNING id`,
+		append([]interface{}{id, action, OperationQueued, total, session.AdminID, session.Username}, values...)...,
+	).Scan(&opID)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("failed to create broadcast operation: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, 0, 0, fmt.Errorf("failed to commit broadcast operation: %w", err)
+	}
+
+	s.wakeBroadcastWorker()
+	return opID, total, expired, nil
+}
+
+// listBroadcastOperations - изменения и удаления отправленных сообщений рассылки, от новых к старым
+func (s *Server) listBroadcastOperations(ctx context.Context, id int64) ([]BroadcastOperation, error) {
+	rows, err := s.db.Query(ctx,
+		`SELECT `+broadcastOperationColumns+` FROM admin_broadcast_operation WHERE broadcast_id = $1 ORDER BY id DESC`,
+		id,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query broadcast operations: %w", err)
+	}
+	defer rows.Close()
+
+	var ops []BroadcastOperation
+	for rows.Next() {
+		op, err := scanBroadcastOperation(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan broadcast operation: %w", err)
+		}
+		ops = append(ops, *op)
+	}
+
+	return ops, rows.Err()
+}
+
+// processNextBroadcastOperation - выполняет самую старую незавершенную операцию; false - операций нет.
+// Выполняется тем же воркером, что и рассылки, поэтому делит с ними лимиты Bot API.
+func (s *Server) processNextBroadcastOperation(ctx context.Context) (bool, error) {
+	var id, broadcastID, cursor int64
+	var action string
+	var content broadcastContent
+	row := scanContent(&content.BroadcastContent)
+	err := s.db.QueryRow(ctx,
+		`UPDATE admin_broadcast_operation
+		 SET status = $1, started_at = COALESCE(started_at, now())
+		 WHERE id = (
+		   SELECT id FROM admin_broadcast_operation
+		   WHERE status IN ($2, $1)
+		   ORDER BY id
+		   LIMIT 1
+		 )
+		 RETURNING id, broadcast_id, action, last_telegram_id, message, messages, fallback_language, media, buttons`,
+		OperationRunning, OperationQueued,
+	).Scan(append([]interface{}{&id, &broadcastID, &action, &cursor}, row.dest()...)...)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to pick broadcast operation: %w", err)
+	}
+
+	if !s.telegram.Configured() {
+		return true, s.finishBroadcastOperation(ctx, id, OperationFailed, errTelegramNotConfigured.Error())
+	}
+	if err := row.parse(); err != nil {
+		return true, s.finishBroadcastOperation(ctx, id, OperationFailed, err.Error())
+	}
+
+	log.Printf("✏️ Рассылка #%d: операция %s #%d начата", broadcastID, action, id)
+
+	for {
+		recipients, err := s.sentMessages(ctx, broadcastID, cursor, broadcastBatchSize)
+		if err != nil {
+			return true, err
+		}
+		if len(recipients) == 0 {
+			break
+		}
+
+		for _, recipient := range recipients {
+			if ctx.Err() != nil {
+				return true, nil
+			}
+
+			// Курсор сдвигается до обращения к Telegram: после перезапуска сообщение не обрабатывается повторно
+			cursor = recipient.TelegramID
+			if _, err := s.db.Exec(ctx, `UPDATE admin_broadcast_operation SET last_telegram_id = $2 WHERE id = $1`, id, cursor); err != nil {
+				return true, fmt.Errorf("failed to update operation cursor: %w", err)
+			}
+
+			opErr := s.applyBroadcastOperation(ctx, action, &recipient, &content)
+			if opErr != nil {
+				slog.Error("Failed to apply broadcast operation",
+					"operation_id", id,
+					"action", action,
+					"telegram_id", recipient.TelegramID,
+					"error", opErr)
+			}
+			if err := s.markOperationRecipient(context.WithoutCancel(ctx), id, broadcastID, action, recipient.TelegramID, opErr); err != nil {
+				return true, err
+			}
+		}
+	}
+
+	if err := s.finishBroadcastOperation(ctx, id, OperationCompleted, ""); err != nil {
+		return true, err
+	}
+	log.Printf("✏️ Рассылка #%d: операция %s #%d завершена", broadcastID, action, id)
+	return true, nil
+}
+
+// sentMessage - получатель с ID и временем отправки его сообщения
+type sentMessage struct {
+	Customer
+	MessageID int64
+	SentAt    time.Time
+}
+
+// sentMessages - очередная пачка получателей с отправленным сообщением после курсора
+func (s *Server) sentMessages(ctx context.Context, id, after int64, limit int) ([]sentMessage, error) {
+	rows, err := s.db.Query(ctx,
+		`SELECT r.telegram_id, r.language, r.message_id, r.sent_at, COALESCE(c.id, 0), c.expire_at, c.subscription_link
+		 FROM admin_broadcast_recipient AS r
+		 LEFT JOIN customer AS c ON c.telegram_id = r.telegram_id
+		 WHERE r.broadcast_id = $1 AND r.message_id IS NOT NULL AND r.telegram_id > $2
+		 ORDER BY r.telegram_id
+		 LIMIT $3`,
+		id, after, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sent messages: %w", err)
+	}
+	defer rows.Close()
+
+	var messages []sentMessage
+	for rows.Next() {
+		var m sentMessage
+		err := rows.Scan(&m.TelegramID, &m.Language, &m.MessageID, &m.SentAt, &m.ID, &m.ExpireAt, &m.SubscriptionLink)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan sent message: %w", err)
+		}
+		messages = append(messages, m)
+	}
+
+	return messages, rows.Err()
+}
+
+// applyBroadcastOperation - изменяет или удаляет одно отправленное сообщение. Текст подставляется заново
+// для получателя; неизменившийся текст Telegram отвергает, это не считается ошибкой.
+func (s *Server) applyBroadcastOperation(ctx context.Context, action string, message *sentMessage, content *broadcastContent) error {
+	if action == OperationDelete {
+		return s.deleteSentMessage(ctx, message, time.Now())
+	}
+
+	text, err := content.render(&message.Customer)
+	if err != nil {
+		return err
+	}
+	err = s.telegram.EditMessage(ctx, message.TelegramID, message.MessageID, text, content.Media != nil, buttonsMarkup(content.Buttons))
+
+	var tgErr *TelegramError
+	if errors.As(err, &tgErr) && strings.Contains(tgErr.Description, "message is not modified") {
+		return nil
+	}
+	return err
+}
+
+// deleteSentMessage - удаляет сообщение у получателя. Сообщения с вышедшим окном удаления не отправляются
+// в Telegram вовсе, а его отказ удалить сообщение получает ту же понятную причину вместо текста ошибки API.
+func (s *Server) deleteSentMessage(ctx context.Context, message *sentMessage, now time.Time) error {
+	if deleteWindowExpired(message.SentAt, now) {
+		return errDeleteWindowExpired
+	}
+
+	err := s.telegram.DeleteMessage(ctx, message.TelegramID, message.MessageID)
+
+	var tgErr *TelegramError
+	if errors.As(err, &tgErr) && strings.Contains(tgErr.Description, "message can't be deleted") {
+		return errDeleteWindowExpired
+	}
+	return err
+}
+
+// markOperationRecipient - учитывает результат в счетчиках операции; удаленное сообщение больше не изменяется
+func (s *Server) markOperationRecipient(ctx context.Context, id, broadcastID int64, action string, telegramID int64, opErr error) error {
+	var err error
+	if opErr != nil {
+		_, err = s.db.Exec(ctx,
+			`UPDATE admin_broadcast_operation SET failed = failed + 1, error = $2 WHERE id = $1`,
+			id, opErr.Error(),
+		)
+	} else {
+		_, err = s.db.Exec(ctx, `UPDATE admin_broadcast_operation SET done = done + 1 WHERE id = $1`, id)
+		if err == nil && action == OperationDelete {
+			_, err = s.db.Exec(ctx,
+				`UPDATE admin_broadcast_recipient SET message_id = NULL WHERE broadcast_id = $1 AND telegram_id = $2`,
+				broadcastID, telegramID,
+			)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update broadcast operation: %w", err)
+	}
+	return nil
+}
+
+// finishBroadcastOperation - переводит операцию в конечный статус
+func (s *Server) finishBroadcastOperation(ctx context.Context, id int64, status, errMsg string) error {
+	_, err := s.db.Exec(ctx,
+		`UPDATE admin_broadcast_operation SET status = $2, error = COALESCE(NULLIF($3, ''), error), finished_at = now() WHERE id = $1`,
+		id, status, errMsg,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to finish broadcast operation %d: %w", id, err)
+	}
+	return nil
+}
+
+// broadcastOperationHandler - POST /admin/broadcasts/{id}/edit-sent и /delete-sent: изменение (новые message,
+// messages, fallback_language, buttons) или удаление отправленных сообщений у всех получателей
+func (s *Server) broadcastOperationHandler(action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil || id <= 0 {
+			writeJSONError(w, http.StatusBadRequest, "Invalid broadcast ID")
+			return
+		}
+
+		setAuditTarget(r.Context(), fmt.Sprintf("broadcast:%d", id))
+
+		var content *BroadcastContent
+		if action == OperationEdit {
+			var req BroadcastContent
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid JSON", http.StatusBadRequest)
+				return
+			}
+
+			// Вложение отправленного сообщения не меняется, от него зависит только метод и лимит подписи
+			job, err := s.getBroadcast(r.Context(), id)
+			if err != nil {
+				status := http.StatusInternalServerError
+				if errors.Is(err, errBroadcastNotFound) {
+					status = http.StatusNotFound
+				}
+				writeJSONError(w, status, err.Error())
+				return
+			}
+			req.Media = job.Media
+			if err := s.validateContent(r.Context(), &req); err != nil {
+				writeJSONError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			content = &req
+		}
+
+		current := sessionFromContext(r.Context())
+		opID, total, expired, err := s.createBroadcastOperation(r.Context(), current, id, action, content)
+
+		response := map[string]interface{}{
+			"success": err == nil,
+		}
+
+		status := http.StatusOK
+
+		if err != nil {
+			switch {
+			case errors.Is(err, errBroadcastNotFound):
+				status = http.StatusNotFound
+			case errors.Is(err, errBroadcastState):
+				status = http.StatusConflict
+			}
+			response["error"] = err.Error()
+		} else {
+			setAuditDetail(r.Context(), "operation_id", opID)
+			log.Printf("✏️ %s запустил операцию %s для рассылки #%d: %d сообщений", current.Username, action, id, total)
+			response["operation_id"] = opID
+			response["total"] = total
+			response["message"] = fmt.Sprintf("Операция поставлена в очередь: %d сообщений", total)
+			if expired > 0 {
+				response["expired"] = expired
+				response["message"] = fmt.Sprintf("Операция поставлена в очередь: %d сообщений, из них %d отправлены больше 48 часов назад - Telegram не даст их удалить",
+					total, expired)
+			}
+		}
+
+		writeJSON(w, status, response)
+	}
+}
+
+// broadcastOperationsHandler - GET /admin/broadcasts/{id}/operations: прогресс изменений и удалений
+func (s *Server) broadcastOperationsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id <= 0 {
+		writeJSONError(w, http.StatusBadRequest, "Invalid broadcast ID")
+		return
+	}
+
+	ops, err := s.listBroadcastOperations(r.Context(), id)
+
+	response := map[string]interface{}{
+		"success":    err == nil,
+		"operations": ops,
+	}
+
+	if err != nil {
+		slog.Error("Failed to list broadcast operations", "broadcast_id", id, "error", err)
+		response["error"] = err.Error()
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDeleteSentMessageWindow(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		sentAt    time.Time
+		fault     *FakeBotFault
+		wantErr   error
+		wantCalls int
+	}{
+		{name: "fresh message", sentAt: now.Add(-time.Hour), wantCalls: 1},
+		{name: "just inside the window", sentAt: now.Add(-telegramDeleteWindow + time.Second), wantCalls: 1},
+		{name: "exactly 48 hours", sentAt: now.Add(-telegramDeleteWindow), wantErr: errDeleteWindowExpired},
+		{name: "older than 48 hours", sentAt: now.Add(-telegramDeleteWindow - time.Second), wantErr: errDeleteWindowExpired},
+		{
+			name:      "Telegram refuses to delete",
+			sentAt:    now.Add(-time.Hour),
+			fault:     &FakeBotFault{Method: "deleteMessage", Status: http.StatusBadRequest, Description: "Bad Request: message can't be deleted for everyone"},
+			wantErr:   errDeleteWindowExpired,
+			wantCalls: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeBotAPI()
+			server := httptest.NewServer(api.Handler())
+			defer server.Close()
+			if tt.fault != nil {
+				api.AddFault(*tt.fault)
+			}
+
+			s := &Server{telegram: newTelegramClientWithClock("token", server.URL, newFakeClock().clock())}
+			message := &sentMessage{Customer: Customer{TelegramID: 100}, MessageID: 5, SentAt: tt.sentAt}
+
+			err := s.deleteSentMessage(context.Background(), message, now)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("deleteSentMessage error = %v, want %v", err, tt.wantErr)
+			}
+			if calls := len(api.Calls()); calls != tt.wantCalls {
+				t.Errorf("deleteMessage calls = %d, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"log/slog"
+	"math"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// messageDateLayout - формат даты в тексте рассылки по умолчанию
+const messageDateLayout = "02.01.2006"
+
+// messageRenderMaxBytes - предел текста после подстановки. Шаблон может размножить текст ({{range 1000000}}),
+// поэтому вывод обрывается задолго до того, как займет заметную память; Telegram все равно не примет больше 4096 символов
+const messageRenderMaxBytes = 4 * telegramMaxMessageLength
+
+// errRenderedMessageInvalid - текст после подстановки данных конкретного клиента Telegram не примет:
+// разметка сломана, текст длиннее лимита или пуст. Шаблон проверяется на примере клиента, но реальные
+// данные (длинная ссылка, пустая ветка условия) могут дать другой результат.
+var errRenderedMessageInvalid = errors.New("rendered message is invalid")
+
+var errRenderedMessageTooLarge = fmt.Errorf("rendered message is larger than %d bytes", messageRenderMaxBytes)
+
+// MessageData - данные клиента для подстановки в текст рассылки: {{.ExpireAt}}, {{.DaysLeft}},
+// {{.HasSubscription}}, {{.Expired}}, {{.SubscriptionLink}}, {{.TelegramID}}. Строки уже экранированы для parse_mode HTML.
+type MessageData struct {
+	TelegramID int64
+	ExpireAt   messageDate
+	// DaysLeft - дней до окончания подписки с округлением вверх; после окончания отрицательное с округлением
+	// вниз (в первые сутки после окончания -1), 0 - подписки нет
+	DaysLeft         int
+	HasSubscription  bool // у клиента есть дата окончания подписки
+	Expired          bool // подписка уже закончилась (expire_at не позже текущего момента)
+	SubscriptionLink string
+	Language         string
+}
+
+// messageDate - дата в тексте рассылки: {{.ExpireAt}} печатает дд.мм.гггг, {{.ExpireAt.Format "2006-01-02"}} - в своем формате
+type messageDate struct {
+	t *time.Time
+}
+
+func (d messageDate) String() string {
+	return d.Format(messageDateLayout)
+}
+
+// Format - дата в формате Go; пустая строка, если даты нет
+func (d messageDate) Format(layout string) string {
+	if d.t == nil {
+		return ""
+	}
+	return html.EscapeString(d.t.UTC().Format(layout))
+}
+
+// newMessageData - данные для подстановки из клиента
+func newMessageData(customer *Customer, now time.Time) MessageData {
+	data := MessageData{
+		TelegramID: customer.TelegramID,
+		ExpireAt:   messageDate{t: customer.ExpireAt},
+		Language:   html.EscapeString(customer.Language),
+	}
+	if customer.ExpireAt != nil {
+		data.HasSubscription = true
+		left := customer.ExpireAt.Sub(now)
+		data.Expired = left <= 0
+		if data.Expired {
+			data.DaysLeft = -int(math.Floor(-left.Hours()/24)) - 1
+		} else {
+			data.DaysLeft = int(math.Ceil(left.Hours() / 24))
+		}
+	}
+	if customer.SubscriptionLink != nil {
+		data.SubscriptionLink = html.EscapeString(*customer.SubscriptionLink)
+	}
+	return data
+}
+
+// sampleCustomer - клиент для проверки и предпросмотра шаблона
+func sampleCustomer(now time.Time) *Customer {
+	expireAt := now.Add(7 * 24 * time.Hour)
+	link := "https://example.com/sub/AbCdEf123"
+	return &Customer{
+		TelegramID:       123456789,
+		ExpireAt:         &expireAt,
+		CreatedAt:        now,
+		SubscriptionLink: &link,
+		Language:         "ru",
+	}
+}
+
+// parseMessageTemplate - разбирает текст рассылки как text/template
+func parseMessageTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("message").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid message template: %s", strings.TrimPrefix(err.Error(), "template: "))
+	}
+	return tmpl, nil
+}
+
+// limitedWriter - буфер, который возвращает errRenderedMessageTooLarge вместо записи сверх limit байт
+type limitedWriter struct {
+	b     strings.Builder
+	limit int
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	if w.b.Len()+len(p) > w.limit {
+		return 0, errRenderedMessageTooLarge
+	}
+	return w.b.Write(p)
+}
+
+// executeMessageTemplate - текст рассылки для клиента; вывод больше messageRenderMaxBytes - ошибка
+func executeMessageTemplate(tmpl *template.Template, customer *Customer, now time.Time) (string, error) {
+	w := &limitedWriter{limit: messageRenderMaxBytes}
+	if err := tmpl.Execute(w, newMessageData(customer, now)); err != nil {
+		if errors.Is(err, errRenderedMessageTooLarge) {
+			return "", fmt.Errorf("failed to render message: %w", err)
+		}
+		return "", fmt.Errorf("failed to render message: %s", strings.TrimPrefix(err.Error(), "template: "))
+	}
+	return w.b.String(), nil
+}
+
+// renderMessage - разбирает и подставляет данные клиента в текст
+func renderMessage(text string, customer *Customer, now time.Time) (string, error) {
+	tmpl, err := parseMessageTemplate(text)
+	if err != nil {
+		return "", err
+	}
+	return executeMessageTemplate(tmpl, customer, now)
+}
+
+// validateMessageTemplate - проверяет шаблон и HTML разметку. Разметка проверяется в исходном тексте, чтобы
+// позиция ошибки совпадала с тем, что ввел администратор (подставленные значения экранированы и разметку
+// не меняют), а длина - после подстановки данных примера клиента, как её считает Telegram.
+func validateMessageTemplate(text string, limit int) error {
+	if _, err := validateTelegramHTML(text); err != nil {
+		return err
+	}
+	rendered, err := renderMessage(text, sampleCustomer(time.Now()), time.Now())
+	if err != nil {
+		return err
+	}
+	return validateMessageText(rendered, limit)
+}
+
+// validateMessageText - проверяет разметку и длину готового текста
+func validateMessageText(text string, limit int) error {
+	n, err := validateTelegramHTML(text)
+	if err != nil {
+		return err
+	}
+	if n == 0 && strings.TrimSpace(text) != "" {
+		return fmt.Errorf("message is empty after removing HTML tags")
+	}
+	if n > limit {
+		return fmt.Errorf("message is too long: %d characters after HTML parsing (max %d)", n, limit)
+	}
+	return nil
+}
+
+// render - текст для получателя на его языке с подставленными данными. Шаблоны разбираются один раз на задание.
+// Готовый текст проверяется перед отправкой: при ошибке errRenderedMessageInvalid возвращается и сам текст.
+func (c *broadcastContent) render(customer *Customer) (string, error) {
+	text := c.messageFor(customer.Language)
+
+	tmpl, ok := c.templates[text]
+	if !ok {
+		var err error
+		if tmpl, err = parseMessageTemplate(text); err != nil {
+			return "", err
+		}
+		if c.templates == nil {
+			c.templates = make(map[string]*template.Template)
+		}
+		c.templates[text] = tmpl
+	}
+
+	rendered, err := executeMessageTemplate(tmpl, customer, time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	limit := telegramMaxMessageLength
+	if c.Media != nil {
+		limit = telegramMaxCaptionLength
+	} else if strings.TrimSpace(rendered) == "" {
+		return rendered, fmt.Errorf("%w: message is empty for this customer", errRenderedMessageInvalid)
+	}
+	if err := validateMessageText(rendered, limit); err != nil {
+		return rendered, fmt.Errorf("%w: %w", errRenderedMessageInvalid, err)
+	}
+	return rendered, nil
+}
+
+// MessagePreviewRequest - текст рассылки и клиент для предпросмотра (без customer_id - пример клиента)
+type MessagePreviewRequest struct {
+	BroadcastContent
+	CustomerID int64  `json:"customer_id,omitempty"`
+	Language   string `json:"language,omitempty"` // язык получателя, по умолчанию язык клиента
+}
+
+type MessagePreviewResponse struct {
+	Success  bool      `json:"success"`
+	Text     string    `json:"text,omitempty"`
+	Length   int       `json:"length"` // символов после разбора HTML
+	Customer *Customer `json:"customer,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// broadcastRenderHandler - POST /admin/broadcast/render: текст рассылки с подставленными данными клиента
+func (s *Server) broadcastRenderHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req MessagePreviewRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if err := req.normalizeVariants(); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	customer := sampleCustomer(time.Now())
+	if req.CustomerID != 0 {
+		var err error
+		customer, err = getCustomer(r.Context(), s.db, req.CustomerID, false)
+		if errors.Is(err, errCustomerNotFound) {
+			writeJSONError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		if err != nil {
+			slog.Error("Failed to load customer for message preview", "customer_id", req.CustomerID, "error", err)
+			writeJSONError(w, http.StatusInternalServerError, "Failed to load customer")
+			return
+		}
+	}
+	if req.Language != "" {
+		customer.Language = req.Language
+	}
+
+	// render проверяет разметку и длину готового текста, как их увидит Telegram
+	content := broadcastContent{BroadcastContent: req.BroadcastContent}
+	text, err := content.render(customer)
+	length, _ := validateTelegramHTML(text)
+
+	response := MessagePreviewResponse{
+		Success:  err == nil,
+		Text:     text,
+		Length:   length,
+		Customer: customer,
+	}
+	if err != nil {
+		response.Error = err.Error()
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderMessage(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	expireAt := now.Add(36 * time.Hour)
+	expired := now.Add(-50 * time.Hour)
+	link := `https://sub.example.com/?a=1&b=<x>"`
+
+	customer := &Customer{TelegramID: 42, ExpireAt: &expireAt, SubscriptionLink: &link, Language: "ru"}
+	noSubscription := &Customer{TelegramID: 7, Language: `<b>`}
+	expiredCustomer := &Customer{TelegramID: 8, ExpireAt: &expired}
+
+	tests := []struct {
+		name     string
+		text     string
+		customer *Customer
+		want     string
+		wantErr  string
+	}{
+		{"plain text", "Привет!", customer, "Привет!", ""},
+		{"all placeholders", "{{.TelegramID}} {{.ExpireAt}} {{.DaysLeft}} {{.Language}}", customer, "42 03.03.2024 2 ru", ""},
+		{"custom date format", `{{.ExpireAt.Format "2006-01-02 15:04"}}`, customer, "2024-03-03 00:00", ""},
+
+		// Подставленные значения экранируются и не ломают разметку
+		{"link is escaped", `<a href="{{.SubscriptionLink}}">link</a>`, customer,
+			`<a href="https://sub.example.com/?a=1&amp;b=&lt;x&gt;&#34;">link</a>`, ""},
+		{"language is escaped", "{{.Language}}", noSubscription, "&lt;b&gt;", ""},
+		{"markup of the template is kept", "<b>{{.DaysLeft}}</b> &amp;", customer, "<b>2</b> &amp;", ""},
+
+		// Клиент без подписки: пустые значения
+		{"no expiry date", "[{{.ExpireAt}}] [{{.SubscriptionLink}}] {{.DaysLeft}}", noSubscription, "[] [] 0", ""},
+		{"expired subscription", "{{.DaysLeft}}", expiredCustomer, "-3", ""},
+		{"subscription flags", "{{.HasSubscription}} {{.Expired}}", expiredCustomer, "true true", ""},
+		{"no subscription flags", "{{.HasSubscription}} {{.Expired}}", noSubscription, "false false", ""},
+
+		// Условные блоки
+		{"if branch", "{{if .SubscriptionLink}}Ссылка: {{.SubscriptionLink}}{{else}}Нет ссылки{{end}}", noSubscription, "Нет ссылки", ""},
+		{"comparison", "{{if gt .DaysLeft 0}}Осталось {{.DaysLeft}}{{else}}Истекла{{end}}", expiredCustomer, "Истекла", ""},
+		{"comparison true", "{{if gt .DaysLeft 0}}Осталось {{.DaysLeft}}{{else}}Истекла{{end}}", customer, "Осталось 2", ""},
+		{"language switch", `{{if eq .Language "ru"}}Привет{{else}}Hello{{end}}`, customer, "Привет", ""},
+
+		// Ошибки
+		{"missing placeholder", "Привет, {{.Name}}", customer, "", "can't evaluate field Name"},
+		{"method on a missing placeholder", `{{.Plan.Title}}`, customer, "", "can't evaluate field Plan"},
+		{"unclosed action", "{{.DaysLeft", customer, "", "invalid message template"},
+		{"unclosed if", "{{if .DaysLeft}}x", customer, "", "invalid message template"},
+
+		// Размноженный шаблоном текст обрывается, не занимая память
+		{"huge range", "{{range 1000000000}}xxxxxxxx{{end}}", customer, "", "rendered message is larger than"},
+		{"range within the limit", `{{range 3}}ab{{end}}`, customer, "ababab", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := renderMessage(tt.text, tt.customer, now)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("renderMessage(%q) error = %v, want %q", tt.text, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("renderMessage(%q): %v", tt.text, err)
+			}
+			if got != tt.want {
+				t.Errorf("renderMessage(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderMessageTooLarge(t *testing.T) {
+	customer := &Customer{TelegramID: 1}
+	now := time.Now()
+
+	fits := strings.Repeat("x", messageRenderMaxBytes)
+	if got, err := renderMessage(fits, customer, now); err != nil || len(got) != messageRenderMaxBytes {
+		t.Errorf("renderMessage at the limit = (%d bytes, %v), want the whole text", len(got), err)
+	}
+
+	for _, text := range []string{fits + "x", "{{range 1000000000}}xxxxxxxx{{end}}"} {
+		if _, err := renderMessage(text, customer, now); !errors.Is(err, errRenderedMessageTooLarge) {
+			t.Errorf("renderMessage(%.20q...) error = %v, want errRenderedMessageTooLarge", text, err)
+		}
+	}
+	if err := validateMessageTemplate("{{range 1000000000}}xxxxxxxx{{end}}", telegramMaxMessageLength); !errors.Is(err, errRenderedMessageTooLarge) {
+		t.Errorf("validateMessageTemplate error = %v, want errRenderedMessageTooLarge", err)
+	}
+}
+
+// DaysLeft: 0 только у клиента без подписки; в момент окончания и после него - отрицательное
+func TestMessageDataDaysLeft(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+
+	tests := []struct {
+		name        string
+		expireIn    *time.Duration // nil - подписки нет
+		wantDays    int
+		wantExpired bool
+	}{
+		{name: "no subscription", wantDays: 0},
+		{name: "expires in a week", expireIn: durationPtr(7 * day), wantDays: 7},
+		{name: "expires in 6 days and an hour", expireIn: durationPtr(6*day + time.Hour), wantDays: 7},
+		{name: "expires in a day", expireIn: durationPtr(day), wantDays: 1},
+		{name: "expires in a second", expireIn: durationPtr(time.Second), wantDays: 1},
+		{name: "expires right now", expireIn: durationPtr(0), wantDays: -1, wantExpired: true},
+		{name: "expired a second ago", expireIn: durationPtr(-time.Second), wantDays: -1, wantExpired: true},
+		{name: "expired 23 hours ago", expireIn: durationPtr(-23 * time.Hour), wantDays: -1, wantExpired: true},
+		{name: "expired exactly a day ago", expireIn: durationPtr(-day), wantDays: -2, wantExpired: true},
+		{name: "expired 50 hours ago", expireIn: durationPtr(-50 * time.Hour), wantDays: -3, wantExpired: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			customer := &Customer{TelegramID: 1}
+			if tt.expireIn != nil {
+				expireAt := now.Add(*tt.expireIn)
+				customer.ExpireAt = &expireAt
+			}
+
+			data := newMessageData(customer, now)
+			if data.DaysLeft != tt.wantDays {
+				t.Errorf("DaysLeft = %d, want %d", data.DaysLeft, tt.wantDays)
+			}
+			if data.Expired != tt.wantExpired {
+				t.Errorf("Expired = %v, want %v", data.Expired, tt.wantExpired)
+			}
+			if data.HasSubscription != (tt.expireIn != nil) {
+				t.Errorf("HasSubscription = %v, want %v", data.HasSubscription, tt.expireIn != nil)
+			}
+		})
+	}
+}
+
+func durationPtr(d time.Duration) *time.Duration {
+	return &d
+}
+
+// Готовый текст проверяется для каждого получателя: данные клиента могут дать текст, которого не было на примере
+func TestBroadcastContentRenderValidates(t *testing.T) {
+	longLink := "https://sub.example.com/" + strings.Repeat("a", 200)
+	withLongLink := &Customer{TelegramID: 1, SubscriptionLink: &longLink}
+	withoutLink := &Customer{TelegramID: 2}
+
+	tests := []struct {
+		name     string
+		content  BroadcastContent
+		customer *Customer
+		wantErr  string
+	}{
+		{
+			name:     "fits the message limit",
+			content:  BroadcastContent{Message: strings.Repeat("x", telegramMaxMessageLength-len(longLink)) + "{{.SubscriptionLink}}"},
+			customer: withLongLink,
+		},
+		{
+			name:     "too long after substitution",
+			content:  BroadcastContent{Message: strings.Repeat("x", telegramMaxMessageLength-len(longLink)+1) + "{{.SubscriptionLink}}"},
+			customer: withLongLink,
+			wantErr:  "message is too long",
+		},
+		{
+			name:     "caption limit with media",
+			content:  BroadcastContent{Message: strings.Repeat("x", telegramMaxCaptionLength) + "{{.SubscriptionLink}}", Media: &BroadcastMedia{Type: MediaPhoto}},
+			customer: withLongLink,
+			wantErr:  "message is too long",
+		},
+		{
+			name:     "empty conditional result",
+			content:  BroadcastContent{Message: "{{if .SubscriptionLink}}{{.SubscriptionLink}}{{end}}"},
+			customer: withoutLink,
+			wantErr:  "message is empty",
+		},
+		{
+			name:     "empty caption is allowed with media",
+			content:  BroadcastContent{Message: "{{if .SubscriptionLink}}{{.SubscriptionLink}}{{end}}", Media: &BroadcastMedia{Type: MediaPhoto}},
+			customer: withoutLink,
+		},
+		{
+			name:     "markup only",
+			content:  BroadcastContent{Message: "{{if .SubscriptionLink}}text{{end}}<b></b>"},
+			customer: withoutLink,
+			wantErr:  "empty after removing HTML tags",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := &broadcastContent{BroadcastContent: tt.content}
+			_, err := content.render(tt.customer)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("render: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, errRenderedMessageInvalid) || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("render error = %v, want errRenderedMessageInvalid with %q", err, tt.wantErr)
+			}
+		})
+	}
+}
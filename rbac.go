@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+type Role string
+
+const (
+	RoleViewer     Role = "viewer"
+	RoleSupport    Role = "support"
+	RoleTranslator Role = "translator"
+	RoleOperator   Role = "operator"
+	RoleOwner      Role = "owner"
+)
+
+type Permission string
+
+const (
+	PermViewLogs         Permission = "logs:view"
+	PermViewTranslations Permission = "translations:view"
+	PermEditTranslations Permission = "translations:edit"
+	PermRestartBot       Permission = "bot:restart"
+	PermBroadcast        Permission = "broadcast:send"
+	PermManageSessions   Permission = "sessions:manage"
+	PermManageAdmins     Permission = "admins:manage"
+	PermManageSecurity   Permission = "security:manage"
+	PermViewAudit        Permission = "audit:view"
+	PermViewCustomers    Permission = "customers:view"
+	PermEditCustomers    Permission = "customers:edit"
+)
+
+// allPermissions - все права, владельцу выдаются целиком
+var allPermissions = []Permission{
+	PermViewLogs,
+	PermViewTranslations,
+	PermEditTranslations,
+	PermRestartBot,
+	PermBroadcast,
+	PermManageSessions,
+	PermManageAdmins,
+	PermManageSecurity,
+	PermViewAudit,
+	PermViewCustomers,
+	PermEditCustomers,
+}
+
+// rolePermissions - права каждой роли. Переводчику нужен bot:restart: бот читает ru.json и en.json
+// только при старте, и без перезапуска сохраненные переводы не вступят в силу
+var rolePermissions = map[Role][]Permission{
+	RoleViewer:     {PermViewLogs},
+	RoleSupport:    {PermViewLogs, PermViewTranslations, PermViewCustomers},
+	RoleTranslator: {PermViewTranslations, PermEditTranslations, PermRestartBot},
+	RoleOperator:   {PermViewLogs, PermViewTranslations, PermEditTranslations, PermRestartBot, PermBroadcast, PermViewCustomers, PermEditCustomers},
+	RoleOwner:      allPermissions,
+}
+
+type contextKey string
+
+const sessionContextKey contextKey = "session"
+
+// Valid - известна ли роль
+func (r Role) Valid() bool {
+	_, ok := rolePermissions[r]
+	return ok
+}
+
+// Can - есть ли у роли право
+func (r Role) Can(perm Permission) bool {
+	for _, p := range rolePermissions[r] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRole - проверяет название роли из запроса или флага
+func parseRole(value string) (Role, error) {
+	role := Role(strings.TrimSpace(value))
+	if !role.Valid() {
+		return "", fmt.Errorf("unknown role %q (available: viewer, support, translator, operator, owner)", value)
+	}
+	return role, nil
+}
+
+// sessionFromContext - сессия, положенная в контекст middleware requireAuth
+func sessionFromContext(ctx context.Context) *Session {
+	session, _ := ctx.Value(sessionContextKey).(*Session)
+	return session
+}
+
+// isAPIRequest - ждет ли клиент JSON вместо HTML страницы
+func isAPIRequest(r *http.Request) bool {
+	return r.Header.Get("X-Requested-With") == "XMLHttpRequest" ||
+		r.Header.Get("Content-Type") == "application/json" ||
+		strings.HasPrefix(r.URL.Path, "/admin/")
+}
+
+// writeJSON - JSON ответ API: Content-Type выставляется до статуса, иначе заголовок не попадет в ответ
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeJSONError - JSON ответ с ошибкой в формате остальных API
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]interface{}{
+		"success": false,
+		"error":   message,
+	})
+}
+
+// requireAuth - middleware: пропускает только запросы с действующей сессией и верным CSRF токеном и кладет сессию в контекст
+func (s *Server) requireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, err := s.sessionFromRequest(r)
+		if err != nil {
+			if !errors.Is(err, errNoSession) {
+				slog.Error("Failed to check session", "error", err)
+			}
+
+			// Для AJAX запросов возвращаем JSON ошибку, иначе показываем форму входа
+			if isAPIRequest(r) {
+				writeJSONError(w, http.StatusUnauthorized, "Authentication required")
+			} else {
+				s.showLoginForm(w, "")
+			}
+			return
+		}
+
+		if !checkCSRF(w, r, session) {
+			return
+		}
+
+		next(w, r.WithContext(context.WithValue(r.Context(), sessionContextKey, session)))
+	}
+}
+
+// requirePermission - middleware: как requireAuth, плюс проверка права роли
+func (s *Server) requirePermission(perm Permission, next http.HandlerFunc) http.HandlerFunc {
+	return s.requireAuth(checkPermission(perm, next))
+}
+
+// checkPermission - пропускает запрос, только если у роли сессии из контекста есть право perm
+func checkPermission(perm Permission, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session := sessionFromContext(r.Context())
+
+		// При обязательной 2FA без неё доступна только настройка 2FA и свои сессии
+		if twoFactorEnforced() && !session.TOTPEnabled {
+			writeJSONError(w, http.StatusForbidden, "Включите двухфакторную аутентификацию во вкладке «Безопасность»")
+			return
+		}
+
+		if !session.Role.Can(perm) {
+			log.Printf("⛔ %s (%s) без права %s обратился к %s", session.Username, session.Role, perm, r.URL.Path)
+			if isAPIRequest(r) {
+				writeJSONError(w, http.StatusForbidden, "Недостаточно прав")
+			} else {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+			}
+			return
+		}
+
+		next(w, r)
+	}
+}
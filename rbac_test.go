@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+// Роль с правом доходит до обработчика, без права получает 403; при обязательной 2FA без нее - тоже 403
+func TestCheckPermission(t *testing.T) {
+	tests := []struct {
+		name       string
+		role       Role
+		totp       bool
+		perm       Permission
+		path       string
+		twoFactor  bool
+		wantStatus int
+	}{
+		{name: "viewer reads logs", role: RoleViewer, perm: PermViewLogs, path: "/admin/logs", wantStatus: http.StatusOK},
+		{name: "viewer edits customers", role: RoleViewer, perm: PermEditCustomers, path: "/admin/customers/update", wantStatus: http.StatusForbidden},
+		{name: "support restarts the bot", role: RoleSupport, perm: PermRestartBot, path: "/restart", wantStatus: http.StatusForbidden},
+		{name: "owner manages admins", role: RoleOwner, perm: PermManageAdmins, path: "/admin/admins", wantStatus: http.StatusOK},
+		{name: "unknown role", role: Role("root"), perm: PermViewLogs, path: "/admin/logs", wantStatus: http.StatusForbidden},
+		{name: "2FA required but not enabled", role: RoleOwner, perm: PermViewLogs, path: "/admin/logs", twoFactor: true, wantStatus: http.StatusForbidden},
+		{name: "2FA required and enabled", role: RoleOwner, totp: true, perm: PermViewLogs, path: "/admin/logs", twoFactor: true, wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("REQUIRE_2FA", strconv.FormatBool(tt.twoFactor))
+
+			called := false
+			handler := checkPermission(tt.perm, func(w http.ResponseWriter, r *http.Request) {
+				called = true
+				w.WriteHeader(http.StatusOK)
+			})
+
+			session := &Session{Username: "tester", Role: tt.role, TOTPEnabled: tt.totp}
+			r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			r = r.WithContext(context.WithValue(r.Context(), sessionContextKey, session))
+			w := httptest.NewRecorder()
+			handler(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if called != (tt.wantStatus == http.StatusOK) {
+				t.Errorf("handler called = %v with status %d", called, w.Code)
+			}
+		})
+	}
+}
+
+// testAdminSession - администратор с ролью, его cookie сессии и CSRF токен
+func testAdminSession(t *testing.T, s *Server, username string, role Role) (*http.Cookie, string) {
+	t.Helper()
+	ctx := context.Background()
+
+	admin := &Admin{Username: username, Role: role}
+	err := s.db.QueryRow(ctx,
+		`INSERT INTO admin_user (username, password_hash, role) VALUES ($1, '', $2) RETURNING id`,
+		admin.Username, admin.Role,
+	).Scan(&admin.ID)
+	if err != nil {
+		t.Fatalf("create admin: %v", err)
+	}
+
+	token, err := s.createSession(ctx, admin, httptest.NewRequest(http.MethodPost, "/login", nil))
+	if err != nil {
+		t.Fatalf("createSession: %v", err)
+	}
+	var csrfToken string
+	err = s.db.QueryRow(ctx, `SELECT csrf_token FROM admin_session WHERE token_hash = $1`, hashSessionToken(token)).Scan(&csrfToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &http.Cookie{Name: sessionCookieName, Value: token}, csrfToken
+}
+
+// requirePermission целиком: сессия из cookie, CSRF токен и право роли
+func TestRequirePermission(t *testing.T) {
+	db := testDB(t)
+	s := &Server{db: db}
+
+	viewer, viewerCSRF := testAdminSession(t, s, "viewer", RoleViewer)
+	owner, ownerCSRF := testAdminSession(t, s, "owner", RoleOwner)
+
+	tests := []struct {
+		name       string
+		method     string
+		perm       Permission
+		cookie     *http.Cookie
+		csrf       string
+		twoFactor  bool
+		wantStatus int
+	}{
+		{name: "role has the permission", method: http.MethodGet, perm: PermViewLogs, cookie: viewer, wantStatus: http.StatusOK},
+		{name: "role lacks the permission", method: http.MethodGet, perm: PermViewCustomers, cookie: viewer, wantStatus: http.StatusForbidden},
+		{name: "role lacks the permission for a change", method: http.MethodPost, perm: PermEditCustomers, cookie: viewer, csrf: viewerCSRF, wantStatus: http.StatusForbidden},
+		{name: "owner has every permission", method: http.MethodPost, perm: PermManageAdmins, cookie: owner, csrf: ownerCSRF, wantStatus: http.StatusOK},
+		{name: "owner without a CSRF token", method: http.MethodPost, perm: PermManageAdmins, cookie: owner, wantStatus: http.StatusForbidden},
+		{name: "no session", method: http.MethodGet, perm: PermViewLogs, wantStatus: http.StatusUnauthorized},
+		{name: "unknown session", method: http.MethodGet, perm: PermViewLogs, cookie: &http.Cookie{Name: sessionCookieName, Value: "forged"}, wantStatus: http.StatusUnauthorized},
+		{name: "2FA required but not enabled", method: http.MethodGet, perm: PermViewLogs, cookie: owner, twoFactor: true, wantStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.twoFactor {
+				t.Setenv("REQUIRE_2FA", "true")
+			}
+
+			called := false
+			handler := s.requirePermission(tt.perm, func(w http.ResponseWriter, r *http.Request) {
+				called = true
+				if session := sessionFromContext(r.Context()); session == nil || !session.Role.Can(tt.perm) {
+					t.Errorf("handler got session %+v without %s", session, tt.perm)
+				}
+				w.WriteHeader(http.StatusOK)
+			})
+
+			r := httptest.NewRequest(tt.method, "/admin/test", nil)
+			if tt.cookie != nil {
+				r.AddCookie(tt.cookie)
+			}
+			if tt.csrf != "" {
+				r.Header.Set(csrfHeaderName, tt.csrf)
+			}
+			w := httptest.NewRecorder()
+			handler(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if called != (tt.wantStatus == http.StatusOK) {
+				t.Errorf("handler called = %v with status %d", called, w.Code)
+			}
+		})
+	}
+}
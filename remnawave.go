@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const remnawaveRequestTimeout = 15 * time.Second
+
+var (
+	errRemnawaveNotConfigured = errors.New("Remnawave не настроен: задайте REMNAWAVE_URL и REMNAWAVE_TOKEN")
+	errRemnawaveUserNotFound  = errors.New("пользователь Remnawave с этим Telegram ID не найден")
+	errRemnawaveUserAmbiguous = errors.New("у Telegram ID несколько пользователей Remnawave, и ни у одного нет ссылки клиента")
+)
+
+// RemnawaveUser - часть пользователя Remnawave, нужная панели
+type RemnawaveUser struct {
+	UUID            string    `json:"uuid"`
+	Username        string    `json:"username"`
+	Status          string    `json:"status"`
+	ExpireAt        time.Time `json:"expireAt"`
+	SubscriptionURL string    `json:"subscriptionUrl"`
+}
+
+// RemnawaveError - ответ Remnawave API с ошибкой
+type RemnawaveError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *RemnawaveError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("remnawave: HTTP %d", e.StatusCode)
+	}
+	return fmt.Sprintf("remnawave: HTTP %d: %s", e.StatusCode, e.Message)
+}
+
+// RemnawaveAPI - методы Remnawave API, которые использует панель: подписку клиента выпускает Remnawave,
+// а в customer бот хранит только ее копию (expire_at и subscription_link)
+type RemnawaveAPI interface {
+	Configured() bool
+	UsersByTelegramID(ctx context.Context, telegramID int64) ([]RemnawaveUser, error)
+	UpdateExpireAt(ctx context.Context, uuid string, expireAt time.Time) (*RemnawaveUser, error)
+	RevokeSubscription(ctx context.Context, uuid string) (*RemnawaveUser, error)
+}
+
+// RemnawaveClient - клиент Remnawave API с токеном из REMNAWAVE_TOKEN
+type RemnawaveClient struct {
+	baseURL    string
+	token      string
+	httpClient *http.Client
+}
+
+func newRemnawaveClient(baseURL, token string) *RemnawaveClient {
+	return &RemnawaveClient{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		token:      token,
+		httpClient: &http.Client{Timeout: remnawaveRequestTimeout},
+	}
+}
+
+// Configured - заданы ли адрес панели Remnawave и токен
+func (c *RemnawaveClient) Configured() bool {
+	return c.baseURL != "" && c.token != ""
+}
+
+// UsersByTelegramID - пользователи Remnawave, привязанные к Telegram ID; пусто, если таких нет
+func (c *RemnawaveClient) UsersByTelegramID(ctx context.Context, telegramID int64) ([]RemnawaveUser, error) {
+	var users []RemnawaveUser
+	err := c.call(ctx, http.MethodGet, "/api/users/by-telegram-id/"+strconv.FormatInt(telegramID, 10), nil, &users)
+	var rwErr *RemnawaveError
+	if errors.As(err, &rwErr) && rwErr.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	return users, err
+}
+
+// UpdateExpireAt - новая дата окончания подписки пользователя
+func (c *RemnawaveClient) UpdateExpireAt(ctx context.Context, uuid string, expireAt time.Time) (*RemnawaveUser, error) {
+	body := map[string]string{
+		"uuid":     uuid,
+		"expireAt": expireAt.UTC().Format(time.RFC3339),
+	}
+	var user RemnawaveUser
+	if err := c.call(ctx, http.MethodPatch, "/api/users", body, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// RevokeSubscription - отзывает подписку: старая ссылка перестает работать, Remnawave выпускает новую
+func (c *RemnawaveClient) RevokeSubscription(ctx context.Context, uuid string) (*RemnawaveUser, error) {
+	var user RemnawaveUser
+	if err := c.call(ctx, http.MethodPost, "/api/users/"+url.PathEscape(uuid)+"/actions/revoke", map[string]string{}, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// call - запрос к Remnawave API; ответ приходит в поле response
+func (c *RemnawaveClient) call(ctx context.Context, method, path string, body, result interface{}) error {
+	if !c.Configured() {
+		return errRemnawaveNotConfigured
+	}
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to marshal remnawave request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	// Remnawave принимает только запросы через HTTPS прокси; внутри docker сети панель ходит к нему по HTTP
+	// напрямую и, как и основной бот, передает заголовки прокси сама
+	if strings.HasPrefix(c.baseURL, "http://") {
+		req.Header.Set("X-Forwarded-Proto", "https")
+		req.Header.Set("X-Forwarded-For", "127.0.0.1")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("remnawave request %s %s failed: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	var parsed struct {
+		Response json.RawMessage `json:"response"`
+		Message  string          `json:"message"`
+	}
+	decodeErr := json.NewDecoder(resp.Body).Decode(&parsed)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &RemnawaveError{StatusCode: resp.StatusCode, Message: parsed.Message}
+	}
+	if decodeErr != nil {
+		return fmt.Errorf("failed to parse remnawave response: %w", decodeErr)
+	}
+	if result != nil {
+		if err := json.Unmarshal(parsed.Response, result); err != nil {
+			return fmt.Errorf("failed to parse remnawave response: %w", err)
+		}
+	}
+	return nil
+}
+
+// remnawaveUserFor - пользователь Remnawave клиента: с той же ссылкой подписки, а если ссылки нет
+// или она не совпала - единственный пользователь с его Telegram ID
+func remnawaveUserFor(users []RemnawaveUser, customer *Customer) (*RemnawaveUser, error) {
+	if customer.SubscriptionLink != nil {
+		for i := range users {
+			if users[i].SubscriptionURL == *customer.SubscriptionLink {
+				return &users[i], nil
+			}
+		}
+	}
+	switch len(users) {
+	case 0:
+		return nil, errRemnawaveUserNotFound
+	case 1:
+		return &users[0], nil
+	default:
+		return nil, errRemnawaveUserAmbiguous
+	}
+}
+
+// remnawaveUser - находит пользователя Remnawave клиента
+func (s *Server) remnawaveUser(ctx context.Context, customer *Customer) (*RemnawaveUser, error) {
+	users, err := s.remnawave.UsersByTelegramID(ctx, customer.TelegramID)
+	if err != nil {
+		return nil, err
+	}
+	return remnawaveUserFor(users, customer)
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// remnawaveTestClient - клиент к httptest серверу, который проверяет запрос и отвечает status и body
+func remnawaveTestClient(t *testing.T, check func(r *http.Request, body []byte), status int, body string) *RemnawaveClient {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("read request body: %v", err)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer secret" {
+			t.Errorf("Authorization = %q, want Bearer token", got)
+		}
+		if got := r.Header.Get("X-Forwarded-Proto"); got != "https" {
+			t.Errorf("X-Forwarded-Proto = %q, want https for an http:// panel", got)
+		}
+		if check != nil {
+			check(r, data)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(server.Close)
+
+	return newRemnawaveClient(server.URL+"/", "secret")
+}
+
+func TestRemnawaveClientUpdateExpireAt(t *testing.T) {
+	expireAt := time.Date(2025, 3, 1, 12, 0, 0, 0, time.FixedZone("MSK", 3*60*60))
+	client := remnawaveTestClient(t, func(r *http.Request, body []byte) {
+		if r.Method != http.MethodPatch || r.URL.Path != "/api/users" {
+			t.Errorf("request = %s %s, want PATCH /api/users", r.Method, r.URL.Path)
+		}
+		var req map[string]string
+		if err := json.Unmarshal(body, &req); err != nil {
+			t.Fatalf("request body %q: %v", body, err)
+		}
+		if req["uuid"] != "u-1" || req["expireAt"] != "2025-03-01T09:00:00Z" {
+			t.Errorf("request body = %v, want uuid u-1 and expireAt in UTC", req)
+		}
+	}, http.StatusOK, `{"response": {"uuid": "u-1", "expireAt": "2025-03-01T09:00:00Z"}}`)
+
+	user, err := client.UpdateExpireAt(context.Background(), "u-1", expireAt)
+	if err != nil {
+		t.Fatalf("UpdateExpireAt: %v", err)
+	}
+	if user.UUID != "u-1" || !user.ExpireAt.Equal(expireAt) {
+		t.Errorf("user = %+v, want u-1 expiring %s", user, expireAt)
+	}
+}
+
+func TestRemnawaveClientRevokeSubscription(t *testing.T) {
+	client := remnawaveTestClient(t, func(r *http.Request, body []byte) {
+		if r.Method != http.MethodPost || r.URL.Path != "/api/users/u-1/actions/revoke" {
+			t.Errorf("request = %s %s, want POST /api/users/u-1/actions/revoke", r.Method, r.URL.Path)
+		}
+	}, http.StatusOK, `{"response": {"uuid": "u-1", "subscriptionUrl": "https://sub.example.com/new"}}`)
+
+	user, err := client.RevokeSubscription(context.Background(), "u-1")
+	if err != nil {
+		t.Fatalf("RevokeSubscription: %v", err)
+	}
+	if user.SubscriptionURL != "https://sub.example.com/new" {
+		t.Errorf("subscription URL = %q, want the new link", user.SubscriptionURL)
+	}
+}
+
+func TestRemnawaveClientUsersByTelegramID(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		body      string
+		wantUsers int
+		wantErr   string
+	}{
+		{
+			name:      "found",
+			status:    http.StatusOK,
+			body:      `{"response": [{"uuid": "u-1"}, {"uuid": "u-2"}]}`,
+			wantUsers: 2,
+		},
+		{
+			name:   "not found",
+			status: http.StatusNotFound,
+			body:   `{"message": "Users not found"}`,
+		},
+		{
+			name:    "server error",
+			status:  http.StatusInternalServerError,
+			body:    `{"message": "Internal error"}`,
+			wantErr: "remnawave: HTTP 500: Internal error",
+		},
+		{
+			name:    "error without a body",
+			status:  http.StatusBadGateway,
+			wantErr: "remnawave: HTTP 502",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := remnawaveTestClient(t, func(r *http.Request, body []byte) {
+				if r.Method != http.MethodGet || r.URL.Path != "/api/users/by-telegram-id/42" {
+					t.Errorf("request = %s %s, want GET /api/users/by-telegram-id/42", r.Method, r.URL.Path)
+				}
+			}, tt.status, tt.body)
+
+			users, err := client.UsersByTelegramID(context.Background(), 42)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("UsersByTelegramID error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("UsersByTelegramID: %v", err)
+			}
+			if len(users) != tt.wantUsers {
+				t.Errorf("users = %d, want %d", len(users), tt.wantUsers)
+			}
+		})
+	}
+}
+
+func TestRemnawaveClientNotConfigured(t *testing.T) {
+	client := newRemnawaveClient("", "")
+	if client.Configured() {
+		t.Fatal("client without URL and token is configured")
+	}
+	if _, err := client.UsersByTelegramID(context.Background(), 42); !errors.Is(err, errRemnawaveNotConfigured) {
+		t.Errorf("UsersByTelegramID error = %v, want errRemnawaveNotConfigured", err)
+	}
+}
+
+func TestRemnawaveUserFor(t *testing.T) {
+	link := "https://sub.example.com/b"
+	users := []RemnawaveUser{
+		{UUID: "a", SubscriptionURL: "https://sub.example.com/a"},
+		{UUID: "b", SubscriptionURL: link},
+	}
+	other := "https://sub.example.com/other"
+
+	tests := []struct {
+		name     string
+		users    []RemnawaveUser
+		link     *string
+		wantUUID string
+		wantErr  error
+	}{
+		{name: "matched by link", users: users, link: &link, wantUUID: "b"},
+		{name: "single user without a link", users: users[:1], wantUUID: "a"},
+		{name: "single user with another link", users: users[:1], link: &other, wantUUID: "a"},
+		{name: "several users, no match", users: users, link: &other, wantErr: errRemnawaveUserAmbiguous},
+		{name: "several users without a link", users: users, wantErr: errRemnawaveUserAmbiguous},
+		{name: "no users", wantErr: errRemnawaveUserNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, err := remnawaveUserFor(tt.users, &Customer{SubscriptionLink: tt.link})
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("remnawaveUserFor: %v", err)
+			}
+			if user.UUID != tt.wantUUID {
+				t.Errorf("user = %q, want %q", user.UUID, tt.wantUUID)
+			}
+		})
+	}
+}
+
+// fakeRemnawave - RemnawaveAPI в памяти с одним пользователем
+type fakeRemnawave struct {
+	user      RemnawaveUser
+	updateErr error
+	revoked   int
+}
+
+func (f *fakeRemnawave) Configured() bool { return true }
+
+func (f *fakeRemnawave) UsersByTelegramID(ctx context.Context, telegramID int64) ([]RemnawaveUser, error) {
+	return []RemnawaveUser{f.user}, nil
+}
+
+func (f *fakeRemnawave) UpdateExpireAt(ctx context.Context, uuid string, expireAt time.Time) (*RemnawaveUser, error) {
+	if f.updateErr != nil {
+		return nil, f.updateErr
+	}
+	f.user.ExpireAt = expireAt
+	return &f.user, nil
+}
+
+func (f *fakeRemnawave) RevokeSubscription(ctx context.Context, uuid string) (*RemnawaveUser, error) {
+	f.revoked++
+	f.user.SubscriptionURL = "https://sub.example.com/revoked"
+	return &f.user, nil
+}
+
+// Дата окончания меняется в Remnawave вместе с БД бота, ошибка Remnawave откатывает изменение,
+// а новая ссылка сохраняется после отзыва подписки
+func TestUpdateCustomerSyncsRemnawave(t *testing.T) {
+	db := testDB(t)
+	remnawave := &fakeRemnawave{user: RemnawaveUser{UUID: "u-1"}}
+	s := &Server{db: db, remnawave: remnawave}
+	session := testSession(t, db)
+	ctx := context.Background()
+
+	expireAt := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	customerID := testCustomer(t, s, 100, expireAt)
+
+	newExpireAt := expireAt.AddDate(0, 1, 0)
+	if _, err := s.updateCustomer(ctx, session, UpdateCustomerRequest{ID: customerID, Action: CustomerActionSetExpire, ExpireAt: &newExpireAt}); err != nil {
+		t.Fatalf("set_expire: %v", err)
+	}
+	if !remnawave.user.ExpireAt.Equal(newExpireAt) {
+		t.Errorf("Remnawave expireAt = %s, want %s", remnawave.user.ExpireAt, newExpireAt)
+	}
+
+	remnawave.updateErr = &RemnawaveError{StatusCode: http.StatusInternalServerError}
+	if _, err := s.updateCustomer(ctx, session, UpdateCustomerRequest{ID: customerID, Action: CustomerActionExtend, Days: 30}); err == nil {
+		t.Fatal("extend succeeded although Remnawave failed")
+	}
+	customer, err := getCustomer(ctx, db, customerID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !customer.ExpireAt.Equal(newExpireAt) {
+		t.Errorf("expire_at after a Remnawave error = %s, want %s", customer.ExpireAt, newExpireAt)
+	}
+
+	if _, err := s.updateCustomer(ctx, session, UpdateCustomerRequest{ID: customerID, Action: CustomerActionRegenerateLink}); err != nil {
+		t.Fatalf("regenerate_link: %v", err)
+	}
+	customer, err = getCustomer(ctx, db, customerID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remnawave.revoked != 1 || customer.SubscriptionLink == nil || *customer.SubscriptionLink != "https://sub.example.com/revoked" {
+		t.Errorf("after regenerate_link: revoked %d times, link %v", remnawave.revoked, customer.SubscriptionLink)
+	}
+}
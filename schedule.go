@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // в alpine образе нет базы часовых поясов
+
+	"github.com/jackc/pgx/v4"
+)
+
+const (
+	broadcastScheduleInterval = 10 * time.Second
+	broadcastMaxScheduleAhead = 365 * 24 * time.Hour
+)
+
+// broadcastScheduleLayouts - форматы времени без часового пояса; пояс берется из поля timezone
+var broadcastScheduleLayouts = []string{
+	"2006-01-02T15:04",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04",
+}
+
+// parseBroadcastSchedule - время запланированной рассылки. value - локальное время в часовом поясе timezone
+// (IANA, например Europe/Moscow, по умолчанию UTC) или RFC3339 с явным смещением.
+// Пустое value означает немедленную отправку.
+func parseBroadcastSchedule(value, timezone string, now time.Time) (*time.Time, string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, "", nil
+	}
+
+	timezone = strings.TrimSpace(timezone)
+	if timezone == "" {
+		timezone = "UTC"
+	}
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, "", fmt.Errorf("unknown timezone %q", timezone)
+	}
+
+	scheduledAt, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		for _, layout := range broadcastScheduleLayouts {
+			var wall time.Time
+			if wall, err = time.Parse(layout, value); err == nil {
+				scheduledAt, err = inLocation(wall, location)
+				break
+			}
+		}
+		if errors.Is(err, errNonexistentLocalTime) {
+			return nil, "", fmt.Errorf("времени %s нет в часовом поясе %s: часы в этот момент переводятся вперед", value, timezone)
+		}
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid scheduled_at value %q", value)
+	}
+
+	if !scheduledAt.After(now) {
+		return nil, "", fmt.Errorf("время рассылки уже прошло")
+	}
+	if scheduledAt.Sub(now) > broadcastMaxScheduleAhead {
+		return nil, "", fmt.Errorf("рассылку можно запланировать не больше чем на год вперед")
+	}
+
+	scheduledAt = scheduledAt.In(location)
+	return &scheduledAt, timezone, nil
+}
+
+var errNonexistentLocalTime = errors.New("local time does not exist")
+
+// inLocation - момент, когда в поясе location наступает местное время wall (пояс самого wall не важен).
+// time.Date при переводе часов выбирает смещение произвольно, поэтому выбираем сами: время, пропущенное
+// при переводе вперед, - ошибка, а повторившееся при переводе назад - первое из двух.
+func inLocation(wall time.Time, location *time.Location) (time.Time, error) {
+	local := time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), wall.Nanosecond(), time.UTC)
+
+	var found []time.Time
+	for _, probe := range []time.Time{local.Add(-24 * time.Hour), local.Add(24 * time.Hour)} {
+		_, offset := probe.In(location).Zone()
+		candidate := local.Add(-time.Duration(offset) * time.Second).In(location)
+		if _, actual := candidate.Zone(); actual == offset {
+			found = append(found, candidate)
+		}
+	}
+
+	if len(found) == 0 {
+		return time.Time{}, errNonexistentLocalTime
+	}
+	if len(found) == 2 && found[1].Before(found[0]) {
+		return found[1], nil
+	}
+	return found[0], nil
+}
+
+// runBroadcastScheduler - запускает запланированные рассылки, время которых наступило.
+// Расписание хранится в БД, поэтому рассылки, пропущенные пока панель была выключена, запускаются после старта.
+func (s *Server) runBroadcastScheduler(ctx context.Context) {
+	log.Printf("🕒 Планировщик рассылок запущен")
+
+	for {
+		if err := s.fireScheduledBroadcasts(ctx); err != nil && ctx.Err() == nil {
+			slog.Error("Broadcast scheduler error", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Printf("🕒 Планировщик рассылок остановлен")
+			return
+		case <-time.After(broadcastScheduleInterval):
+		}
+	}
+}
+
+// fireScheduledBroadcasts - ставит в очередь все запланированные рассылки, время которых наступило
+func (s *Server) fireScheduledBroadcasts(ctx context.Context) error {
+	for {
+		fired, err := s.fireNextScheduledBroadcast(ctx)
+		if err != nil || !fired {
+			return err
+		}
+	}
+}
+
+// fireNextScheduledBroadcast - выбирает получателей для одной наступившей рассылки и ставит её в очередь;
+// false - наступивших рассылок нет. Получатели определяются в момент запуска, а не при планировании.
+func (s *Server) fireNextScheduledBroadcast(ctx context.Context) (bool, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var id int64
+	var segmentJSON []byte
+	var scheduledAt time.Time
+	err = tx.QueryRow(ctx,
+		`SELECT id, segment, scheduled_at FROM admin_broadcast
+		 WHERE status = $1 AND scheduled_at <= now()
+		 ORDER BY scheduled_at, id
+		 LIMIT 1
+		 FOR UPDATE SKIP LOCKED`,
+		BroadcastScheduled,
+	).Scan(&id, &segmentJSON, &scheduledAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to pick scheduled broadcast: %w", err)
+	}
+
+	var segment BroadcastSegment
+	if err := json.Unmarshal(segmentJSON, &segment); err != nil {
+		return false, fmt.Errorf("failed to parse broadcast segment: %w", err)
+	}
+
+	total, _, err := snapshotRecipients(ctx, tx, id, segment, time.Now())
+	if err != nil && !errors.Is(err, errNoRecipients) {
+		return false, err
+	}
+
+	if total == 0 {
+		_, err = tx.Exec(ctx,
+			`UPDATE admin_broadcast SET status = $2, error = $3, finished_at = now() WHERE id = $1`,
+			id, BroadcastFailed, errNoRecipients.Error(),
+		)
+	} else {
+		_, err = tx.Exec(ctx, `UPDATE admin_broadcast SET status = $2, total = $3 WHERE id = $1`, id, BroadcastQueued, total)
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to update scheduled broadcast: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return false, fmt.Errorf("failed to commit scheduled broadcast: %w", err)
+	}
+
+	if late := time.Since(scheduledAt); late > time.Minute {
+		log.Printf("⚠️ Запланированная рассылка #%d запущена с опозданием на %s", id, late.Round(time.Second))
+	}
+	log.Printf("🕒 Запланированная рассылка #%d поставлена в очередь: %d получателей", id, total)
+
+	s.wakeBroadcastWorker()
+	return true, nil
+}
+
+// updateScheduledBroadcast - изменяет содержимое, сегмент или время рассылки, пока она не запущена
+func (s *Server) updateScheduledBroadcast(ctx context.Context, id int64, req BroadcastRequest) error {
+	scheduledAt, timezone, err := parseBroadcastSchedule(req.ScheduledAt, req.Timezone, time.Now())
+	if err != nil {
+		return err
+	}
+	if scheduledAt == nil {
+		return fmt.Errorf("scheduled_at is required")
+	}
+
+	if _, err := req.Segment.filter(time.Now()); err != nil {
+		return err
+	}
+	if err := s.validateContent(ctx, &req.BroadcastContent); err != nil {
+		return err
+	}
+	if err := s.checkBroadcastTested(ctx, req.BroadcastContent); err != nil {
+		return err
+	}
+
+	segmentJSON, err := json.Marshal(req.Segment)
+	if err != nil {
+		return fmt.Errorf("failed to marshal segment: %w", err)
+	}
+	content, err := req.BroadcastContent.columns()
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var status string
+	err = tx.QueryRow(ctx, `SELECT status FROM admin_broadcast WHERE id = $1 FOR UPDATE`, id).Scan(&status)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return errBroadcastNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to query broadcast: %w", err)
+	}
+	if status != BroadcastScheduled {
+		return fmt.Errorf("%w: %s", errBroadcastState, status)
+	}
+
+	_, err = tx.Exec(ctx,
+		`UPDATE admin_broadcast
+		 SET segment = $2, scheduled_at = $3, timezone = $4,
+		     message = $5, messages = $6, fallback_language = $7, media = $8, buttons = $9
+		 WHERE id = $1`,
+		append([]interface{}{id, string(segmentJSON), *scheduledAt, timezone}, content...)...,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update broadcast: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit broadcast update: %w", err)
+	}
+	return nil
+}
+
+// updateBroadcastHandler - POST /admin/broadcasts/{id}/update: изменение запланированной рассылки
+func (s *Server) updateBroadcastHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id <= 0 {
+		writeJSONError(w, http.StatusBadRequest, "Invalid broadcast ID")
+		return
+	}
+
+	var req BroadcastRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	setAuditTarget(r.Context(), fmt.Sprintf("broadcast:%d", id))
+
+	err = s.updateScheduledBroadcast(r.Context(), id, req)
+
+	response := map[string]interface{}{
+		"success": err == nil,
+	}
+
+	status := http.StatusOK
+
+	if err != nil {
+		switch {
+		case errors.Is(err, errBroadcastNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, errBroadcastState):
+			status = http.StatusConflict
+		}
+		response["error"] = err.Error()
+	} else {
+		current := sessionFromContext(r.Context())
+		log.Printf("🕒 %s изменил запланированную рассылку #%d", current.Username, id)
+		response["message"] = fmt.Sprintf("Рассылка #%d изменена", id)
+	}
+
+	writeJSON(w, status, response)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseBroadcastSchedule(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	utc := func(value string) time.Time {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+
+	tests := []struct {
+		name     string
+		value    string
+		timezone string
+		want     time.Time // нулевое - ошибка
+		wantZone string
+	}{
+		{"default timezone is UTC", "2024-02-01T10:00", "", utc("2024-02-01T10:00:00Z"), "UTC"},
+		{"local time in Moscow", "2024-02-01T10:00", "Europe/Moscow", utc("2024-02-01T07:00:00Z"), "Europe/Moscow"},
+		{"timezone is trimmed", "2024-02-01T10:00", " Europe/Moscow ", utc("2024-02-01T07:00:00Z"), "Europe/Moscow"},
+		{"seconds layout", "2024-02-01T10:00:30", "UTC", utc("2024-02-01T10:00:30Z"), "UTC"},
+		{"space layout", "2024-02-01 10:00", "Asia/Tokyo", utc("2024-02-01T01:00:00Z"), "Asia/Tokyo"},
+		{"RFC3339 offset wins over timezone", "2024-02-01T10:00:00+03:00", "America/New_York", utc("2024-02-01T07:00:00Z"), "America/New_York"},
+
+		// Переход на летнее время: 02:00-03:00 в Берлине 31 марта не существует
+		{"before the spring gap", "2024-03-31T01:59", "Europe/Berlin", utc("2024-03-31T00:59:00Z"), "Europe/Berlin"},
+		{"inside the spring gap", "2024-03-31T02:30", "Europe/Berlin", time.Time{}, ""},
+		{"after the spring gap", "2024-03-31T03:00", "Europe/Berlin", utc("2024-03-31T01:00:00Z"), "Europe/Berlin"},
+		{"inside the New York spring gap", "2024-03-10T02:30", "America/New_York", time.Time{}, ""},
+
+		// Переход на зимнее время: повторяющийся час - берется первое наступление
+		{"repeated hour in Berlin", "2024-10-27T02:30", "Europe/Berlin", utc("2024-10-27T00:30:00Z"), "Europe/Berlin"},
+		{"after the repeated hour", "2024-10-27T03:00", "Europe/Berlin", utc("2024-10-27T02:00:00Z"), "Europe/Berlin"},
+		{"repeated hour in New York", "2024-11-03T01:30", "America/New_York", utc("2024-11-03T05:30:00Z"), "America/New_York"},
+
+		// Граница «сейчас» зависит от пояса: в UTC+14 это местное время уже прошло, в UTC-11 еще нет
+		{"past in UTC+14", "2024-01-01T10:00", "Pacific/Kiritimati", time.Time{}, ""},
+		{"future in UTC-11", "2024-01-01T10:00", "Pacific/Pago_Pago", utc("2024-01-01T21:00:00Z"), "Pacific/Pago_Pago"},
+		{"exactly now", "2024-01-01T03:00", "Europe/Moscow", time.Time{}, ""},
+		{"one second after now", "2024-01-01T03:00:01", "Europe/Moscow", utc("2024-01-01T00:00:01Z"), "Europe/Moscow"},
+
+		// Не больше года вперед
+		{"exactly one year ahead", "2024-12-31T00:00", "UTC", utc("2024-12-31T00:00:00Z"), "UTC"},
+		{"more than a year ahead", "2024-12-31T00:01", "UTC", time.Time{}, ""},
+
+		{"unknown timezone", "2024-02-01T10:00", "Mars/Olympus", time.Time{}, ""},
+		{"garbage", "tomorrow", "UTC", time.Time{}, ""},
+		{"date without time", "2024-02-01", "UTC", time.Time{}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, zone, err := parseBroadcastSchedule(tt.value, tt.timezone, now)
+			if tt.want.IsZero() {
+				if err == nil {
+					t.Fatalf("parseBroadcastSchedule(%q, %q) = %v, want an error", tt.value, tt.timezone, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseBroadcastSchedule(%q, %q): %v", tt.value, tt.timezone, err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("scheduled at %s, want %s", got.UTC(), tt.want)
+			}
+			if zone != tt.wantZone || got.Location().String() != tt.wantZone {
+				t.Errorf("timezone = %q (location %s), want %q", zone, got.Location(), tt.wantZone)
+			}
+		})
+	}
+}
+
+func TestParseBroadcastScheduleEmpty(t *testing.T) {
+	got, zone, err := parseBroadcastSchedule("  ", "Europe/Moscow", time.Now())
+	if got != nil || zone != "" || err != nil {
+		t.Errorf("parseBroadcastSchedule(empty) = (%v, %q, %v), want immediate send", got, zone, err)
+	}
+}
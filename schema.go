@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// pgxQuerier - то общее, что есть у пула и транзакции, для функций, вызываемых в обоих контекстах
+type pgxQuerier interface {
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+// migrations - миграции собственных таблиц админки.
+// Применяются по порядку при старте; уже выпущенные миграции не меняем, новые добавляем в конец.
+var migrations = []string{
+	// 1: учетные записи администраторов
+	`CREATE TABLE IF NOT EXISTS admin_user (
+		id            BIGSERIAL PRIMARY KEY,
+		username      TEXT        NOT NULL UNIQUE,
+		password_hash TEXT        NOT NULL,
+		created_at    TIMESTAMPTZ NOT NULL DEFAULT now(),
+		last_login_at TIMESTAMPTZ
+	)`,
+	// 2: сессии администраторов
+	`CREATE TABLE IF NOT EXISTS admin_session (
+		id           BIGSERIAL PRIMARY KEY,
+		token_hash   TEXT        NOT NULL UNIQUE,
+		admin_id     BIGINT      NOT NULL REFERENCES admin_user (id) ON DELETE CASCADE,
+		created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+		expires_at   TIMESTAMPTZ NOT NULL,
+		last_seen_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		ip           TEXT        NOT NULL DEFAULT '',
+		user_agent   TEXT        NOT NULL DEFAULT '',
+		revoked_at   TIMESTAMPTZ
+	)`,
+	// 3: роли администраторов; уже созданные учетные записи имели полный доступ и становятся владельцами
+	`ALTER TABLE admin_user ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'owner';
+	 ALTER TABLE admin_user ALTER COLUMN role SET DEFAULT 'viewer'`,
+	// 4: двухфакторная аутентификация (TOTP) и коды восстановления
+	`ALTER TABLE admin_user
+		ADD COLUMN IF NOT EXISTS totp_secret     TEXT,
+		ADD COLUMN IF NOT EXISTS totp_enabled_at TIMESTAMPTZ,
+		ADD COLUMN IF NOT EXISTS totp_last_step  BIGINT NOT NULL DEFAULT 0;
+	 CREATE TABLE IF NOT EXISTS admin_recovery_code (
+		id        BIGSERIAL PRIMARY KEY,
+		admin_id  BIGINT      NOT NULL REFERENCES admin_user (id) ON DELETE CASCADE,
+		code_hash TEXT        NOT NULL,
+		used_at   TIMESTAMPTZ
+	 )`,
+	// 5: защита от перебора паролей: счетчики неудач по IP и логину, журнал неудачных входов
+	`CREATE TABLE IF NOT EXISTS admin_login_block (
+		kind            TEXT        NOT NULL,
+		key             TEXT        NOT NULL,
+		failures        INT         NOT NULL DEFAULT 0,
+		last_failure_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		blocked_until   TIMESTAMPTZ,
+		PRIMARY KEY (kind, key)
+	 );
+	 CREATE TABLE IF NOT EXISTS admin_login_failure (
+		id         BIGSERIAL PRIMARY KEY,
+		ip         TEXT        NOT NULL,
+		username   TEXT        NOT NULL,
+		reason     TEXT        NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	 )`,
+	// 6: CSRF токен сессии; у существующих сессий токена нет, поэтому они отзываются и потребуют повторного входа
+	`ALTER TABLE admin_session ADD COLUMN IF NOT EXISTS csrf_token TEXT NOT NULL DEFAULT '';
+	 UPDATE admin_session SET revoked_at = now() WHERE revoked_at IS NULL AND csrf_token = ''`,
+	// 7: журнал аудита действий администраторов
+	`CREATE TABLE IF NOT EXISTS admin_audit (
+		id         BIGSERIAL PRIMARY KEY,
+		admin_id   BIGINT      REFERENCES admin_user (id) ON DELETE SET NULL,
+		username   TEXT        NOT NULL DEFAULT '',
+		ip         TEXT        NOT NULL DEFAULT '',
+		action     TEXT        NOT NULL,
+		target     TEXT        NOT NULL DEFAULT '',
+		details    JSONB,
+		success    BOOLEAN     NOT NULL,
+		status     INT         NOT NULL DEFAULT 0,
+		error      TEXT        NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	 );
+	 CREATE INDEX IF NOT EXISTS admin_audit_created_at_idx ON admin_audit (created_at);
+	 CREATE INDEX IF NOT EXISTS admin_audit_action_idx ON admin_audit (action, id)`,
+	// 8: история изменений клиентов из панели (таблица customer принадлежит боту, поэтому история хранится отдельно)
+	`CREATE TABLE IF NOT EXISTS admin_customer_change (
+		id          BIGSERIAL PRIMARY KEY,
+		customer_id BIGINT      NOT NULL,
+		admin_id    BIGINT      REFERENCES admin_user (id) ON DELETE SET NULL,
+		username    TEXT        NOT NULL,
+		action      TEXT        NOT NULL,
+		field       TEXT        NOT NULL,
+		old_value   TEXT,
+		new_value   TEXT,
+		created_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+	 );
+	 CREATE INDEX IF NOT EXISTS admin_customer_change_customer_idx ON admin_customer_change (customer_id, id)`,
+	// 9: фоновые задания рассылки и состояние доставки каждому получателю
+	`CREATE TABLE IF NOT EXISTS admin_broadcast (
+		id              BIGSERIAL PRIMARY KEY,
+		message         TEXT        NOT NULL,
+		segment         JSONB       NOT NULL DEFAULT '{}',
+		status          TEXT        NOT NULL,
+		created_by      BIGINT      REFERENCES admin_user (id) ON DELETE SET NULL,
+		created_by_name TEXT        NOT NULL DEFAULT '',
+		created_at      TIMESTAMPTZ NOT NULL DEFAULT now(),
+		started_at      TIMESTAMPTZ,
+		finished_at     TIMESTAMPTZ,
+		total           INT         NOT NULL DEFAULT 0,
+		error           TEXT        NOT NULL DEFAULT ''
+	 );
+	 CREATE INDEX IF NOT EXISTS admin_broadcast_status_idx ON admin_broadcast (status, id);
+	 CREATE TABLE IF NOT EXISTS admin_broadcast_recipient (
+		broadcast_id BIGINT      NOT NULL REFERENCES admin_broadcast (id) ON DELETE CASCADE,
+		telegram_id  BIGINT      NOT NULL,
+		status       TEXT        NOT NULL DEFAULT 'pending',
+		error        TEXT        NOT NULL DEFAULT '',
+		sent_at      TIMESTAMPTZ,
+		PRIMARY KEY (broadcast_id, telegram_id)
+	 );
+	 CREATE INDEX IF NOT EXISTS admin_broadcast_recipient_status_idx ON admin_broadcast_recipient (broadcast_id, status)`,
+	// 10: пользователи, заблокировавшие бота или удалившие чат; по умолчанию исключаются из рассылок
+	`CREATE TABLE IF NOT EXISTS admin_blocked_user (
+		telegram_id  BIGINT      PRIMARY KEY,
+		blocked_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+		reason       TEXT        NOT NULL DEFAULT '',
+		broadcast_id BIGINT      REFERENCES admin_broadcast (id) ON DELETE SET NULL
+	 )`,
+	// 11: запланированные рассылки; время хранится в UTC, часовой пояс - для отображения и редактирования
+	`ALTER TABLE admin_broadcast ADD COLUMN IF NOT EXISTS scheduled_at TIMESTAMPTZ;
+	 ALTER TABLE admin_broadcast ADD COLUMN IF NOT EXISTS timezone TEXT NOT NULL DEFAULT '';
+	 CREATE INDEX IF NOT EXISTS admin_broadcast_scheduled_idx ON admin_broadcast (scheduled_at) WHERE status = 'scheduled'`,
+	// 12: вложения и inline кнопки рассылок; файлы, загруженные через панель, хранятся вместе с file_id из Telegram
+	`ALTER TABLE admin_broadcast ADD COLUMN IF NOT EXISTS media JSONB;
+	 ALTER TABLE admin_broadcast ADD COLUMN IF NOT EXISTS buttons JSONB;
+	 CREATE TABLE IF NOT EXISTS admin_broadcast_media (
+		id              BIGSERIAL PRIMARY KEY,
+		type            TEXT        NOT NULL,
+		file_name       TEXT        NOT NULL,
+		content_type    TEXT        NOT NULL,
+		size            BIGINT      NOT NULL,
+		data            BYTEA       NOT NULL,
+		file_id         TEXT        NOT NULL DEFAULT '',
+		created_by_name TEXT        NOT NULL DEFAULT '',
+		created_at      TIMESTAMPTZ NOT NULL DEFAULT now()
+	 )`,
+	// 13: тексты рассылки по языкам; язык получателя запоминается при выборе получателей
+	`ALTER TABLE admin_broadcast ADD COLUMN IF NOT EXISTS messages JSONB;
+	 ALTER TABLE admin_broadcast ADD COLUMN IF NOT EXISTS fallback_language TEXT NOT NULL DEFAULT '';
+	 ALTER TABLE admin_broadcast_recipient ADD COLUMN IF NOT EXISTS language TEXT NOT NULL DEFAULT ''`,
+	// 14: успешные тестовые отправки рассылок администраторам, по отпечатку содержимого
+	`CREATE TABLE IF NOT EXISTS admin_broadcast_test (
+		id              BIGSERIAL PRIMARY KEY,
+		content_hash    TEXT        NOT NULL,
+		admin_id        BIGINT      REFERENCES admin_user (id) ON DELETE SET NULL,
+		created_by_name TEXT        NOT NULL DEFAULT '',
+		created_at      TIMESTAMPTZ NOT NULL DEFAULT now()
+	 );
+	 CREATE INDEX IF NOT EXISTS admin_broadcast_test_hash_idx ON admin_broadcast_test (content_hash, created_at)`,
+	// 15: ID отправленных сообщений и операции изменения или удаления их у всех получателей
+	`ALTER TABLE admin_broadcast_recipient ADD COLUMN IF NOT EXISTS message_id BIGINT;
+	 CREATE TABLE IF NOT EXISTS admin_broadcast_operation (
+		id                BIGSERIAL PRIMARY KEY,
+		broadcast_id      BIGINT      NOT NULL REFERENCES admin_broadcast (id) ON DELETE CASCADE,
+		action            TEXT        NOT NULL,
+		status            TEXT        NOT NULL,
+		message           TEXT        NOT NULL DEFAULT '',
+		messages          JSONB,
+		fallback_language TEXT        NOT NULL DEFAULT '',
+		media             JSONB,
+		buttons           JSONB,
+		total             INT         NOT NULL DEFAULT 0,
+		done              INT         NOT NULL DEFAULT 0,
+		failed            INT         NOT NULL DEFAULT 0,
+		last_telegram_id  BIGINT      NOT NULL DEFAULT 0,
+		error             TEXT        NOT NULL DEFAULT '',
+		created_by        BIGINT      REFERENCES admin_user (id) ON DELETE SET NULL,
+		created_by_name   TEXT        NOT NULL DEFAULT '',
+		created_at        TIMESTAMPTZ NOT NULL DEFAULT now(),
+		started_at        TIMESTAMPTZ,
+		finished_at       TIMESTAMPTZ
+	 );
+	 CREATE INDEX IF NOT EXISTS admin_broadcast_operation_broadcast_idx ON admin_broadcast_operation (broadcast_id, id);
+	 CREATE INDEX IF NOT EXISTS admin_broadcast_operation_status_idx ON admin_broadcast_operation (status, id)`,
+	// 16: библиотека шаблонов сообщений и черновики рассылок; названия шаблонов уникальны без учета регистра
+	`CREATE TABLE IF NOT EXISTS admin_message_template (
+		id                BIGSERIAL PRIMARY KEY,
+		kind              TEXT        NOT NULL,
+		name              TEXT        NOT NULL,
+		message           TEXT        NOT NULL DEFAULT '',
+		messages          JSONB,
+		fallback_language TEXT        NOT NULL DEFAULT '',
+		media             JSONB,
+		buttons           JSONB,
+		segment           JSONB,
+		created_by        BIGINT      REFERENCES admin_user (id) ON DELETE SET NULL,
+		created_by_name   TEXT        NOT NULL DEFAULT '',
+		created_at        TIMESTAMPTZ NOT NULL DEFAULT now(),
+		updated_by_name   TEXT        NOT NULL DEFAULT '',
+		updated_at        TIMESTAMPTZ NOT NULL DEFAULT now()
+	 );
+	 CREATE UNIQUE INDEX IF NOT EXISTS admin_message_template_name_idx ON admin_message_template (lower(name)) WHERE kind = 'template';
+	 CREATE INDEX IF NOT EXISTS admin_message_template_kind_idx ON admin_message_template (kind, updated_at)`,
+	// 17: автоматические сообщения относительно окончания подписки; доставка запоминается на каждый срок подписки
+	// (expire_at), чтобы клиент получил каждое напоминание один раз
+	`CREATE TABLE IF NOT EXISTS admin_lifecycle_rule (
+		id              BIGSERIAL PRIMARY KEY,
+		name            TEXT        NOT NULL,
+		template_id     BIGINT      NOT NULL REFERENCES admin_message_template (id),
+		offset_days     INT         NOT NULL,
+		enabled         BOOLEAN     NOT NULL DEFAULT true,
+		created_by      BIGINT      REFERENCES admin_user (id) ON DELETE SET NULL,
+		created_by_name TEXT        NOT NULL DEFAULT '',
+		created_at      TIMESTAMPTZ NOT NULL DEFAULT now(),
+		updated_by_name TEXT        NOT NULL DEFAULT '',
+		updated_at      TIMESTAMPTZ NOT NULL DEFAULT now()
+	 );
+	 CREATE TABLE IF NOT EXISTS admin_lifecycle_delivery (
+		rule_id     BIGINT      NOT NULL REFERENCES admin_lifecycle_rule (id) ON DELETE CASCADE,
+		customer_id BIGINT      NOT NULL,
+		expire_at   TIMESTAMPTZ NOT NULL,
+		telegram_id BIGINT      NOT NULL,
+		status      TEXT        NOT NULL,
+		error       TEXT        NOT NULL DEFAULT '',
+		message_id  BIGINT,
+		created_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+		sent_at     TIMESTAMPTZ,
+		PRIMARY KEY (rule_id, customer_id, expire_at)
+	 );
+	 CREATE INDEX IF NOT EXISTS admin_lifecycle_delivery_status_idx ON admin_lifecycle_delivery (status)`,
+}
+
+// migrate - применяет недостающие миграции схемы
+func migrate(ctx context.Context, db *pgxpool.Pool) error {
+	_, err := db.Exec(ctx, `CREATE TABLE IF NOT EXISTS admin_schema_migrations (
+		version    INT         PRIMARY KEY,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create migrations table: %w", err)
+	}
+
+	var current int
+	if err := db.QueryRow(ctx, `SELECT COALESCE(MAX(version), 0) FROM admin_schema_migrations`).Scan(&current); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	for version := current + 1; version <= len(migrations); version++ {
+		tx, err := db.Begin(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to begin migration %d: %w", version, err)
+		}
+
+		if _, err := tx.Exec(ctx, migrations[version-1]); err != nil {
+			tx.Rollback(ctx)
+			return fmt.Errorf("failed to apply migration %d: %w", version, err)
+		}
+
+		if _, err := tx.Exec(ctx, `INSERT INTO admin_schema_migrations (version) VALUES ($1)`, version); err != nil {
+			tx.Rollback(ctx)
+			return fmt.Errorf("failed to record migration %d: %w", version, err)
+		}
+
+		if err := tx.Commit(ctx); err != nil {
+			return fmt.Errorf("failed to commit migration %d: %w", version, err)
+		}
+
+		log.Printf("📦 Применена миграция схемы #%d", version)
+	}
+
+	return nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// testCustomerTable - таблица customer принадлежит боту; в тестовой схеме создается ее минимальная копия
+const testCustomerTable = `CREATE TABLE customer (
+	id                BIGSERIAL PRIMARY KEY,
+	telegram_id       BIGINT      NOT NULL UNIQUE,
+	expire_at         TIMESTAMPTZ,
+	created_at        TIMESTAMPTZ NOT NULL DEFAULT now(),
+	subscription_link TEXT,
+	language          TEXT        NOT NULL DEFAULT 'ru'
+)`
+
+// testDB - пул к отдельной схеме в базе TEST_DATABASE_URL с примененными миграциями; схема удаляется после
+// теста. Без TEST_DATABASE_URL тест пропускается.
+func testDB(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+
+	dbURL := os.Getenv("TEST_DATABASE_URL")
+	if dbURL == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	ctx := context.Background()
+
+	admin, err := pgxpool.Connect(ctx, dbURL)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	schema := fmt.Sprintf("test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec(ctx, `CREATE SCHEMA `+schema); err != nil {
+		admin.Close()
+		t.Fatalf("create schema: %v", err)
+	}
+	t.Cleanup(func() {
+		admin.Exec(context.Background(), `DROP SCHEMA `+schema+` CASCADE`)
+		admin.Close()
+	})
+
+	config, err := pgxpool.ParseConfig(dbURL)
+	if err != nil {
+		t.Fatalf("parse TEST_DATABASE_URL: %v", err)
+	}
+	config.ConnConfig.RuntimeParams["search_path"] = schema
+	db, err := pgxpool.ConnectConfig(ctx, config)
+	if err != nil {
+		t.Fatalf("connect to schema: %v", err)
+	}
+	t.Cleanup(db.Close)
+
+	if _, err := db.Exec(ctx, testCustomerTable); err != nil {
+		t.Fatalf("create customer table: %v", err)
+	}
+	if err := migrate(ctx, db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return db
+}
+
+// testSession - сессия администратора, созданного в тестовой базе
+func testSession(t *testing.T, db *pgxpool.Pool) *Session {
+	t.Helper()
+
+	session := &Session{Username: "tester", Role: RoleOwner}
+	err := db.QueryRow(context.Background(),
+		`INSERT INTO admin_user (username, password_hash, role) VALUES ($1, '', $2) RETURNING id`,
+		session.Username, session.Role,
+	).Scan(&session.AdminID)
+	if err != nil {
+		t.Fatalf("create admin: %v", err)
+	}
+	return session
+}
+
+func TestMigrateIdempotent(t *testing.T) {
+	db := testDB(t)
+	if err := migrate(context.Background(), db); err != nil {
+		t.Fatalf("second migrate: %v", err)
+	}
+
+	var version int
+	if err := db.QueryRow(context.Background(), `SELECT MAX(version) FROM admin_schema_migrations`).Scan(&version); err != nil {
+		t.Fatal(err)
+	}
+	if version != len(migrations) {
+		t.Errorf("schema version = %d, want %d", version, len(migrations))
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	segmentMaxExpiringDays = 365
+	segmentMaxTelegramIDs  = 10000
+)
+
+// BroadcastSegment - получатели рассылки. Пустой сегмент означает всех клиентов,
+// заданные условия объединяются через И.
+type BroadcastSegment struct {
+	Language           string     `json:"language,omitempty"`
+	Status             string     `json:"status,omitempty"`               // active, expired, never
+	ExpiringWithinDays int        `json:"expiring_within_days,omitempty"` // активная подписка, которая закончится в ближайшие N дней
+	CreatedFrom        *time.Time `json:"created_from,omitempty"`
+	CreatedTo          *time.Time `json:"created_to,omitempty"`
+	TelegramIDs        []int64    `json:"telegram_ids,omitempty"`
+	IncludeBlocked     bool       `json:"include_blocked,omitempty"` // по умолчанию заблокировавшие бота пропускаются
+}
+
+type BroadcastPreviewRequest struct {
+	Segment BroadcastSegment `json:"segment"`
+}
+
+type BroadcastPreviewResponse struct {
+	Success   bool             `json:"success"`
+	Count     int64            `json:"count"`
+	Blocked   int64            `json:"blocked"`             // исключены из сегмента, потому что заблокировали бота
+	Languages map[string]int64 `json:"languages,omitempty"` // получатели по языкам, для проверки вариантов текста
+	Segment   string           `json:"segment,omitempty"`
+	Error     string           `json:"error,omitempty"`
+}
+
+// filter - проверяет сегмент и переводит его в фильтр клиентов на момент now
+func (seg BroadcastSegment) filter(now time.Time) (CustomerFilter, error) {
+	filter := CustomerFilter{
+		Language: strings.TrimSpace(seg.Language),
+		Status:   seg.Status,
+		Blocked:  CustomerBlockedExclude,
+	}
+	if seg.IncludeBlocked {
+		filter.Blocked = ""
+	}
+
+	switch seg.Status {
+	case "", CustomerStatusActive, CustomerStatusExpired, CustomerStatusNever:
+	default:
+		return filter, fmt.Errorf("invalid status %q (available: active, expired, never)", seg.Status)
+	}
+
+	if seg.ExpiringWithinDays < 0 || seg.ExpiringWithinDays > segmentMaxExpiringDays {
+		return filter, fmt.Errorf("expiring_within_days must be within 0..%d", segmentMaxExpiringDays)
+	}
+	if seg.ExpiringWithinDays > 0 {
+		if seg.Status == CustomerStatusExpired || seg.Status == CustomerStatusNever {
+			return filter, fmt.Errorf("expiring_within_days cannot be combined with status %q", seg.Status)
+		}
+		filter.ExpireFrom = now
+		filter.ExpireTo = now.AddDate(0, 0, seg.ExpiringWithinDays)
+	}
+
+	if seg.CreatedFrom != nil {
+		filter.CreatedFrom = *seg.CreatedFrom
+	}
+	if seg.CreatedTo != nil {
+		filter.CreatedTo = *seg.CreatedTo
+	}
+	if seg.CreatedFrom != nil && seg.CreatedTo != nil && !seg.CreatedFrom.Before(*seg.CreatedTo) {
+		return filter, fmt.Errorf("created_from must be before created_to")
+	}
+
+	if len(seg.TelegramIDs) > segmentMaxTelegramIDs {
+		return filter, fmt.Errorf("too many telegram_ids (max %d)", segmentMaxTelegramIDs)
+	}
+	for _, id := range seg.TelegramIDs {
+		if id <= 0 {
+			return filter, fmt.Errorf("invalid telegram id %d", id)
+		}
+	}
+	if len(seg.TelegramIDs) > 0 {
+		filter.TelegramIDs = seg.TelegramIDs
+	}
+
+	return filter, nil
+}
+
+// String - краткое описание сегмента для журнала и интерфейса
+func (seg BroadcastSegment) String() string {
+	var parts []string
+	if seg.Language != "" {
+		parts = append(parts, "language="+seg.Language)
+	}
+	if seg.Status != "" {
+		parts = append(parts, "status="+seg.Status)
+	}
+	if seg.ExpiringWithinDays > 0 {
+		parts = append(parts, fmt.Sprintf("expiring_within=%dd", seg.ExpiringWithinDays))
+	}
+	if seg.CreatedFrom != nil {
+		parts = append(parts, "created_from="+seg.CreatedFrom.Format("2006-01-02"))
+	}
+	if seg.CreatedTo != nil {
+		parts = append(parts, "created_to="+seg.CreatedTo.Format("2006-01-02"))
+	}
+	if len(seg.TelegramIDs) > 0 {
+		parts = append(parts, fmt.Sprintf("telegram_ids=%d", len(seg.TelegramIDs)))
+	}
+	if seg.IncludeBlocked {
+		parts = append(parts, "include_blocked")
+	}
+
+	if len(parts) == 0 {
+		return "all"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// broadcastPreviewHandler - число получателей сегмента перед отправкой
+func (s *Server) broadcastPreviewHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req BroadcastPreviewRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	filter, err := req.Segment.filter(time.Now())
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	count, err := s.countCustomers(r.Context(), filter)
+
+	var languages map[string]int64
+	if err == nil {
+		languages, err = s.countCustomersByLanguage(r.Context(), filter)
+	}
+
+	var blocked int64
+	if err == nil && filter.Blocked == CustomerBlockedExclude {
+		filter.Blocked = CustomerBlockedOnly
+		blocked, err = s.countCustomers(r.Context(), filter)
+	}
+
+	response := BroadcastPreviewResponse{
+		Success:   err == nil,
+		Count:     count,
+		Blocked:   blocked,
+		Languages: languages,
+		Segment:   req.Segment.String(),
+	}
+
+	if err != nil {
+		slog.Error("Failed to count broadcast recipients", "segment", req.Segment.String(), "error", err)
+		response.Error = err.Error()
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+const (
+	sessionCookieName = "admin_session"
+	sessionTTL        = 12 * time.Hour
+)
+
+var errNoSession = errors.New("no valid session")
+
+type Session struct {
+	ID         int64     `json:"id"`
+	AdminID    int64     `json:"admin_id"`
+	Username   string    `json:"username"`
+	Role       Role      `json:"role"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+	Current    bool      `json:"current"`
+
+	// TOTPEnabled и CSRFToken заполняются только для сессии текущего запроса
+	TOTPEnabled bool   `json:"-"`
+	CSRFToken   string `json:"-"`
+}
+
+type SessionsResponse struct {
+	Success  bool      `json:"success"`
+	Sessions []Session `json:"sessions,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+type RevokeSessionRequest struct {
+	ID int64 `json:"id"`
+}
+
+// newSessionToken - генерирует случайный токен сессии
+func newSessionToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate session token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashSessionToken - в БД храним только хеш токена, чтобы утечка таблицы не давала готовых cookie
+func hashSessionToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// createSession - создает сессию администратора и возвращает токен для cookie
+func (s *Server) createSession(ctx context.Context, admin *Admin, r *http.Request) (string, error) {
+	token, err := newSessionToken()
+	if err != nil {
+		return "", err
+	}
+
+	// CSRF токен генерируется так же, но хранится открыто: он встраивается в страницу панели
+	csrfToken, err := newSessionToken()
+	if err != nil {
+		return "", err
+	}
+
+	// Заодно чистим давно истекшие сессии
+	if _, err := s.db.Exec(ctx, `DELETE FROM admin_session WHERE expires_at < now() - interval '7 days'`); err != nil {
+		slog.Error("Failed to clean up expired sessions", "error", err)
+	}
+
+	query := `INSERT INTO admin_session (token_hash, admin_id, expires_at, ip, user_agent, csrf_token)
+			  VALUES ($1, $2, $3, $4, $5, $6)`
+
+	_, err = s.db.Exec(ctx, query,
+		hashSessionToken(token),
+		admin.ID,
+		time.Now().Add(sessionTTL),
+		clientIP(r),
+		r.UserAgent(),
+		csrfToken,
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to insert session: %w", err)
+	}
+
+	return token, nil
+}
+
+// sessionFromRequest - находит действующую сессию по cookie запроса
+func (s *Server) sessionFromRequest(r *http.Request) (*Session, error) {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil || cookie.Value == "" {
+		return nil, errNoSession
+	}
+
+	query := `UPDATE admin_session AS s
+			  SET last_seen_at = now()
+			  FROM admin_user AS u
+			  WHERE s.admin_id = u.id
+			    AND s.token_hash = $1
+			    AND s.revoked_at IS NULL
+			    AND s.expires_at > now()
+			  RETURNING s.id, s.admin_id, u.username, u.role, s.created_at, s.expires_at, s.last_seen_at, s.ip, s.user_agent,
+			            u.totp_enabled_at IS NOT NULL, s.csrf_token`
+
+	var session Session
+	err = s.db.QueryRow(r.Context(), query, hashSessionToken(cookie.Value)).Scan(
+		&session.ID,
+		&session.AdminID,
+		&session.Username,
+		&session.Role,
+		&session.CreatedAt,
+		&session.ExpiresAt,
+		&session.LastSeenAt,
+		&session.IP,
+		&session.UserAgent,
+		&session.TOTPEnabled,
+		&session.CSRFToken,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errNoSession
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query session: %w", err)
+	}
+
+	session.Current = true
+	return &session, nil
+}
+
+// listActiveSessions - возвращает действующие сессии администратора, при adminID == 0 — всех
+func (s *Server) listActiveSessions(ctx context.Context, adminID int64) ([]Session, error) {
+	query := `SELECT s.id, s.admin_id, u.username, u.role, s.created_at, s.expires_at, s.last_seen_at, s.ip, s.user_agent
+			  FROM admin_session AS s
+			  JOIN admin_user AS u ON u.id = s.admin_id
+			  WHERE s.revoked_at IS NULL AND s.expires_at > now()
+			    AND ($1::bigint = 0 OR s.admin_id = $1)
+			  ORDER BY s.last_seen_at DESC`
+
+	rows, err := s.db.Query(ctx, query, adminID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var sessions []Session
+	for rows.Next() {
+		var session Session
+		err := rows.Scan(
+			&session.ID,
+			&session.AdminID,
+			&session.Username,
+			&session.Role,
+			&session.CreatedAt,
+			&session.ExpiresAt,
+			&session.LastSeenAt,
+			&session.IP,
+			&session.UserAgent,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan session: %w", err)
+		}
+		sessions = append(sessions, session)
+	}
+
+	return sessions, rows.Err()
+}
+
+// revokeSession - отзывает сессию по ID; при adminID != 0 — только если она принадлежит этому администратору
+func (s *Server) revokeSession(ctx context.Context, id, adminID int64) error {
+	query := `UPDATE admin_session SET revoked_at = now()
+			  WHERE id = $1 AND revoked_at IS NULL AND ($2::bigint = 0 OR admin_id = $2)`
+
+	tag, err := s.db.Exec(ctx, query, id, adminID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("session %d not found", id)
+	}
+	return nil
+}
+
+// cookieSecure - нужен ли флаг Secure: COOKIE_SECURE=true/false, иначе по факту HTTPS (с учетом TRUSTED_PROXIES)
+func cookieSecure(r *http.Request) bool {
+	switch getEnv("COOKIE_SECURE", "") {
+	case "true", "1":
+		return true
+	case "false", "0":
+		return false
+	}
+	return requestIsHTTPS(r, trustedProxies())
+}
+
+// setSessionCookie - выставляет cookie сессии
+func setSessionCookie(w http.ResponseWriter, r *http.Request, token string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(sessionTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   cookieSecure(r),
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// clearSessionCookie - удаляет cookie сессии в браузере
+func clearSessionCookie(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   cookieSecure(r),
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// logoutHandler - завершение текущей сессии
+func (s *Server) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session, err := s.sessionFromRequest(r)
+	if err == nil {
+		// Без проверки токена чужой сайт мог бы разлогинивать администратора
+		if !checkCSRF(w, r, session) {
+			return
+		}
+		if err := s.revokeSession(r.Context(), session.ID, session.AdminID); err != nil {
+			slog.Error("Failed to revoke session on logout", "session_id", session.ID, "error", err)
+		} else {
+			log.Printf("🔒 Администратор %s вышел из панели", session.Username)
+			s.recordAudit(r.Context(), session, clientIP(r), AuditEntry{
+				Action:  AuditLogout,
+				Success: true,
+				Status:  http.StatusSeeOther,
+			})
+		}
+	} else if !errors.Is(err, errNoSession) {
+		slog.Error("Failed to load session on logout", "error", err)
+	}
+
+	clearSessionCookie(w, r)
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+// sessionsScope - чьи сессии доступны: свои, или все при праве sessions:manage
+func sessionsScope(session *Session) int64 {
+	if session.Role.Can(PermManageSessions) {
+		return 0
+	}
+	return session.AdminID
+}
+
+// sessionsHandler - список действующих сессий
+func (s *Server) sessionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	current := sessionFromContext(r.Context())
+	sessions, err := s.listActiveSessions(r.Context(), sessionsScope(current))
+
+	response := SessionsResponse{
+		Success:  err == nil,
+		Sessions: sessions,
+	}
+
+	if err != nil {
+		slog.Error("Failed to list sessions", "error", err)
+		response.Error = err.Error()
+	}
+
+	for i := range response.Sessions {
+		response.Sessions[i].Current = response.Sessions[i].ID == current.ID
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// revokeSessionHandler - отзыв сессии администратором
+func (s *Server) revokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req RevokeSessionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if req.ID == 0 {
+		http.Error(w, "Session ID is required", http.StatusBadRequest)
+		return
+	}
+
+	setAuditTarget(r.Context(), fmt.Sprintf("session:%d", req.ID))
+
+	current := sessionFromContext(r.Context())
+	err := s.revokeSession(r.Context(), req.ID, sessionsScope(current))
+
+	response := map[string]interface{}{
+		"success": err == nil,
+	}
+
+	if err != nil {
+		response["error"] = err.Error()
+	} else {
+		log.Printf("🚫 %s отозвал сессию #%d", current.Username, req.ID)
+		response["message"] = fmt.Sprintf("Сессия #%d отозвана", req.ID)
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
@@ -1,289 +1,1966 @@
 
 function clearForm() {
-    document.getElementById("message").value = "";
+    document.getElementById("broadcast-form").reset();
+    document.getElementById("buttons-editor").innerHTML = "";
+    document.getElementById("variants-editor").innerHTML = "";
+    setUploadedMedia(null);
+    document.getElementById("broadcast-timezone").value = BROWSER_TIMEZONE;
     document.getElementById("broadcast-result").style.display = "none";
+    document.getElementById("segment-preview").textContent = "";
+    setEditingBroadcast(0);
+    setLoadedTemplate(null);
 }
 
-document.getElementById("broadcast-form").addEventListener("submit", async function(e) {
+// Часовой пояс браузера - по умолчанию для запланированных рассылок
+const BROWSER_TIMEZONE = Intl.DateTimeFormat().resolvedOptions().timeZone || "UTC";
+
+// Запланированная рассылка, которая сейчас редактируется в форме (0 - создается новая),
+// и отправленная рассылка, текст которой исправляется у всех получателей
+let editingBroadcastId = 0;
+let editingSentBroadcastId = 0;
+
+function setEditingBroadcast(id, sent) {
+    editingBroadcastId = sent ? 0 : id;
+    editingSentBroadcastId = sent ? id : 0;
+    const submitBtn = document.querySelector("#broadcast-form button[type=submit]");
+    if (!submitBtn) return;
+    if (sent && id) submitBtn.textContent = `✏️ Исправить у получателей #${id}`;
+    else submitBtn.textContent = id ? `💾 Сохранить рассылку #${id}` : "🚀 Отправить рассылку";
+}
+
+// Вложение, уже загруженное через панель (или из редактируемой рассылки)
+let uploadedMedia = null;
+
+function setUploadedMedia(media) {
+    uploadedMedia = media;
+    document.getElementById("media-uploaded").textContent = media && media.upload_id
+        ? `Загружен: ${media.file_name || "файл #" + media.upload_id}` : "";
+}
+
+document.getElementById("media-file")?.addEventListener("change", () => setUploadedMedia(null));
+
+// Вложение рассылки из формы: выбранный файл сначала загружается в панель
+async function resolveBroadcastMedia() {
+    const type = document.getElementById("media-type").value;
+    const fileInput = document.getElementById("media-file");
+    const source = document.getElementById("media-source").value.trim();
+
+    if (fileInput.files.length && !uploadedMedia) {
+        const form = new FormData();
+        form.append("file", fileInput.files[0]);
+        if (type) form.append("type", type);
+        const response = await fetch("/admin/broadcast/media", {
+            method: "POST",
+            credentials: "same-origin",
+            headers: { "X-CSRF-Token": CSRF_TOKEN },
+            body: form
+        });
+        const result = await response.json();
+        if (!result.success) throw new Error(result.error);
+        setUploadedMedia(result.media);
+        document.getElementById("media-type").value = result.media.type;
+    }
+    if (uploadedMedia) return uploadedMedia;
+    if (source) return { type: type || "photo", source: source };
+    return null;
+}
+
+// Строка редактора текстов по языкам: код языка и текст
+function addVariantLine(language, message) {
+    const line = document.createElement("div");
+    line.className = "form-group variant-line";
+    line.innerHTML = `
+        <div class="inline-form">
+            <input type="text" class="variant-language" placeholder="en" size="6">
+            <button type="button" class="btn btn-secondary" onclick="this.closest('.variant-line').remove()">✕</button>
+        </div>
+        <textarea class="variant-message" rows="4" placeholder="Текст на этом языке"></textarea>
+    `;
+    line.querySelector(".variant-language").value = language || "";
+    line.querySelector(".variant-message").value = message || "";
+    document.getElementById("variants-editor").appendChild(line);
+}
+
+// Тексты по языкам из редактора
+function broadcastVariants() {
+    const messages = {};
+    for (const line of document.querySelectorAll("#variants-editor .variant-line")) {
+        const language = line.querySelector(".variant-language").value.trim();
+        const message = line.querySelector(".variant-message").value.trim();
+        if (language && message) messages[language] = message;
+    }
+    return messages;
+}
+
+// Заполняет основной текст и тексты по языкам
+function fillBroadcastMessages(content) {
+    document.getElementById("message").value = content.message || "";
+    document.getElementById("message-language").value = content.fallback_language || "";
+    document.getElementById("variants-editor").innerHTML = "";
+    Object.entries(content.messages || {})
+        .filter(([language]) => language !== content.fallback_language)
+        .forEach(([language, message]) => addVariantLine(language, message));
+}
+
+// Строка редактора кнопок: текст, тип (ссылка или callback), значение, перенос на новую строку клавиатуры
+function addButtonLine(button, newRow) {
+    button = button || {};
+    const line = document.createElement("div");
+    line.className = "inline-form button-line";
+    line.innerHTML = `
+        <input type="text" class="button-text" placeholder="Текст кнопки" size="20">
+        <select class="button-kind">
+            <option value="url">Ссылка</option>
+            <option value="callback_data">Callback</option>
+        </select>
+        <input type="text" class="button-value" placeholder="https://... или данные для бота" size="30">
+        <label><input type="checkbox" class="button-new-row"> с новой строки</label>
+        <button type="button" class="btn btn-secondary" onclick="this.parentElement.remove()">✕</button>
+    `;
+    line.querySelector(".button-text").value = button.text || "";
+    line.querySelector(".button-kind").value = button.callback_data ? "callback_data" : "url";
+    line.querySelector(".button-value").value = button.callback_data || button.url || "";
+    line.querySelector(".button-new-row").checked = !!newRow;
+    document.getElementById("buttons-editor").appendChild(line);
+}
+
+// Inline клавиатура из редактора кнопок, по строкам
+function broadcastButtons() {
+    const rows = [];
+    for (const line of document.querySelectorAll("#buttons-editor .button-line")) {
+        const text = line.querySelector(".button-text").value.trim();
+        const value = line.querySelector(".button-value").value.trim();
+        if (!text && !value) continue;
+        const button = { text: text };
+        button[line.querySelector(".button-kind").value] = value;
+        if (!rows.length || line.querySelector(".button-new-row").checked) rows.push([]);
+        rows[rows.length - 1].push(button);
+    }
+    return rows;
+}
+
+// Заполняет вложение и кнопки в форме
+function fillBroadcastContent(media, buttons) {
+    document.getElementById("media-type").value = media ? media.type : "";
+    document.getElementById("media-file").value = "";
+    document.getElementById("media-source").value = media && media.source ? media.source : "";
+    setUploadedMedia(media && media.upload_id ? media : null);
+
+    document.getElementById("buttons-editor").innerHTML = "";
+    (buttons || []).forEach((row, i) => row.forEach((button, j) => addButtonLine(button, i > 0 && j === 0)));
+}
+
+// Тело запроса рассылки из формы (без вложения, его добавляет resolveBroadcastMedia)
+function broadcastPayload() {
+    const payload = {
+        message: document.getElementById("message").value.trim(),
+        segment: broadcastSegment()
+    };
+    const messages = broadcastVariants();
+    if (Object.keys(messages).length) {
+        payload.messages = messages;
+        payload.fallback_language = document.getElementById("message-language").value.trim();
+    }
+    const buttons = broadcastButtons();
+    if (buttons.length) payload.buttons = buttons;
+    const scheduledAt = document.getElementById("broadcast-scheduled-at").value;
+    if (scheduledAt) {
+        payload.scheduled_at = scheduledAt;
+        payload.timezone = document.getElementById("broadcast-timezone").value.trim() || BROWSER_TIMEZONE;
+    }
+    return payload;
+}
+
+// Заполняет поля сегмента в форме
+function fillBroadcastSegment(segment) {
+    const dateValue = value => value ? toLocalInputValue(value).slice(0, 10) : "";
+    const previousDay = value => {
+        const date = new Date(value);
+        date.setDate(date.getDate() - 1);
+        return date.toISOString();
+    };
+    document.getElementById("segment-language").value = segment.language || "";
+    document.getElementById("segment-status").value = segment.status || "";
+    document.getElementById("segment-expiring-days").value = segment.expiring_within_days || "";
+    document.getElementById("segment-created-from").value = dateValue(segment.created_from);
+    document.getElementById("segment-created-to").value = segment.created_to ? dateValue(previousDay(segment.created_to)) : "";
+    document.getElementById("segment-telegram-ids").value = (segment.telegram_ids || []).join(" ");
+    document.getElementById("segment-include-blocked").checked = !!segment.include_blocked;
+}
+
+// Время в часовом поясе рассылки в формате datetime-local
+function toZonedInputValue(value, timezone) {
+    try {
+        return new Date(value).toLocaleString("sv-SE", { timeZone: timezone || "UTC" }).replace(" ", "T").slice(0, 16);
+    } catch (error) {
+        return toLocalInputValue(value);
+    }
+}
+
+// Загружает запланированную рассылку в форму для редактирования
+async function editBroadcast(event, id) {
+    event.stopPropagation();
+    try {
+        const response = await fetch(`/admin/broadcasts/${id}`, {
+            credentials: "same-origin",
+            headers: { "X-Requested-With": "XMLHttpRequest" }
+        });
+        const result = await response.json();
+        if (!result.success) {
+            alert("Ошибка: " + result.error);
+            return;
+        }
+        const job = result.broadcast;
+        fillBroadcastMessages(job);
+        fillBroadcastContent(job.media, job.buttons);
+        fillBroadcastSegment(job.segment || {});
+        document.getElementById("broadcast-scheduled-at").value = toZonedInputValue(job.scheduled_at, job.timezone);
+        document.getElementById("broadcast-timezone").value = job.timezone;
+        setEditingBroadcast(id);
+        document.getElementById("broadcast-form").scrollIntoView({ behavior: "smooth" });
+    } catch (error) {
+        alert("Ошибка сети: " + error.message);
+    }
+}
+
+// Время запланированной рассылки в её часовом поясе
+function formatScheduledAt(job) {
+    try {
+        return new Date(job.scheduled_at).toLocaleString("ru-RU", { timeZone: job.timezone || "UTC" }) + ` (${job.timezone || "UTC"})`;
+    } catch (error) {
+        return formatDate(job.scheduled_at);
+    }
+}
+
+// Сегмент получателей рассылки из формы
+function broadcastSegment() {
+    const segment = {};
+    const language = document.getElementById("segment-language").value.trim();
+    if (language) segment.language = language;
+    const status = document.getElementById("segment-status").value;
+    if (status) segment.status = status;
+    const days = parseInt(document.getElementById("segment-expiring-days").value, 10);
+    if (days > 0) segment.expiring_within_days = days;
+    const createdFrom = document.getElementById("segment-created-from").value;
+    if (createdFrom) segment.created_from = new Date(createdFrom).toISOString();
+    const createdTo = document.getElementById("segment-created-to").value;
+    if (createdTo) segment.created_to = new Date(nextDay(createdTo)).toISOString();
+    const ids = document.getElementById("segment-telegram-ids").value
+        .split(/[\s,;]+/).filter(Boolean).map(Number);
+    if (ids.length) segment.telegram_ids = ids;
+    if (document.getElementById("segment-include-blocked").checked) segment.include_blocked = true;
+    return segment;
+}
+
+// Число получателей сегмента; null при ошибке (она уже показана)
+async function countBroadcastRecipients(segment) {
+    const preview = document.getElementById("segment-preview");
+    try {
+        const result = await postJSON("/admin/broadcast/preview", { segment: segment });
+        if (!result.success) {
+            preview.textContent = "❌ " + result.error;
+            return null;
+        }
+        preview.textContent = `Получателей: ${result.count}` +
+            (result.blocked ? ` (пропущено заблокировавших бота: ${result.blocked})` : "") +
+            (result.languages ? ", по языкам: " + Object.entries(result.languages)
+                .sort((a, b) => b[1] - a[1])
+                .map(([language, count]) => `${language || "не указан"} ${count}`).join(", ") : "");
+        return result.count;
+    } catch (error) {
+        preview.textContent = "❌ Ошибка сети: " + error.message;
+        return null;
+    }
+}
+
+// Текст рассылки с подставленными данными клиента (или примера клиента)
+async function renderBroadcastMessage() {
+    const output = document.getElementById("message-render");
+    const payload = broadcastPayload();
+    const request = { message: payload.message, messages: payload.messages, fallback_language: payload.fallback_language };
+    const customerId = parseInt(document.getElementById("render-customer-id").value, 10);
+    if (customerId > 0) request.customer_id = customerId;
+    const language = document.getElementById("render-language").value.trim();
+    if (language) request.language = language;
+
+    output.style.display = "block";
+    try {
+        const result = await postJSON("/admin/broadcast/render", request);
+        output.textContent = result.success
+            ? `${result.text}\n\n— ${result.length} символов после разбора HTML`
+            : "❌ " + result.error;
+    } catch (error) {
+        output.textContent = "❌ Ошибка сети: " + error.message;
+    }
+}
+
+function previewBroadcast() {
+    countBroadcastRecipients(broadcastSegment());
+}
+
+// Названия статусов заданий рассылки
+const BROADCAST_STATUSES = {
+    scheduled: "🕒 запланирована",
+    queued: "⏳ в очереди",
+    running: "📨 отправляется",
+    paused: "⏸️ на паузе",
+    completed: "✅ завершена",
+    cancelled: "🚫 отменена",
+    failed: "❌ ошибка"
+};
+
+// Кнопки управления заданием рассылки в зависимости от его статуса
+function broadcastControls(job) {
+    const buttons = [];
+    if (job.status === "scheduled") {
+        buttons.push(`<button class="btn btn-secondary" onclick="editBroadcast(event, ${job.id})">✏️ Изменить</button>`);
+    }
+    if (job.status === "queued" || job.status === "running") {
+        buttons.push(`<button class="btn btn-secondary" onclick="controlBroadcast(event, ${job.id}, 'pause')">⏸️ Пауза</button>`);
+    }
+    if (job.status === "paused") {
+        buttons.push(`<button class="btn btn-primary" onclick="controlBroadcast(event, ${job.id}, 'resume')">▶️ Продолжить</button>`);
+    }
+    if (job.status === "scheduled" || job.status === "queued" || job.status === "running" || job.status === "paused") {
+        buttons.push(`<button class="btn btn-secondary" onclick="controlBroadcast(event, ${job.id}, 'cancel')">🚫 Отменить</button>`);
+    }
+    if (job.total > 0) {
+        buttons.push(`<button class="btn btn-secondary" onclick="showBroadcastReport(event, ${job.id})">📋 Отчет</button>`);
+    }
+    if (job.sent > 0 && ["paused", "completed", "cancelled", "failed"].includes(job.status)) {
+        buttons.push(`<button class="btn btn-secondary" onclick="editSentBroadcast(event, ${job.id})">✏️ Исправить у всех</button>`);
+        buttons.push(`<button class="btn btn-secondary" onclick="deleteSentBroadcast(event, ${job.id})">🗑️ Удалить у всех</button>`);
+    }
+    return buttons.join(" ");
+}
+
+// Загружает текст и кнопки отправленной рассылки в форму, чтобы исправить их у всех получателей
+async function editSentBroadcast(event, id) {
+    event.stopPropagation();
+    try {
+        const response = await fetch(`/admin/broadcasts/${id}`, {
+            credentials: "same-origin",
+            headers: { "X-Requested-With": "XMLHttpRequest" }
+        });
+        const result = await response.json();
+        if (!result.success) {
+            alert("Ошибка: " + result.error);
+            return;
+        }
+        const job = result.broadcast;
+        fillBroadcastMessages(job);
+        fillBroadcastContent(job.media, job.buttons);
+        setEditingBroadcast(id, true);
+        document.getElementById("broadcast-form").scrollIntoView({ behavior: "smooth" });
+    } catch (error) {
+        alert("Ошибка сети: " + error.message);
+    }
+}
+
+// Удаляет отправленные сообщения рассылки из чатов получателей
+async function deleteSentBroadcast(event, id) {
+    event.stopPropagation();
+    if (!confirm(`Удалить сообщения рассылки #${id} у всех получателей? Telegram позволяет боту удалять сообщения только в течение 48 часов.`)) return;
+
+    try {
+        const result = await postJSON(`/admin/broadcasts/${id}/delete-sent`, {});
+        if (!result.success) {
+            alert("Ошибка: " + result.error);
+            return;
+        }
+        if (result.expired) alert(result.message);
+        loadBroadcastReport(id);
+    } catch (error) {
+        alert("Ошибка сети: " + error.message);
+    }
+}
+
+// Названия операций над отправленной рассылкой
+const OPERATION_ACTIONS = { edit: "✏️ Исправление", delete: "🗑️ Удаление" };
+
+// Прогресс исправлений и удалений отправленной рассылки; пока операция выполняется, обновляется сам
+let operationsTimer = null;
+
+async function loadBroadcastOperations(id) {
+    clearTimeout(operationsTimer);
+    const container = document.getElementById("report-operations");
+    try {
+        const response = await fetch(`/admin/broadcasts/${id}/operations`, {
+            credentials: "same-origin",
+            headers: { "X-Requested-With": "XMLHttpRequest" }
+        });
+        const result = await response.json();
+        if (!result.success || reportBroadcastId !== id) return;
+
+        const operations = result.operations || [];
+        container.innerHTML = operations.map(op => {
+            const percent = op.total ? Math.round((op.done + op.failed) / op.total * 100) : 100;
+            return `<div class="cell-muted">${OPERATION_ACTIONS[op.action] || escapeHtml(op.action)} #${op.id} (${escapeHtml(op.created_by)}, ${formatDate(op.created_at)}): ` +
+                `${BROADCAST_STATUSES[op.status] || escapeHtml(op.status)} — готово ${op.done}, ошибок ${op.failed} из ${op.total} (${percent}%)` +
+                `${op.error ? ". Последняя ошибка: " + escapeHtml(op.error) : ""}</div>`;
+        }).join("");
+        if (operations.some(op => op.status === "queued" || op.status === "running")) {
+            operationsTimer = setTimeout(() => loadBroadcastOperations(id), 2000);
+        }
+    } catch (error) {
+        container.textContent = "Ошибка загрузки: " + error.message;
+    }
+}
+
+// Названия статусов доставки получателю
+const RECIPIENT_STATUSES = {
+    pending: "⏳ в очереди",
+    sending: "📤 отправляется",
+    sent: "✅ доставлено",
+    failed: "❌ ошибка",
+    cancelled: "🚫 отменено",
+    blocked: "🚷 заблокировал бота"
+};
+
+// Рассылка, отчет о которой открыт, и курсор следующей страницы получателей
+let reportBroadcastId = 0;
+let reportNextAfter = 0;
+
+function showBroadcastReport(event, id) {
+    event.stopPropagation();
+    document.getElementById("report-status").value = "";
+    loadBroadcastReport(id);
+    document.getElementById("broadcast-report").scrollIntoView({ behavior: "smooth" });
+}
+
+// Отчет о доставке: сводка рассылки и получатели по статусу
+async function loadBroadcastReport(id, more) {
+    reportBroadcastId = id;
+    const card = document.getElementById("broadcast-report");
+    const body = document.getElementById("report-body");
+    const status = document.getElementById("report-status").value;
+    card.style.display = "block";
+    document.getElementById("report-export").href = `/admin/broadcasts/${id}/recipients.csv?status=${encodeURIComponent(status)}`;
+
+    const params = new URLSearchParams({ status: status });
+    if (more && reportNextAfter) params.set("after", reportNextAfter);
+    try {
+        const [job, result] = await Promise.all([
+            more ? null : fetch(`/admin/broadcasts/${id}`, { credentials: "same-origin", headers: { "X-Requested-With": "XMLHttpRequest" } }).then(r => r.json()),
+            fetch(`/admin/broadcasts/${id}/recipients?${params}`, { credentials: "same-origin", headers: { "X-Requested-With": "XMLHttpRequest" } }).then(r => r.json())
+        ]);
+        if (!more) loadBroadcastOperations(id);
+        if (job && job.success) {
+            const b = job.broadcast;
+            document.getElementById("report-title").textContent = `#${b.id}`;
+            document.getElementById("report-summary").textContent =
+                `${b.created_by}, ${b.segment_label}. Начата: ${b.started_at ? formatDate(b.started_at) : "—"}, ` +
+                `завершена: ${b.finished_at ? formatDate(b.finished_at) : "—"}. ` +
+                `Доставлено ${b.sent}, ошибок ${b.failed}, заблокировали ${b.blocked}, в очереди ${b.pending}, отменено ${b.cancelled} из ${b.total}`;
+        }
+        if (!result.success) {
+            body.innerHTML = `<tr><td colspan="5">❌ ${escapeHtml(result.error)}</td></tr>`;
+            return;
+        }
+
+        if (!more) body.innerHTML = "";
+        reportNextAfter = result.next_after || 0;
+        document.getElementById("report-more").style.display = reportNextAfter ? "inline-block" : "none";
+        for (const recipient of result.recipients || []) {
+            const row = document.createElement("tr");
+            row.innerHTML = `
+                <td>${recipient.telegram_id}</td>
+                <td>${escapeHtml(recipient.language || "—")}</td>
+                <td>${RECIPIENT_STATUSES[recipient.status] || escapeHtml(recipient.status)}</td>
+                <td class="cell-muted">${escapeHtml(recipient.error || "")}</td>
+                <td>${recipient.sent_at ? formatDate(recipient.sent_at) : "—"}</td>
+            `;
+            body.appendChild(row);
+        }
+        if (!body.children.length) {
+            body.innerHTML = '<tr><td colspan="5" class="cell-muted">Нет получателей</td></tr>';
+        }
+    } catch (error) {
+        body.innerHTML = `<tr><td colspan="5">Ошибка загрузки: ${escapeHtml(error.message)}</td></tr>`;
+    }
+}
+
+// Пауза, продолжение или отмена рассылки
+async function controlBroadcast(event, id, action) {
+    event.stopPropagation();
+    if (action === "cancel" && !confirm(`Отменить рассылку #${id}? Оставшиеся получатели не получат сообщение.`)) return;
+
+    try {
+        const result = await postJSON(`/admin/broadcasts/${id}/${action}`, {});
+        if (!result.success) {
+            alert("Ошибка: " + result.error);
+        }
+    } catch (error) {
+        alert("Ошибка сети: " + error.message);
+    }
+    watchBroadcast(id);
+    loadBroadcasts();
+}
+
+// Задание рассылки, за прогрессом которого следим
+let watchedBroadcastId = 0;
+let broadcastWatchTimer = null;
+
+// Прогресс задания рассылки: опрос раз в 2 секунды, пока оно не завершится
+async function watchBroadcast(id) {
+    watchedBroadcastId = id;
+    clearTimeout(broadcastWatchTimer);
+    document.getElementById("broadcast-result").style.display = "block";
+    document.getElementById("broadcast-progress").style.display = "block";
+
+    try {
+        const response = await fetch(`/admin/broadcasts/${id}`, {
+            credentials: "same-origin",
+            headers: { "X-Requested-With": "XMLHttpRequest" }
+        });
+        const result = await response.json();
+        if (!result.success || id !== watchedBroadcastId) return;
+
+        const job = result.broadcast;
+        if (job.status === "scheduled") {
+            document.getElementById("broadcast-progress-bar").style.width = "0%";
+            document.getElementById("broadcast-progress-text").innerHTML = escapeHtml(
+                `#${job.id}: ${BROADCAST_STATUSES.scheduled} на ${formatScheduledAt(job)}`) + " " + broadcastControls(job);
+            return;
+        }
+        const done = job.sent + job.failed + job.blocked;
+        const percent = job.total ? Math.round(done * 100 / job.total) : 0;
+        document.getElementById("broadcast-progress-bar").style.width = percent + "%";
+        document.getElementById("broadcast-progress-text").innerHTML = escapeHtml(
+            `#${job.id}: ${BROADCAST_STATUSES[job.status] || job.status} — отправлено ${job.sent}, ошибок ${job.failed}, заблокировали бота ${job.blocked}, в очереди ${job.pending}` +
+            (job.cancelled ? `, отменено ${job.cancelled}` : "") + ` из ${job.total}` +
+            (job.error ? ` (${job.error})` : "")) + " " + broadcastControls(job);
+
+        if (job.status === "queued" || job.status === "running") {
+            broadcastWatchTimer = setTimeout(() => watchBroadcast(id), 2000);
+        } else {
+            loadBroadcasts();
+        }
+    } catch (error) {
+        broadcastWatchTimer = setTimeout(() => watchBroadcast(id), 5000);
+    }
+}
+
+// Шаблон или черновик, загруженный в форму: повторное сохранение того же вида обновляет его
+let loadedTemplate = null;
+
+const TEMPLATE_KINDS = { template: "📚 Шаблон", draft: "💾 Черновик" };
+
+function setLoadedTemplate(tpl) {
+    loadedTemplate = tpl ? { id: tpl.id, kind: tpl.kind, name: tpl.name } : null;
+    const label = document.getElementById("template-loaded");
+    if (label) label.textContent = tpl ? `${TEMPLATE_KINDS[tpl.kind]}: ${tpl.name}` : "";
+}
+
+// Сохраняет содержимое формы черновиком (вместе с получателями) или шаблоном с названием
+async function saveMessageTemplate(kind) {
+    const payload = broadcastPayload();
+    try {
+        const media = await resolveBroadcastMedia();
+        if (media) payload.media = media;
+    } catch (error) {
+        alert("Не удалось загрузить файл: " + error.message);
+        return;
+    }
+    if (!payload.message && !payload.media) { alert("Введите сообщение или добавьте вложение"); return; }
+
+    const current = loadedTemplate && loadedTemplate.kind === kind ? loadedTemplate : null;
+    const request = {
+        kind: kind,
+        name: current ? current.name : "",
+        message: payload.message,
+        messages: payload.messages,
+        fallback_language: payload.fallback_language,
+        media: payload.media,
+        buttons: payload.buttons
+    };
+    if (kind === "draft") request.segment = payload.segment;
+    if (kind === "template") {
+        const name = prompt(current ? `Обновить шаблон «${current.name}». Название:` : "Название шаблона:", request.name);
+        if (name === null) return;
+        request.name = name.trim();
+    }
+
+    const statusDiv = document.getElementById("broadcast-status");
+    try {
+        const url = current ? `/admin/message-templates/${current.id}/update` : "/admin/message-templates/create";
+        const result = await postJSON(url, request);
+        statusDiv.innerHTML = result.success
+            ? `<div style="color: green;">${TEMPLATE_KINDS[kind]}: ${escapeHtml(result.message)}</div>`
+            : `<div style="color: red;">❌ ${escapeHtml(result.error)}</div>`;
+        if (result.success) {
+            setLoadedTemplate({ id: result.id, kind: kind, name: result.name });
+            loadMessageTemplates();
+        }
+    } catch (error) {
+        statusDiv.innerHTML = "<div style=\"color: red;\">❌ Ошибка сети</div>";
+    }
+    document.getElementById("broadcast-progress").style.display = "none";
+    document.getElementById("broadcast-result").style.display = "block";
+}
+
+// Загружает шаблон или черновик в форму рассылки; получатели берутся только из черновика
+async function loadMessageTemplate(id) {
+    try {
+        const response = await fetch(`/admin/message-templates/${id}`, {
+            credentials: "same-origin",
+            headers: { "X-Requested-With": "XMLHttpRequest" }
+        });
+        const result = await response.json();
+        if (!result.success) {
+            alert("Ошибка: " + result.error);
+            return;
+        }
+        const tpl = result.template;
+        fillBroadcastMessages(tpl);
+        fillBroadcastContent(tpl.media, tpl.buttons);
+        if (tpl.segment) fillBroadcastSegment(tpl.segment);
+        setLoadedTemplate(tpl);
+        document.getElementById("broadcast-form").scrollIntoView({ behavior: "smooth" });
+    } catch (error) {
+        alert("Ошибка сети: " + error.message);
+    }
+}
+
+async function cloneMessageTemplate(id, name) {
+    const cloneName = prompt("Название копии:", `${name} (копия)`);
+    if (cloneName === null) return;
+    try {
+        const result = await postJSON(`/admin/message-templates/${id}/clone`, { name: cloneName.trim() });
+        if (!result.success) alert("Ошибка: " + result.error);
+        loadMessageTemplates();
+    } catch (error) {
+        alert("Ошибка сети: " + error.message);
+    }
+}
+
+async function deleteMessageTemplate(id, name) {
+    if (!confirm(`Удалить «${name}»?`)) return;
+    try {
+        const result = await postJSON(`/admin/message-templates/${id}/delete`);
+        if (!result.success) alert("Ошибка: " + result.error);
+        if (result.success && loadedTemplate && loadedTemplate.id === id) setLoadedTemplate(null);
+        loadMessageTemplates();
+    } catch (error) {
+        alert("Ошибка сети: " + error.message);
+    }
+}
+
+// Библиотека шаблонов и черновики
+async function loadMessageTemplates() {
+    const body = document.getElementById("templates-body");
+    if (!body) return;
+
+    try {
+        const params = new URLSearchParams({
+            kind: document.getElementById("templates-kind").value,
+            q: document.getElementById("templates-search").value.trim()
+        });
+        const response = await fetch("/admin/message-templates?" + params, {
+            credentials: "same-origin",
+            headers: { "X-Requested-With": "XMLHttpRequest" }
+        });
+        const result = await response.json();
+        if (!result.success) {
+            body.innerHTML = `<tr><td colspan="5">❌ ${escapeHtml(result.error)}</td></tr>`;
+            return;
+        }
+
+        body.innerHTML = "";
+        for (const tpl of result.templates || []) {
+            const languages = Object.keys(tpl.messages || {});
+            const row = document.createElement("tr");
+            row.innerHTML = `
+                <td>${escapeHtml(tpl.name)}</td>
+                <td>${TEMPLATE_KINDS[tpl.kind] || escapeHtml(tpl.kind)}</td>
+                <td class="cell-muted">${tpl.media ? "📎 " : ""}${tpl.buttons ? "🔘 " : ""}${languages.length ? "🌐 " + escapeHtml(languages.join(", ")) + " " : ""}${escapeHtml(tpl.message.slice(0, 100))}</td>
+                <td>${formatDate(tpl.updated_at)}<br><span class="cell-muted">${escapeHtml(tpl.updated_by || tpl.created_by)}</span></td>
+                <td>
+                    <button class="btn btn-secondary" onclick="loadMessageTemplate(${tpl.id})">📥 В форму</button>
+                    <button class="btn btn-secondary" data-action="clone">📄 Копия</button>
+                    <button class="btn btn-secondary" data-action="delete">🗑️</button>
+                </td>
+            `;
+            row.querySelector('[data-action="clone"]').addEventListener("click", () => cloneMessageTemplate(tpl.id, tpl.name));
+            row.querySelector('[data-action="delete"]').addEventListener("click", () => deleteMessageTemplate(tpl.id, tpl.name));
+            body.appendChild(row);
+        }
+        if (!body.children.length) {
+            body.innerHTML = '<tr><td colspan="5" class="cell-muted">Сохраненных шаблонов и черновиков нет</td></tr>';
+        }
+        loadLifecycleTemplates();
+    } catch (error) {
+        body.innerHTML = `<tr><td colspan="5">Ошибка загрузки: ${escapeHtml(error.message)}</td></tr>`;
+    }
+}
+
+// Правила автоматических сообщений (последний загруженный список) и правило, открытое в форме
+let lifecycleRules = [];
+let editingLifecycleRuleId = 0;
+
+// Срок правила относительно окончания подписки
+function lifecycleWhen(offsetDays) {
+    if (offsetDays < 0) return `за ${-offsetDays} дн. до окончания`;
+    if (offsetDays > 0) return `через ${offsetDays} дн. после окончания`;
+    return "в момент окончания";
+}
+
+function resetLifecycleForm() {
+    editingLifecycleRuleId = 0;
+    document.getElementById("lifecycle-form").reset();
+    document.getElementById("lifecycle-submit").textContent = "➕ Добавить";
+}
+
+// Шаблоны библиотеки для выбора в правиле; черновики автоматически не отправляются
+async function loadLifecycleTemplates() {
+    const select = document.getElementById("lifecycle-template");
+    if (!select) return;
+    try {
+        const response = await fetch("/admin/message-templates?kind=template", {
+            credentials: "same-origin",
+            headers: { "X-Requested-With": "XMLHttpRequest" }
+        });
+        const result = await response.json();
+        if (!result.success) return;
+        const selected = select.value;
+        select.innerHTML = '<option value="">Шаблон...</option>' + (result.templates || [])
+            .map(tpl => `<option value="${tpl.id}">${escapeHtml(tpl.name)}</option>`).join("");
+        select.value = selected;
+    } catch (error) {
+        console.error("Error:", error);
+    }
+}
+
+async function loadLifecycleRules() {
+    const body = document.getElementById("lifecycle-body");
+    if (!body) return;
+
+    try {
+        const response = await fetch("/admin/lifecycle-rules", {
+            credentials: "same-origin",
+            headers: { "X-Requested-With": "XMLHttpRequest" }
+        });
+        const result = await response.json();
+        if (!result.success) {
+            body.innerHTML = `<tr><td colspan="6">❌ ${escapeHtml(result.error)}</td></tr>`;
+            return;
+        }
+
+        lifecycleRules = result.rules || [];
+        body.innerHTML = "";
+        for (const rule of lifecycleRules) {
+            const row = document.createElement("tr");
+            row.innerHTML = `
+                <td>${escapeHtml(rule.name)}${rule.enabled ? "" : ' <span class="cell-muted">(выключено)</span>'}</td>
+                <td>${lifecycleWhen(rule.offset_days)}</td>
+                <td>${escapeHtml(rule.template_name)}</td>
+                <td>${rule.sent} / ${rule.failed} / ${rule.blocked}</td>
+                <td>${rule.last_sent_at ? formatDate(rule.last_sent_at) : "—"}</td>
+                <td>
+                    <button class="btn btn-secondary" onclick="editLifecycleRule(${rule.id})">✏️</button>
+                    <button class="btn btn-secondary" onclick="toggleLifecycleRule(${rule.id})">${rule.enabled ? "⏸️ Выключить" : "▶️ Включить"}</button>
+                    <button class="btn btn-secondary" onclick="deleteLifecycleRule(${rule.id})">🗑️</button>
+                </td>
+            `;
+            body.appendChild(row);
+        }
+        if (!body.children.length) {
+            body.innerHTML = '<tr><td colspan="6" class="cell-muted">Автоматических сообщений нет</td></tr>';
+        }
+    } catch (error) {
+        body.innerHTML = `<tr><td colspan="6">Ошибка загрузки: ${escapeHtml(error.message)}</td></tr>`;
+    }
+}
+
+async function saveLifecycleRule(id, rule) {
+    try {
+        const url = id ? `/admin/lifecycle-rules/${id}/update` : "/admin/lifecycle-rules/create";
+        const result = await postJSON(url, rule);
+        if (!result.success) {
+            alert("Ошибка: " + result.error);
+            return false;
+        }
+        loadLifecycleRules();
+        return true;
+    } catch (error) {
+        alert("Ошибка сети: " + error.message);
+        return false;
+    }
+}
+
+function editLifecycleRule(id) {
+    const rule = lifecycleRules.find(r => r.id === id);
+    if (!rule) return;
+    editingLifecycleRuleId = id;
+    document.getElementById("lifecycle-name").value = rule.name;
+    document.getElementById("lifecycle-template").value = rule.template_id;
+    document.getElementById("lifecycle-days").value = Math.abs(rule.offset_days);
+    document.getElementById("lifecycle-direction").value = Math.sign(rule.offset_days);
+    document.getElementById("lifecycle-enabled").checked = rule.enabled;
+    document.getElementById("lifecycle-submit").textContent = `💾 Сохранить #${id}`;
+}
+
+function toggleLifecycleRule(id) {
+    const rule = lifecycleRules.find(r => r.id === id);
+    if (!rule) return;
+    saveLifecycleRule(id, {
+        name: rule.name,
+        template_id: rule.template_id,
+        offset_days: rule.offset_days,
+        enabled: !rule.enabled
+    });
+}
+
+async function deleteLifecycleRule(id) {
+    if (!confirm(`Удалить автоматическое сообщение #${id} вместе с историей отправок?`)) return;
+    try {
+        const result = await postJSON(`/admin/lifecycle-rules/${id}/delete`);
+        if (!result.success) alert("Ошибка: " + result.error);
+        if (editingLifecycleRuleId === id) resetLifecycleForm();
+        loadLifecycleRules();
+    } catch (error) {
+        alert("Ошибка сети: " + error.message);
+    }
+}
+
+document.getElementById("lifecycle-form")?.addEventListener("submit", async function(e) {
     e.preventDefault();
-    const message = document.getElementById("message").value.trim();
-    if (!message) { alert("Введите сообщение"); return; }
-    if (!confirm("Отправить сообщение всем пользователям?")) return;
+    const templateId = parseInt(document.getElementById("lifecycle-template").value, 10);
+    if (!templateId) { alert("Выберите шаблон"); return; }
+    const direction = parseInt(document.getElementById("lifecycle-direction").value, 10);
+    const days = parseInt(document.getElementById("lifecycle-days").value, 10) || 0;
+    const rule = {
+        name: document.getElementById("lifecycle-name").value.trim(),
+        template_id: templateId,
+        offset_days: direction * days,
+        enabled: document.getElementById("lifecycle-enabled").checked
+    };
+    if (await saveLifecycleRule(editingLifecycleRuleId, rule)) resetLifecycleForm();
+});
+
+// Список последних заданий рассылки
+// Курсор следующей страницы истории рассылок
+let broadcastsNextBefore = 0;
+
+async function loadBroadcasts(more) {
+    const body = document.getElementById("broadcasts-body");
+    if (!body) return;
+
+    try {
+        const params = new URLSearchParams({
+            status: document.getElementById("broadcasts-status")?.value || "",
+            created_by: document.getElementById("broadcasts-author")?.value.trim() || ""
+        });
+        const from = document.getElementById("broadcasts-from")?.value;
+        if (from) params.set("from", new Date(from).toISOString());
+        const to = document.getElementById("broadcasts-to")?.value;
+        if (to) params.set("to", new Date(nextDay(to)).toISOString());
+        if (more && broadcastsNextBefore) params.set("before", broadcastsNextBefore);
+
+        const response = await fetch("/admin/broadcasts?" + params, {
+            credentials: "same-origin",
+            headers: { "X-Requested-With": "XMLHttpRequest" }
+        });
+        const result = await response.json();
+        if (!result.success) {
+            body.innerHTML = `<tr><td colspan="8">❌ ${escapeHtml(result.error)}</td></tr>`;
+            return;
+        }
+
+        if (!more) body.innerHTML = "";
+        broadcastsNextBefore = result.next_before || 0;
+        document.getElementById("broadcasts-more").style.display = broadcastsNextBefore ? "inline-block" : "none";
+        for (const job of result.broadcasts || []) {
+            const row = document.createElement("tr");
+            row.innerHTML = `
+                <td>${job.id}</td>
+                <td>${formatDate(job.created_at)}</td>
+                <td>${escapeHtml(job.created_by)}</td>
+                <td class="cell-muted">${escapeHtml(job.segment_label)}</td>
+                <td class="cell-muted">${job.media ? "📎 " : ""}${job.buttons ? "🔘 " : ""}${escapeHtml(job.message.slice(0, 100))}</td>
+                <td>${BROADCAST_STATUSES[job.status] || escapeHtml(job.status)}${job.status === "scheduled" ? `<br><span class="cell-muted">${escapeHtml(formatScheduledAt(job))}</span>` : ""}${job.error ? `<br><span class="cell-muted">${escapeHtml(job.error)}</span>` : ""}</td>
+                <td>${job.sent} / ${job.failed} / ${job.blocked} / ${job.total}</td>
+                <td>${broadcastControls(job)}</td>
+            `;
+            row.style.cursor = "pointer";
+            row.addEventListener("click", () => watchBroadcast(job.id));
+            body.appendChild(row);
+        }
+        if (!body.children.length) {
+            body.innerHTML = '<tr><td colspan="8" class="cell-muted">Рассылок еще не было</td></tr>';
+        }
+    } catch (error) {
+        body.innerHTML = `<tr><td colspan="8">Ошибка загрузки: ${escapeHtml(error.message)}</td></tr>`;
+    }
+}
+
+// CSRF токен сессии; сервер требует его в заголовке X-CSRF-Token у всех POST запросов
+const CSRF_TOKEN = document.querySelector('meta[name="csrf-token"]').content;
+
+// Проверка права текущего администратора (список приходит из шаблона)
+function can(permission) {
+    return ADMIN_PERMISSIONS.includes(permission);
+}
+
+// Отправка рассылки администраторам из BROADCAST_TEST_CHAT_IDS в том виде, в каком её получат клиенты
+async function sendBroadcastTest(button) {
+    const payload = broadcastPayload();
+    try {
+        const media = await resolveBroadcastMedia();
+        if (media) payload.media = media;
+    } catch (error) {
+        alert("Не удалось загрузить файл: " + error.message);
+        return;
+    }
+    if (!payload.message && !payload.media) { alert("Введите сообщение или добавьте вложение"); return; }
+
+    const content = {
+        message: payload.message,
+        messages: payload.messages,
+        fallback_language: payload.fallback_language,
+        media: payload.media,
+        buttons: payload.buttons
+    };
+    const statusDiv = document.getElementById("broadcast-status");
+    button.disabled = true;
+    try {
+        const result = await postJSON("/admin/broadcast/test", content);
+        const failures = (result.results || []).filter(r => r.error)
+            .map(r => `<div>${r.telegram_id}: ${escapeHtml(r.error)}</div>`).join("");
+        statusDiv.innerHTML = result.success
+            ? `<div style="color: green;">🧪 ${escapeHtml(result.message)}</div>`
+            : `<div style="color: red;">❌ ${escapeHtml(result.error || result.message)}</div>${failures}`;
+    } catch (error) {
+        statusDiv.innerHTML = "<div style=\"color: red;\">❌ Ошибка сети</div>";
+    } finally {
+        document.getElementById("broadcast-progress").style.display = "none";
+        document.getElementById("broadcast-result").style.display = "block";
+        button.disabled = false;
+    }
+}
+
+document.getElementById("broadcast-form")?.addEventListener("submit", async function(e) {
+    e.preventDefault();
+    const payload = broadcastPayload();
+    try {
+        const media = await resolveBroadcastMedia();
+        if (media) payload.media = media;
+    } catch (error) {
+        alert("Не удалось загрузить файл: " + error.message);
+        return;
+    }
+    if (!payload.message && !payload.media) { alert("Введите сообщение или добавьте вложение"); return; }
+    if (editingSentBroadcastId) {
+        await editSentBroadcastSubmit(payload);
+        return;
+    }
+    const count = await countBroadcastRecipients(payload.segment);
+    if (count === null) return;
+    if (count === 0 && !payload.scheduled_at) { alert("В сегменте нет получателей"); return; }
+    const audience = Object.keys(payload.segment).length ? "выбранному сегменту" : "всем пользователям";
+    const when = payload.scheduled_at ? ` ${payload.scheduled_at.replace("T", " ")} (${payload.timezone})` : "";
+    if (editingBroadcastId) {
+        if (!confirm(`Сохранить изменения рассылки #${editingBroadcastId}?`)) return;
+    } else if (!confirm(`Отправить сообщение ${audience}${when} (сейчас ${count} получателей)?`)) {
+        return;
+    }
+
+    const submitBtn = e.target.querySelector("button[type=submit]");
+    submitBtn.textContent = "Отправка...";
+    submitBtn.disabled = true;
+    
+    try {
+        const url = editingBroadcastId ? `/admin/broadcasts/${editingBroadcastId}/update` : "/admin/broadcast";
+        const result = await postJSON(url, payload);
+        const editedId = editingBroadcastId;
+        if (result.success && editedId) {
+            result.id = editedId;
+            setEditingBroadcast(0);
+        }
+        
+        const resultBox = document.getElementById("broadcast-result");
+        const statusDiv = document.getElementById("broadcast-status");
+        statusDiv.innerHTML = result.success ? 
+            `<div style="color: green;">✅ ${escapeHtml(result.message)}</div>` : 
+            `<div style="color: red;">❌ ${escapeHtml(result.message || result.error)}</div>`;
+        resultBox.style.display = "block";
+        if (result.success) {
+            watchBroadcast(result.id);
+            loadBroadcasts();
+        }
+    } catch (error) {
+        console.error("Error:", error);
+        document.getElementById("broadcast-status").innerHTML = "<div style=\"color: red;\">❌ Ошибка сети</div>";
+        document.getElementById("broadcast-result").style.display = "block";
+    } finally {
+        setEditingBroadcast(editingBroadcastId);
+        submitBtn.disabled = false;
+    }
+});
+
+// Исправление текста и кнопок уже отправленной рассылки у всех получателей; вложение не меняется
+async function editSentBroadcastSubmit(payload) {
+    const id = editingSentBroadcastId;
+    if (!confirm(`Исправить сообщение рассылки #${id} у всех получателей?`)) return;
+
+    const content = {
+        message: payload.message,
+        messages: payload.messages,
+        fallback_language: payload.fallback_language,
+        buttons: payload.buttons
+    };
+    const statusDiv = document.getElementById("broadcast-status");
+    try {
+        const result = await postJSON(`/admin/broadcasts/${id}/edit-sent`, content);
+        statusDiv.innerHTML = result.success
+            ? `<div style="color: green;">✏️ ${escapeHtml(result.message)}</div>`
+            : `<div style="color: red;">❌ ${escapeHtml(result.error)}</div>`;
+        if (result.success) {
+            setEditingBroadcast(0);
+            loadBroadcastReport(id);
+        }
+    } catch (error) {
+        statusDiv.innerHTML = "<div style=\"color: red;\">❌ Ошибка сети</div>";
+    }
+    document.getElementById("broadcast-progress").style.display = "none";
+    document.getElementById("broadcast-result").style.display = "block";
+}
+
+async function loadLogs() {
+    const logsContent = document.getElementById("logs-content");
+    const lines = document.getElementById("log-lines").value;
+    logsContent.textContent = "Загрузка логов...";
+    
+    try {
+        const response = await fetch(`/admin/logs?lines=${lines}`);
+        const result = await response.json();
+        logsContent.textContent = result.success ? (result.logs || "Логи пусты") : `Ошибка: ${result.error}`;
+    } catch (error) {
+        logsContent.textContent = "Ошибка загрузки логов: " + error.message;
+    }
+}
+
+// Переменные для работы с переводами
+let allTranslations = {};
+let currentLanguage = "";
+let originalTranslations = {};
+
+// Загрузка всех переводов
+async function loadTranslations() {
+    try {
+        const response = await fetch("/admin/translations", {
+            method: "GET",
+            credentials: "same-origin", // Включаем куки
+            headers: {
+                "X-Requested-With": "XMLHttpRequest"
+            }
+        });
+        
+        if (response.status === 401) {
+            alert("Требуется повторная авторизация. Обновите страницу.");
+            window.location.reload();
+            return;
+        }
+        
+        const result = await response.json();
+        
+        if (result.success) {
+            allTranslations = result.translations;
+            populateLanguageSelect();
+            console.log("Переводы загружены:", allTranslations);
+        } else {
+            console.error("Ошибка загрузки переводов:", result.error);
+            alert("Ошибка загрузки переводов: " + result.error);
+        }
+    } catch (error) {
+        console.error("Ошибка сети при загрузке переводов:", error);
+        alert("Ошибка сети при загрузке переводов: " + error.message);
+    }
+}
+
+// Заполнение выпадающего списка языков
+function populateLanguageSelect() {
+    const select = document.getElementById("language-select");
+    select.innerHTML = '<option value="">Выберите язык...</option>';
+    
+    const languageNames = {
+        'ru': 'Русский (ru.json)',
+        'en': 'English (en.json)'
+    };
+    
+    for (const [langCode, translations] of Object.entries(allTranslations)) {
+        const option = document.createElement("option");
+        option.value = langCode;
+        option.textContent = languageNames[langCode] || `${langCode.toUpperCase()} (${langCode}.json)`;
+        select.appendChild(option);
+    }
+}
+
+// Загрузка переводов для выбранного языка
+function loadTranslationForLanguage() {
+    const select = document.getElementById("language-select");
+    const selectedLang = select.value;
+    
+    if (!selectedLang) {
+        document.getElementById("translation-editor").style.display = "none";
+        return;
+    }
+    
+    currentLanguage = selectedLang;
+    const translations = allTranslations[selectedLang];
+    
+    if (!translations) {
+        alert("Переводы для выбранного языка не найдены");
+        return;
+    }
+    
+    // Сохраняем оригинальные переводы для возможности отмены
+    originalTranslations = JSON.parse(JSON.stringify(translations));
+    
+    displayTranslationEditor(selectedLang, translations);
+}
+
+// Отображение редактора переводов
+function displayTranslationEditor(language, translations) {
+    const editor = document.getElementById("translation-editor");
+    const currentLangSpan = document.getElementById("current-language");
+    const fieldsContainer = document.getElementById("translation-fields");
+    
+    currentLangSpan.textContent = language.toUpperCase();
+    fieldsContainer.innerHTML = "";
+    
+    // Создаем поля для каждого перевода
+    for (const [key, value] of Object.entries(translations)) {
+        const fieldDiv = document.createElement("div");
+        fieldDiv.className = "translation-field";
+        
+        fieldDiv.innerHTML = `
+            <label for="trans_${key}">Перевод для ключа:</label>
+            <div class="field-key">${key}</div>
+            <textarea id="trans_${key}" name="${key}">${escapeHtml(value)}</textarea>
+        `;
+        
+        fieldsContainer.appendChild(fieldDiv);
+    }
+    
+    editor.style.display = "block";
+    document.getElementById("translation-result").style.display = "none";
+}
+
+// Сохранение переводов
+async function saveTranslations() {
+    if (!currentLanguage) {
+        alert("Язык не выбран");
+        return;
+    }
+    
+    const fieldsContainer = document.getElementById("translation-fields");
+    const textareas = fieldsContainer.querySelectorAll("textarea");
+    const updatedTranslations = {};
+    
+    // Собираем обновленные переводы
+    textareas.forEach(textarea => {
+        const key = textarea.name;
+        const value = textarea.value;
+        updatedTranslations[key] = value;
+    });
+    
+    try {
+        const response = await fetch("/admin/translations/update", {
+            method: "POST",
+            credentials: "same-origin", // Включаем куки
+            headers: {
+                "Content-Type": "application/json",
+                "X-Requested-With": "XMLHttpRequest",
+                "X-CSRF-Token": CSRF_TOKEN
+            },
+            body: JSON.stringify({
+                language: currentLanguage,
+                data: updatedTranslations
+            })
+        });
+        
+        const result = await response.json();
+        const resultBox = document.getElementById("translation-result");
+        const statusDiv = document.getElementById("translation-status");
+        
+        if (result.success) {
+            // Обновляем локальные данные
+            allTranslations[currentLanguage] = updatedTranslations;
+            originalTranslations = JSON.parse(JSON.stringify(updatedTranslations));
+            
+            resultBox.className = "result-box result-success";
+            
+            // Автоматически перезапускаем бота, если роль это позволяет
+            if (can("bot:restart")) {
+                statusDiv.innerHTML = `<div style="color: green;">✅ ${result.message}<br/>🔄 Перезапускаем бота для применения изменений...</div>`;
+                restartBot();
+            } else {
+                statusDiv.innerHTML = `<div style="color: green;">✅ ${result.message}<br/>💡 Изменения применятся после перезапуска бота.</div>`;
+            }
+        } else {
+            statusDiv.innerHTML = `<div style="color: red;">❌ Ошибка: ${result.error}</div>`;
+            resultBox.className = "result-box result-error";
+        }
+        
+        resultBox.style.display = "block";
+        
+    } catch (error) {
+        console.error("Ошибка сохранения:", error);
+        const resultBox = document.getElementById("translation-result");
+        const statusDiv = document.getElementById("translation-status");
+        statusDiv.innerHTML = `<div style="color: red;">❌ Ошибка сети: ${error.message}</div>`;
+        resultBox.className = "result-box result-error";
+        resultBox.style.display = "block";
+    }
+}
+
+// Отмена редактирования
+function cancelEdit() {
+    if (!currentLanguage) return;
     
-    const submitBtn = e.target.querySelector("button[type=submit]");
-    submitBtn.textContent = "Отправка...";
-    submitBtn.disabled = true;
+    if (confirm("Отменить все изменения и вернуть исходные значения?")) {
+        displayTranslationEditor(currentLanguage, originalTranslations);
+        document.getElementById("translation-result").style.display = "none";
+    }
+}
+
+// Вспомогательная функция для экранирования HTML
+function escapeHtml(text) {
+    const div = document.createElement('div');
+    div.textContent = text;
+    return div.innerHTML;
+}
+
+// Модифицируем функцию showTab для автозагрузки переводов
+function showTab(tabName) {
+    document.querySelectorAll(".tab-content").forEach(tab => tab.classList.remove("active"));
+    document.querySelectorAll(".tab-btn").forEach(btn => btn.classList.remove("active"));
+    document.getElementById(tabName + "-tab").classList.add("active");
+    document.querySelector(`.tab-btn[data-tab="${tabName}"]`).classList.add("active");
+    
+    if (tabName === "logs") loadLogs();
+    if (tabName === "broadcast") {
+        loadBroadcasts();
+        loadMessageTemplates();
+        loadLifecycleRules();
+    }
+    if (tabName === "sessions") loadSessions();
+    if (tabName === "admins") loadAdmins();
+    if (tabName === "security") loadTwoFactor();
+    if (tabName === "login-blocks") loadLoginBlocks();
+    if (tabName === "audit") loadAudit(true);
+    if (tabName === "customers") loadCustomers(true);
     
+    // Если открываем вкладку переводов, загружаем данные
+    if (tabName === 'translations' && Object.keys(allTranslations).length === 0) {
+        loadTranslations();
+    }
+}
+
+// Функция перезапуска основного бота
+async function restartBot() {
     try {
-        const response = await fetch("/admin/broadcast", {
+        const response = await fetch("/admin/restart-bot", {
             method: "POST",
-            headers: { "Content-Type": "application/json" },
-            body: JSON.stringify({ message: message })
+            credentials: "same-origin",
+            headers: {
+                "X-Requested-With": "XMLHttpRequest",
+                "Content-Type": "application/json",
+                "X-CSRF-Token": CSRF_TOKEN
+            }
         });
+        
+        if (response.status === 401) {
+            alert("Требуется повторная авторизация. Обновите страницу.");
+            window.location.reload();
+            return;
+        }
+        
         const result = await response.json();
+        const statusDiv = document.getElementById("translation-status");
         
-        const resultBox = document.getElementById("broadcast-result");
-        const statusDiv = document.getElementById("broadcast-status");
-        statusDiv.innerHTML = result.success ? 
-            `<div style="color: green;">✅ ${result.message}</div>` : 
-            `<div style="color: red;">❌ ${result.message}</div>`;
+        if (result.success) {
+            statusDiv.innerHTML = `<div style="color: green;">✅ Переводы сохранены<br/>🎉 Бот успешно перезапущен и загрузил новые переводы!</div>`;
+        } else {
+            statusDiv.innerHTML = `<div style="color: orange;">⚠️ Переводы сохранены, но не удалось перезапустить бота автоматически.<br/>❌ ${result.error}<br/>💡 Попробуйте перезапустить бота вручную.</div>`;
+        }
+        
+    } catch (error) {
+        console.error("Ошибка перезапуска бота:", error);
+        const statusDiv = document.getElementById("translation-status");
+        statusDiv.innerHTML = `<div style="color: orange;">⚠️ Переводы сохранены, но произошла ошибка при перезапуске бота.<br/>💡 Попробуйте перезапустить бота вручную.</div>`;
+    }
+}
+
+
+// Форматирование даты для таблиц
+function formatDate(value) {
+    return value ? new Date(value).toLocaleString("ru-RU") : "—";
+}
+
+// Загрузка активных сессий
+async function loadSessions() {
+    const body = document.getElementById("sessions-body");
+    body.innerHTML = '<tr><td colspan="7">Загрузка...</td></tr>';
+
+    try {
+        const response = await fetch("/admin/sessions", {
+            credentials: "same-origin",
+            headers: { "X-Requested-With": "XMLHttpRequest" }
+        });
+
+        if (response.status === 401) {
+            alert("Требуется повторная авторизация. Обновите страницу.");
+            window.location.reload();
+            return;
+        }
+
+        const result = await response.json();
+        if (!result.success) {
+            body.innerHTML = `<tr><td colspan="7">❌ ${escapeHtml(result.error)}</td></tr>`;
+            return;
+        }
+
+        body.innerHTML = "";
+        for (const session of result.sessions || []) {
+            const row = document.createElement("tr");
+            row.innerHTML = `
+                <td>${escapeHtml(session.username)}${session.current ? " <b>(вы)</b>" : ""}</td>
+                <td>${escapeHtml(session.ip)}</td>
+                <td class="cell-muted">${escapeHtml(session.user_agent)}</td>
+                <td>${formatDate(session.created_at)}</td>
+                <td>${formatDate(session.last_seen_at)}</td>
+                <td>${formatDate(session.expires_at)}</td>
+                <td><button class="btn btn-secondary" onclick="revokeSession(${session.id})">🚫 Отозвать</button></td>
+            `;
+            body.appendChild(row);
+        }
+    } catch (error) {
+        body.innerHTML = `<tr><td colspan="7">Ошибка загрузки сессий: ${escapeHtml(error.message)}</td></tr>`;
+    }
+}
+
+// Отзыв сессии
+async function revokeSession(id) {
+    if (!confirm("Отозвать эту сессию?")) return;
+
+    try {
+        const response = await fetch("/admin/sessions/revoke", {
+            method: "POST",
+            credentials: "same-origin",
+            headers: {
+                "Content-Type": "application/json",
+                "X-Requested-With": "XMLHttpRequest",
+                "X-CSRF-Token": CSRF_TOKEN
+            },
+            body: JSON.stringify({ id: id })
+        });
+
+        if (response.status === 401) {
+            window.location.reload();
+            return;
+        }
+
+        const result = await response.json();
+        if (!result.success) {
+            alert("Ошибка: " + result.error);
+        }
+        loadSessions();
+    } catch (error) {
+        alert("Ошибка сети: " + error.message);
+    }
+}
+
+// Загрузка списка администраторов
+async function loadAdmins() {
+    const body = document.getElementById("admins-body");
+    body.innerHTML = '<tr><td colspan="5">Загрузка...</td></tr>';
+
+    try {
+        const response = await fetch("/admin/admins", {
+            credentials: "same-origin",
+            headers: { "X-Requested-With": "XMLHttpRequest" }
+        });
+        const result = await response.json();
+        if (!result.success) {
+            body.innerHTML = `<tr><td colspan="5">❌ ${escapeHtml(result.error)}</td></tr>`;
+            return;
+        }
+
+        const roles = ["viewer", "support", "translator", "operator", "owner"];
+        body.innerHTML = "";
+        for (const admin of result.admins || []) {
+            const options = roles.map(role =>
+                `<option value="${role}"${role === admin.role ? " selected" : ""}>${role}</option>`).join("");
+            const row = document.createElement("tr");
+            row.innerHTML = `
+                <td>${admin.id}</td>
+                <td>${escapeHtml(admin.username)}</td>
+                <td><select onchange="setAdminRole(${admin.id}, this.value)">${options}</select></td>
+                <td>${formatDate(admin.created_at)}</td>
+                <td>${formatDate(admin.last_login_at)}</td>
+            `;
+            body.appendChild(row);
+        }
+    } catch (error) {
+        body.innerHTML = `<tr><td colspan="5">Ошибка загрузки: ${escapeHtml(error.message)}</td></tr>`;
+    }
+}
+
+// Смена роли администратора
+async function setAdminRole(id, role) {
+    try {
+        const response = await fetch("/admin/admins/role", {
+            method: "POST",
+            credentials: "same-origin",
+            headers: {
+                "Content-Type": "application/json",
+                "X-Requested-With": "XMLHttpRequest",
+                "X-CSRF-Token": CSRF_TOKEN
+            },
+            body: JSON.stringify({ id: id, role: role })
+        });
+        const result = await response.json();
+        if (!result.success) {
+            alert("Ошибка: " + result.error);
+        }
+    } catch (error) {
+        alert("Ошибка сети: " + error.message);
+    }
+    loadAdmins();
+}
+
+// Создание администратора; пароль показывается один раз
+document.getElementById("admin-create-form")?.addEventListener("submit", async function(e) {
+    e.preventDefault();
+    const resultBox = document.getElementById("admin-create-result");
+
+    try {
+        const response = await fetch("/admin/admins/create", {
+            method: "POST",
+            credentials: "same-origin",
+            headers: {
+                "Content-Type": "application/json",
+                "X-Requested-With": "XMLHttpRequest",
+                "X-CSRF-Token": CSRF_TOKEN
+            },
+            body: JSON.stringify({
+                username: document.getElementById("new-admin-username").value.trim(),
+                role: document.getElementById("new-admin-role").value
+            })
+        });
+        const result = await response.json();
+
+        if (result.success) {
+            resultBox.className = "result-box result-success";
+            resultBox.innerHTML = `✅ ${escapeHtml(result.message)}<br/>🔑 Пароль (сохраните, он больше не будет показан): <code>${escapeHtml(result.password)}</code>`;
+            e.target.reset();
+            loadAdmins();
+        } else {
+            resultBox.className = "result-box result-error";
+            resultBox.innerHTML = `❌ ${escapeHtml(result.error)}`;
+        }
         resultBox.style.display = "block";
     } catch (error) {
-        console.error("Error:", error);
-        document.getElementById("broadcast-status").innerHTML = "<div style=\"color: red;\">❌ Ошибка сети</div>";
-        document.getElementById("broadcast-result").style.display = "block";
-    } finally {
-        submitBtn.textContent = "🚀 Отправить рассылку";
-        submitBtn.disabled = false;
+        alert("Ошибка сети: " + error.message);
     }
 });
 
-async function loadLogs() {
-    const logsContent = document.getElementById("logs-content");
-    const lines = document.getElementById("log-lines").value;
-    logsContent.textContent = "Загрузка логов...";
-    
+// Причины неудачного входа в журнале
+const LOGIN_FAILURE_REASONS = {
+    bad_password: "неверный пароль",
+    bad_totp: "неверный код 2FA",
+    blocked: "попытка во время блокировки"
+};
+
+// Загрузка блокировок входа и журнала неудачных попыток
+async function loadLoginBlocks() {
+    const blocksBody = document.getElementById("login-blocks-body");
+    const failuresBody = document.getElementById("login-failures-body");
+    blocksBody.innerHTML = '<tr><td colspan="6">Загрузка...</td></tr>';
+    failuresBody.innerHTML = "";
+
+    try {
+        const response = await fetch("/admin/login-blocks", {
+            credentials: "same-origin",
+            headers: { "X-Requested-With": "XMLHttpRequest" }
+        });
+        const result = await response.json();
+        if (!result.success) {
+            blocksBody.innerHTML = `<tr><td colspan="6">❌ ${escapeHtml(result.error)}</td></tr>`;
+            return;
+        }
+
+        blocksBody.innerHTML = "";
+        for (const block of result.blocks || []) {
+            const active = block.blocked_until && new Date(block.blocked_until) > new Date();
+            const row = document.createElement("tr");
+            row.innerHTML = `
+                <td>${block.kind === "ip" ? "IP" : "логин"}</td>
+                <td>${escapeHtml(block.key)}</td>
+                <td>${block.failures}</td>
+                <td>${formatDate(block.last_failure_at)}</td>
+                <td>${active ? formatDate(block.blocked_until) : "—"}</td>
+                <td></td>
+            `;
+            const button = document.createElement("button");
+            button.className = "btn btn-secondary";
+            button.textContent = "🔓 Снять";
+            button.addEventListener("click", () => unblockLogin(block.kind, block.key));
+            row.lastElementChild.appendChild(button);
+            blocksBody.appendChild(row);
+        }
+        if (!blocksBody.children.length) {
+            blocksBody.innerHTML = '<tr><td colspan="6" class="cell-muted">Блокировок нет</td></tr>';
+        }
+
+        for (const failure of result.failures || []) {
+            const row = document.createElement("tr");
+            row.innerHTML = `
+                <td>${formatDate(failure.created_at)}</td>
+                <td>${escapeHtml(failure.ip)}</td>
+                <td>${escapeHtml(failure.username)}</td>
+                <td>${escapeHtml(LOGIN_FAILURE_REASONS[failure.reason] || failure.reason)}</td>
+            `;
+            failuresBody.appendChild(row);
+        }
+    } catch (error) {
+        blocksBody.innerHTML = `<tr><td colspan="6">Ошибка загрузки: ${escapeHtml(error.message)}</td></tr>`;
+    }
+}
+
+// Ручное снятие блокировки входа
+async function unblockLogin(kind, key) {
+    if (!confirm(`Снять блокировку ${key}?`)) return;
+
+    try {
+        const result = await postJSON("/admin/login-blocks/unblock", { kind: kind, key: key });
+        if (!result.success) {
+            alert("Ошибка: " + result.error);
+        }
+    } catch (error) {
+        alert("Ошибка сети: " + error.message);
+    }
+    loadLoginBlocks();
+}
+
+// Курсор журнала аудита: ID последней загруженной записи
+let auditBefore = 0;
+
+// Загрузка журнала аудита; reset - начать с самых новых записей
+async function loadAudit(reset) {
+    const body = document.getElementById("audit-body");
+    const moreButton = document.getElementById("audit-more");
+    if (reset) {
+        auditBefore = 0;
+        body.innerHTML = '<tr><td colspan="7">Загрузка...</td></tr>';
+    }
+
+    const params = new URLSearchParams();
+    const filters = {
+        username: document.getElementById("audit-username").value.trim(),
+        action: document.getElementById("audit-action").value,
+        target: document.getElementById("audit-target").value.trim(),
+        success: document.getElementById("audit-success").value,
+        from: document.getElementById("audit-from").value
+    };
+    for (const [key, value] of Object.entries(filters)) {
+        if (value) params.set(key, value);
+    }
+    const to = document.getElementById("audit-to").value;
+    if (to) params.set("to", nextDay(to));
+    if (auditBefore) params.set("before", auditBefore);
+
+    try {
+        const response = await fetch("/admin/audit?" + params.toString(), {
+            credentials: "same-origin",
+            headers: { "X-Requested-With": "XMLHttpRequest" }
+        });
+        const result = await response.json();
+        if (reset) body.innerHTML = "";
+        if (!result.success) {
+            body.innerHTML = `<tr><td colspan="7">❌ ${escapeHtml(result.error)}</td></tr>`;
+            moreButton.style.display = "none";
+            return;
+        }
+
+        for (const entry of result.entries || []) {
+            const details = entry.details ? JSON.stringify(entry.details, null, 2) : "";
+            const row = document.createElement("tr");
+            row.innerHTML = `
+                <td>${formatDate(entry.created_at)}</td>
+                <td>${escapeHtml(entry.username || "—")}</td>
+                <td>${escapeHtml(entry.ip)}</td>
+                <td><code>${escapeHtml(entry.action)}</code></td>
+                <td>${escapeHtml(entry.target)}</td>
+                <td>${entry.success ? "✅" : "❌ " + escapeHtml(entry.error || String(entry.status))}</td>
+                <td>${details ? `<details><summary>показать</summary><pre>${escapeHtml(details)}</pre></details>` : ""}</td>
+            `;
+            body.appendChild(row);
+        }
+        if (!body.children.length) {
+            body.innerHTML = '<tr><td colspan="7" class="cell-muted">Записей нет</td></tr>';
+        }
+
+        auditBefore = result.next_before || 0;
+        moreButton.style.display = auditBefore ? "inline-block" : "none";
+    } catch (error) {
+        body.innerHTML = `<tr><td colspan="7">Ошибка загрузки: ${escapeHtml(error.message)}</td></tr>`;
+    }
+}
+
+document.getElementById("audit-filter-form")?.addEventListener("submit", function(e) {
+    e.preventDefault();
+    loadAudit(true);
+});
+
+// Курсор следующей страницы списка клиентов
+let customersCursor = "";
+
+// Дата окончания для фильтра «по дату» включительно: сервер ожидает границу не включая её
+function nextDay(value) {
+    const date = new Date(value);
+    date.setDate(date.getDate() + 1);
+    return date.toISOString().slice(0, 10);
+}
+
+// Статус подписки клиента для таблицы
+function customerStatus(customer) {
+    const blocked = customer.blocked_at ? ' <span class="cell-muted" title="Заблокировал бота">🚫</span>' : "";
+    if (!customer.expire_at) return '<span class="cell-muted">нет подписки</span>' + blocked;
+    const expired = new Date(customer.expire_at) <= new Date();
+    return `${expired ? "❌" : "✅"} ${formatDate(customer.expire_at)}${blocked}`;
+}
+
+// Загрузка списка клиентов; reset - начать с первой страницы
+async function loadCustomers(reset) {
+    const body = document.getElementById("customers-body");
+    const moreButton = document.getElementById("customers-more");
+    if (reset) {
+        customersCursor = "";
+        body.innerHTML = '<tr><td colspan="7">Загрузка...</td></tr>';
+    }
+
+    const params = new URLSearchParams();
+    const filters = {
+        q: document.getElementById("customers-q").value.trim(),
+        language: document.getElementById("customers-language").value.trim(),
+        status: document.getElementById("customers-status").value,
+        blocked: document.getElementById("customers-blocked").value,
+        created_from: document.getElementById("customers-created-from").value,
+        expire_from: document.getElementById("customers-expire-from").value,
+        sort: document.getElementById("customers-sort").value,
+        order: document.getElementById("customers-order").value,
+        cursor: customersCursor
+    };
+    for (const [key, value] of Object.entries(filters)) {
+        if (value) params.set(key, value);
+    }
+    const createdTo = document.getElementById("customers-created-to").value;
+    if (createdTo) params.set("created_to", nextDay(createdTo));
+    const expireTo = document.getElementById("customers-expire-to").value;
+    if (expireTo) params.set("expire_to", nextDay(expireTo));
+
     try {
-        const response = await fetch(`/admin/logs?lines=${lines}`);
+        const response = await fetch("/admin/customers?" + params.toString(), {
+            credentials: "same-origin",
+            headers: { "X-Requested-With": "XMLHttpRequest" }
+        });
         const result = await response.json();
-        logsContent.textContent = result.success ? (result.logs || "Логи пусты") : `Ошибка: ${result.error}`;
+        if (reset) body.innerHTML = "";
+        if (!result.success) {
+            body.innerHTML = `<tr><td colspan="7">❌ ${escapeHtml(result.error)}</td></tr>`;
+            moreButton.style.display = "none";
+            return;
+        }
+
+        document.getElementById("customers-total").textContent = `Найдено: ${result.total}`;
+        for (const customer of result.customers || []) {
+            const row = document.createElement("tr");
+            row.innerHTML = `
+                <td>${customer.id}</td>
+                <td>${customer.telegram_id}</td>
+                <td>${escapeHtml(customer.language)}</td>
+                <td>${customerStatus(customer)}</td>
+                <td>${formatDate(customer.created_at)}</td>
+                <td class="cell-muted">${escapeHtml(customer.subscription_link || "—")}</td>
+                <td><button class="btn btn-secondary" onclick="openCustomer(${customer.id})">Открыть</button></td>
+            `;
+            body.appendChild(row);
+        }
+        if (!body.children.length) {
+            body.innerHTML = '<tr><td colspan="7" class="cell-muted">Клиенты не найдены</td></tr>';
+        }
+
+        customersCursor = result.next_cursor || "";
+        moreButton.style.display = customersCursor ? "inline-block" : "none";
     } catch (error) {
-        logsContent.textContent = "Ошибка загрузки логов: " + error.message;
+        body.innerHTML = `<tr><td colspan="7">Ошибка загрузки: ${escapeHtml(error.message)}</td></tr>`;
     }
 }
 
-// Переменные для работы с переводами
-let allTranslations = {};
-let currentLanguage = "";
-let originalTranslations = {};
+// ID клиента, открытого в карточке
+let currentCustomerId = 0;
+
+// Названия действий и полей в истории изменений клиента
+const CUSTOMER_ACTIONS = {
+    extend: "продление/сокращение",
+    set_expire: "дата окончания",
+    clear_link: "удаление ссылки",
+    regenerate_link: "новая ссылка",
+    set_language: "смена языка"
+};
+
+// Значение datetime-local поля для даты
+function toLocalInputValue(value) {
+    const date = new Date(value);
+    date.setMinutes(date.getMinutes() - date.getTimezoneOffset());
+    return date.toISOString().slice(0, 16);
+}
+
+// Карточка клиента с историей изменений
+async function openCustomer(id) {
+    currentCustomerId = id;
+    const panel = document.getElementById("customer-detail");
+    document.getElementById("customer-result").style.display = "none";
 
-// Загрузка всех переводов
-async function loadTranslations() {
     try {
-        const response = await fetch("/admin/translations", {
-            method: "GET",
-            credentials: "same-origin", // Включаем куки
-            headers: {
-                "X-Requested-With": "XMLHttpRequest"
-            }
+        const response = await fetch(`/admin/customers/detail?id=${id}`, {
+            credentials: "same-origin",
+            headers: { "X-Requested-With": "XMLHttpRequest" }
         });
-        
-        if (response.status === 401) {
-            alert("Требуется повторная авторизация. Обновите страницу.");
-            window.location.reload();
-            return;
-        }
-        
         const result = await response.json();
-        
-        if (result.success) {
-            allTranslations = result.translations;
-            populateLanguageSelect();
-            console.log("Переводы загружены:", allTranslations);
-        } else {
-            console.error("Ошибка загрузки переводов:", result.error);
-            alert("Ошибка загрузки переводов: " + result.error);
+        if (!result.success) {
+            alert("Ошибка: " + result.error);
+            return;
         }
+        renderCustomer(result.customer, result.changes || []);
+        panel.style.display = "block";
+        panel.scrollIntoView({ behavior: "smooth" });
     } catch (error) {
-        console.error("Ошибка сети при загрузке переводов:", error);
-        alert("Ошибка сети при загрузке переводов: " + error.message);
+        alert("Ошибка сети: " + error.message);
     }
 }
 
-// Заполнение выпадающего списка языков
-function populateLanguageSelect() {
-    const select = document.getElementById("language-select");
-    select.innerHTML = '<option value="">Выберите язык...</option>';
-    
-    const languageNames = {
-        'ru': 'Русский (ru.json)',
-        'en': 'English (en.json)'
-    };
-    
-    for (const [langCode, translations] of Object.entries(allTranslations)) {
-        const option = document.createElement("option");
-        option.value = langCode;
-        option.textContent = languageNames[langCode] || `${langCode.toUpperCase()} (${langCode}.json)`;
-        select.appendChild(option);
+function renderCustomer(customer, changes) {
+    document.getElementById("customer-detail-title").textContent = `#${customer.id} (Telegram ${customer.telegram_id})`;
+
+    const fields = [
+        ["ID", customer.id],
+        ["Telegram ID", customer.telegram_id],
+        ["Язык", escapeHtml(customer.language)],
+        ["Подписка до", customerStatus(customer)],
+        ["Регистрация", formatDate(customer.created_at)],
+        ["Заблокировал бота", customer.blocked_at ? formatDate(customer.blocked_at) : "—"],
+        ["Ссылка подписки", customer.subscription_link ? `<code>${escapeHtml(customer.subscription_link)}</code>` : "—"]
+    ];
+    document.getElementById("customer-detail-fields").innerHTML = fields
+        .map(([name, value]) => `<tr><th>${name}</th><td>${value}</td></tr>`).join("");
+
+    const expireInput = document.getElementById("customer-expire-at");
+    if (expireInput) expireInput.value = customer.expire_at ? toLocalInputValue(customer.expire_at) : "";
+    const languageInput = document.getElementById("customer-language");
+    if (languageInput) languageInput.value = customer.language;
+
+    const body = document.getElementById("customer-changes-body");
+    body.innerHTML = changes.length ? "" : '<tr><td colspan="6" class="cell-muted">Изменений из панели не было</td></tr>';
+    for (const change of changes) {
+        const showValue = value => change.field === "expire_at" ? formatDate(value) : escapeHtml(value ?? "—");
+        const row = document.createElement("tr");
+        row.innerHTML = `
+            <td>${formatDate(change.created_at)}</td>
+            <td>${escapeHtml(change.username)}</td>
+            <td>${escapeHtml(CUSTOMER_ACTIONS[change.action] || change.action)}</td>
+            <td><code>${escapeHtml(change.field)}</code></td>
+            <td class="cell-muted">${showValue(change.old_value)}</td>
+            <td>${showValue(change.new_value)}</td>
+        `;
+        body.appendChild(row);
     }
 }
 
-// Загрузка переводов для выбранного языка
-function loadTranslationForLanguage() {
-    const select = document.getElementById("language-select");
-    const selectedLang = select.value;
-    
-    if (!selectedLang) {
-        document.getElementById("translation-editor").style.display = "none";
-        return;
-    }
-    
-    currentLanguage = selectedLang;
-    const translations = allTranslations[selectedLang];
-    
-    if (!translations) {
-        alert("Переводы для выбранного языка не найдены");
-        return;
-    }
-    
-    // Сохраняем оригинальные переводы для возможности отмены
-    originalTranslations = JSON.parse(JSON.stringify(translations));
-    
-    displayTranslationEditor(selectedLang, translations);
+function closeCustomer() {
+    currentCustomerId = 0;
+    document.getElementById("customer-detail").style.display = "none";
 }
 
-// Отображение редактора переводов
-function displayTranslationEditor(language, translations) {
-    const editor = document.getElementById("translation-editor");
-    const currentLangSpan = document.getElementById("current-language");
-    const fieldsContainer = document.getElementById("translation-fields");
-    
-    currentLangSpan.textContent = language.toUpperCase();
-    fieldsContainer.innerHTML = "";
-    
-    // Создаем поля для каждого перевода
-    for (const [key, value] of Object.entries(translations)) {
-        const fieldDiv = document.createElement("div");
-        fieldDiv.className = "translation-field";
-        
-        fieldDiv.innerHTML = `
-            <label for="trans_${key}">Перевод для ключа:</label>
-            <div class="field-key">${key}</div>
-            <textarea id="trans_${key}" name="${key}">${escapeHtml(value)}</textarea>
-        `;
-        
-        fieldsContainer.appendChild(fieldDiv);
+// Изменение клиента; после успеха карточка и список перезагружаются
+async function updateCustomer(data, confirmText) {
+    if (!currentCustomerId) return;
+    if (confirmText && !confirm(confirmText)) return;
+
+    const resultBox = document.getElementById("customer-result");
+    try {
+        const result = await postJSON("/admin/customers/update", { id: currentCustomerId, ...data });
+        resultBox.className = result.success ? "result-box result-success" : "result-box result-error";
+        resultBox.textContent = result.success ? "✅ " + result.message : "❌ " + result.error;
+        resultBox.style.display = "block";
+        if (result.success) {
+            const message = resultBox.textContent;
+            await openCustomer(currentCustomerId);
+            resultBox.textContent = message;
+            resultBox.style.display = "block";
+            loadCustomers(true);
+        }
+    } catch (error) {
+        alert("Ошибка сети: " + error.message);
     }
-    
-    editor.style.display = "block";
-    document.getElementById("translation-result").style.display = "none";
 }
 
-// Сохранение переводов
-async function saveTranslations() {
-    if (!currentLanguage) {
-        alert("Язык не выбран");
-        return;
-    }
-    
-    const fieldsContainer = document.getElementById("translation-fields");
-    const textareas = fieldsContainer.querySelectorAll("textarea");
-    const updatedTranslations = {};
-    
-    // Собираем обновленные переводы
-    textareas.forEach(textarea => {
-        const key = textarea.name;
-        const value = textarea.value;
-        updatedTranslations[key] = value;
+function extendCustomer(sign) {
+    const days = parseInt(document.getElementById("customer-days").value, 10);
+    if (!days || days <= 0) { alert("Укажите число дней"); return; }
+    updateCustomer({ action: "extend", days: sign * days }, sign < 0 ? `Сократить подписку на ${days} дн.?` : "");
+}
+
+function setCustomerExpire() {
+    const value = document.getElementById("customer-expire-at").value;
+    if (!value) { alert("Укажите дату"); return; }
+    updateCustomer({ action: "set_expire", expire_at: new Date(value).toISOString() }, "");
+}
+
+function setCustomerLanguage() {
+    const language = document.getElementById("customer-language").value.trim();
+    if (!language) { alert("Укажите язык"); return; }
+    updateCustomer({ action: "set_language", language: language }, "");
+}
+
+document.getElementById("customers-filter-form")?.addEventListener("submit", function(e) {
+    e.preventDefault();
+    loadCustomers(true);
+});
+
+// Курсор выдается для конкретной сортировки: после ее смены список загружается с первой страницы
+for (const id of ["customers-sort", "customers-order"]) {
+    document.getElementById(id)?.addEventListener("change", () => loadCustomers(true));
+}
+
+// Действие формы кода 2FA: enable, disable или recovery-codes
+let twoFactorAction = "";
+
+// Отправка JSON запроса к API панели
+async function postJSON(url, data) {
+    const response = await fetch(url, {
+        method: "POST",
+        credentials: "same-origin",
+        headers: {
+            "Content-Type": "application/json",
+            "X-Requested-With": "XMLHttpRequest",
+            "X-CSRF-Token": CSRF_TOKEN
+        },
+        body: JSON.stringify(data || {})
     });
-    
+    return response.json();
+}
+
+// Показ кодов восстановления
+function showRecoveryCodes(message, codes) {
+    const resultBox = document.getElementById("twofa-result");
+    resultBox.className = "result-box result-success";
+    resultBox.innerHTML = `✅ ${escapeHtml(message)}<br/>🔑 Коды восстановления (каждый действует один раз, сохраните их в надежном месте):<pre>${codes.map(escapeHtml).join("\n")}</pre>`;
+    resultBox.style.display = "block";
+}
+
+// Загрузка состояния 2FA
+async function loadTwoFactor() {
+    const statusDiv = document.getElementById("twofa-status");
+    const form = document.getElementById("twofa-form");
+    document.getElementById("twofa-setup").style.display = "none";
+    form.style.display = "none";
+
     try {
-        const response = await fetch("/admin/translations/update", {
-            method: "POST",
-            credentials: "same-origin", // Включаем куки
-            headers: {
-                "Content-Type": "application/json",
-                "X-Requested-With": "XMLHttpRequest"
-            },
-            body: JSON.stringify({
-                language: currentLanguage,
-                data: updatedTranslations
-            })
+        const response = await fetch("/admin/2fa", {
+            credentials: "same-origin",
+            headers: { "X-Requested-With": "XMLHttpRequest" }
         });
-        
         const result = await response.json();
-        const resultBox = document.getElementById("translation-result");
-        const statusDiv = document.getElementById("translation-status");
-        
-        if (result.success) {
-            // Обновляем локальные данные
-            allTranslations[currentLanguage] = updatedTranslations;
-            originalTranslations = JSON.parse(JSON.stringify(updatedTranslations));
-            
-            statusDiv.innerHTML = `<div style="color: green;">✅ ${result.message}<br/>🔄 Перезапускаем бота для применения изменений...</div>`;
-            resultBox.className = "result-box result-success";
-            
-            // Автоматически перезапускаем бота
-            restartBot();
+        if (!result.success) {
+            statusDiv.textContent = "Ошибка: " + result.error;
+            return;
+        }
+
+        if (result.enabled) {
+            statusDiv.innerHTML = `✅ Включена. Осталось кодов восстановления: ${result.recovery_codes_left}<br/><br/>
+                <button class="btn btn-secondary" onclick="askTwoFactorCode('recovery-codes')">🔄 Новые коды восстановления</button>
+                ${result.enforced ? "" : '<button class="btn btn-secondary" onclick="askTwoFactorCode(\'disable\')">❌ Отключить</button>'}`;
         } else {
-            statusDiv.innerHTML = `<div style="color: red;">❌ Ошибка: ${result.error}</div>`;
-            resultBox.className = "result-box result-error";
+            statusDiv.innerHTML = `❌ Не включена${result.enforced ? " (обязательна для доступа к панели)" : ""}<br/><br/>
+                <button class="btn btn-primary" onclick="startTwoFactorSetup()">🛡️ Включить</button>`;
         }
-        
-        resultBox.style.display = "block";
-        
     } catch (error) {
-        console.error("Ошибка сохранения:", error);
-        const resultBox = document.getElementById("translation-result");
-        const statusDiv = document.getElementById("translation-status");
-        statusDiv.innerHTML = `<div style="color: red;">❌ Ошибка сети: ${error.message}</div>`;
-        resultBox.className = "result-box result-error";
-        resultBox.style.display = "block";
+        statusDiv.textContent = "Ошибка сети: " + error.message;
     }
 }
 
-// Отмена редактирования
-function cancelEdit() {
-    if (!currentLanguage) return;
-    
-    if (confirm("Отменить все изменения и вернуть исходные значения?")) {
-        displayTranslationEditor(currentLanguage, originalTranslations);
-        document.getElementById("translation-result").style.display = "none";
-    }
+// Запрос кода для действия с 2FA
+function askTwoFactorCode(action) {
+    twoFactorAction = action;
+    const labels = {
+        "enable": "✅ Подтвердить",
+        "disable": "❌ Отключить 2FA",
+        "recovery-codes": "🔄 Выпустить коды"
+    };
+    document.getElementById("twofa-submit").textContent = labels[action];
+    document.getElementById("twofa-code").value = "";
+    document.getElementById("twofa-form").style.display = "flex";
 }
 
-// Вспомогательная функция для экранирования HTML
-function escapeHtml(text) {
-    const div = document.createElement('div');
-    div.textContent = text;
-    return div.innerHTML;
-}
+// Получение секрета для приложения
+async function startTwoFactorSetup() {
+    try {
+        const result = await postJSON("/admin/2fa/setup");
+        if (!result.success) {
+            alert("Ошибка: " + result.error);
+            return;
+        }
 
-// Модифицируем функцию showTab для автозагрузки переводов
-function showTab(tabName) {
-    document.querySelectorAll(".tab-content").forEach(tab => tab.classList.remove("active"));
-    document.querySelectorAll(".tab-btn").forEach(btn => btn.classList.remove("active"));
-    document.getElementById(tabName + "-tab").classList.add("active");
-    event.target.classList.add("active");
-    
-    if (tabName === "logs") loadLogs();
-    
-    // Если открываем вкладку переводов, загружаем данные
-    if (tabName === 'translations' && Object.keys(allTranslations).length === 0) {
-        loadTranslations();
+        const link = document.getElementById("twofa-uri");
+        link.href = result.uri;
+        link.textContent = result.uri;
+        document.getElementById("twofa-secret").textContent = result.secret;
+        document.getElementById("twofa-setup").style.display = "block";
+        askTwoFactorCode("enable");
+    } catch (error) {
+        alert("Ошибка сети: " + error.message);
     }
 }
 
-// Функция перезапуска основного бота
-async function restartBot() {
+document.getElementById("twofa-form").addEventListener("submit", async function(e) {
+    e.preventDefault();
+    const code = document.getElementById("twofa-code").value.trim();
+    const resultBox = document.getElementById("twofa-result");
+
     try {
-        const response = await fetch("/admin/restart-bot", {
-            method: "POST",
-            credentials: "same-origin",
-            headers: {
-                "X-Requested-With": "XMLHttpRequest",
-                "Content-Type": "application/json"
-            }
-        });
-        
-        if (response.status === 401) {
-            alert("Требуется повторная авторизация. Обновите страницу.");
-            window.location.reload();
+        const result = await postJSON(`/admin/2fa/${twoFactorAction}`, { code: code });
+        if (!result.success) {
+            resultBox.className = "result-box result-error";
+            resultBox.innerHTML = `❌ ${escapeHtml(result.error)}`;
+            resultBox.style.display = "block";
             return;
         }
-        
-        const result = await response.json();
-        const statusDiv = document.getElementById("translation-status");
-        
-        if (result.success) {
-            statusDiv.innerHTML = `<div style="color: green;">✅ Переводы сохранены<br/>🎉 Бот успешно перезапущен и загрузил новые переводы!</div>`;
+
+        if (result.recovery_codes) {
+            showRecoveryCodes(result.message, result.recovery_codes);
         } else {
-            statusDiv.innerHTML = `<div style="color: orange;">⚠️ Переводы сохранены, но не удалось перезапустить бота автоматически.<br/>❌ ${result.error}<br/>💡 Попробуйте перезапустить бота вручную.</div>`;
+            resultBox.className = "result-box result-success";
+            resultBox.innerHTML = `✅ ${escapeHtml(result.message)}`;
+            resultBox.style.display = "block";
         }
-        
+
+        // После включения обязательной 2FA открываются остальные вкладки
+        if (twoFactorAction === "enable" && ADMIN_PERMISSIONS.length === 0) {
+            resultBox.innerHTML += '<br/><button class="btn btn-primary" onclick="window.location.reload()">Сохранил коды, открыть панель</button>';
+        }
+        loadTwoFactor();
     } catch (error) {
-        console.error("Ошибка перезапуска бота:", error);
-        const statusDiv = document.getElementById("translation-status");
-        statusDiv.innerHTML = `<div style="color: orange;">⚠️ Переводы сохранены, но произошла ошибка при перезапуске бота.<br/>💡 Попробуйте перезапустить бота вручную.</div>`;
+        alert("Ошибка сети: " + error.message);
     }
-}
+});
+
+const broadcastTimezoneInput = document.getElementById("broadcast-timezone");
+if (broadcastTimezoneInput) broadcastTimezoneInput.value = BROWSER_TIMEZONE;
+
+// Открываем первую доступную роли вкладку
+const firstTab = document.querySelector(".tab-btn");
+if (firstTab) showTab(firstTab.dataset.tab);
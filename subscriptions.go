@@ -0,0 +1,376 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// Действия над подпиской клиента. Срок и ссылку подписки выпускает Remnawave: extend и set_expire меняют
+// дату и в Remnawave (если он настроен), regenerate_link отзывает подписку в Remnawave и сохраняет новую ссылку,
+// clear_link удаляет ссылку только в БД бота
+const (
+	CustomerActionExtend         = "extend"
+	CustomerActionSetExpire      = "set_expire"
+	CustomerActionClearLink      = "clear_link"
+	CustomerActionRegenerateLink = "regenerate_link"
+	CustomerActionSetLanguage    = "set_language"
+)
+
+const (
+	customerMaxShiftDays = 3650
+	customerChangesShown = 50
+)
+
+var (
+	errCustomerNotFound = errors.New("customer not found")
+	languageCodePattern = regexp.MustCompile(`^[a-z]{2,3}(-[a-zA-Z0-9]{2,8})?$`)
+)
+
+// CustomerChange - запись истории изменений клиента из панели
+type CustomerChange struct {
+	ID        int64     `json:"id"`
+	AdminID   *int64    `json:"admin_id"`
+	Username  string    `json:"username"`
+	Action    string    `json:"action"`
+	Field     string    `json:"field"`
+	OldValue  *string   `json:"old_value"`
+	NewValue  *string   `json:"new_value"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type CustomerDetailResponse struct {
+	Success  bool             `json:"success"`
+	Customer *Customer        `json:"customer,omitempty"`
+	Changes  []CustomerChange `json:"changes,omitempty"`
+	Message  string           `json:"message,omitempty"`
+	Error    string           `json:"error,omitempty"`
+}
+
+// UpdateCustomerRequest - действие над клиентом: days для extend (отрицательное - сократить),
+// expire_at для set_expire, language для set_language
+type UpdateCustomerRequest struct {
+	ID       int64      `json:"id"`
+	Action   string     `json:"action"`
+	Days     int        `json:"days"`
+	ExpireAt *time.Time `json:"expire_at"`
+	Language string     `json:"language"`
+}
+
+// getCustomer - клиент по ID
+func getCustomer(ctx context.Context, q pgxQuerier, id int64, forUpdate bool) (*Customer, error) {
+	query := `SELECT id, telegram_id, expire_at, created_at, subscription_link, language, ` + customerBlockedAtColumn + `
+			  FROM customer
+			  WHERE id = $1`
+	if forUpdate {
+		query += ` FOR UPDATE`
+	}
+
+	var customer Customer
+	err := q.QueryRow(ctx, query, id).Scan(
+		&customer.ID,
+		&customer.TelegramID,
+		&customer.ExpireAt,
+		&customer.CreatedAt,
+		&customer.SubscriptionLink,
+		&customer.Language,
+		&customer.BlockedAt,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errCustomerNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query customer: %w", err)
+	}
+	return &customer, nil
+}
+
+// listCustomerChanges - последние изменения клиента из панели
+func (s *Server) listCustomerChanges(ctx context.Context, customerID int64, limit int) ([]CustomerChange, error) {
+	query := `SELECT id, admin_id, username, action, field, old_value, new_value, created_at
+			  FROM admin_customer_change
+			  WHERE customer_id = $1
+			  ORDER BY id DESC
+			  LIMIT $2`
+
+	rows, err := s.db.Query(ctx, query, customerID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query customer changes: %w", err)
+	}
+	defer rows.Close()
+
+	var changes []CustomerChange
+	for rows.Next() {
+		var change CustomerChange
+		err := rows.Scan(
+			&change.ID,
+			&change.AdminID,
+			&change.Username,
+			&change.Action,
+			&change.Field,
+			&change.OldValue,
+			&change.NewValue,
+			&change.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan customer change: %w", err)
+		}
+		changes = append(changes, change)
+	}
+
+	return changes, rows.Err()
+}
+
+// formatExpireAt - значение expire_at для истории изменений
+func formatExpireAt(t *time.Time) *string {
+	if t == nil {
+		return nil
+	}
+	value := t.UTC().Format(time.RFC3339)
+	return &value
+}
+
+// shiftExpireAt - новая дата окончания после продления или сокращения на days дней.
+// Продление истекшей подписки считается от текущего момента, сократить можно только существующую.
+func shiftExpireAt(current *time.Time, days int, now time.Time) (time.Time, error) {
+	if days == 0 || days > customerMaxShiftDays || days < -customerMaxShiftDays {
+		return time.Time{}, fmt.Errorf("days must be non-zero and within ±%d", customerMaxShiftDays)
+	}
+
+	if days < 0 {
+		if current == nil {
+			return time.Time{}, fmt.Errorf("у клиента нет подписки, сокращать нечего")
+		}
+		return current.AddDate(0, 0, days), nil
+	}
+
+	base := now
+	if current != nil && current.After(now) {
+		base = *current
+	}
+	return base.AddDate(0, 0, days), nil
+}
+
+// updateCustomer - применяет действие к клиенту в транзакции и записывает изменение в историю от имени администратора
+func (s *Server) updateCustomer(ctx context.Context, session *Session, req UpdateCustomerRequest) (*Customer, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	customer, err := getCustomer(ctx, tx, req.ID, true)
+	if err != nil {
+		return nil, err
+	}
+
+	var field string
+	var oldValue, newValue *string
+
+	switch req.Action {
+	case CustomerActionExtend, CustomerActionSetExpire:
+		var expireAt time.Time
+		if req.Action == CustomerActionExtend {
+			expireAt, err = shiftExpireAt(customer.ExpireAt, req.Days, time.Now())
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			if req.ExpireAt == nil {
+				return nil, fmt.Errorf("expire_at is required")
+			}
+			expireAt = *req.ExpireAt
+		}
+
+		field, oldValue, newValue = "expire_at", formatExpireAt(customer.ExpireAt), formatExpireAt(&expireAt)
+		if _, err := tx.Exec(ctx, `UPDATE customer SET expire_at = $2 WHERE id = $1`, customer.ID, expireAt); err != nil {
+			return nil, fmt.Errorf("failed to update expire_at: %w", err)
+		}
+
+		// Подписка действует по дате в Remnawave: без него меняется только копия в БД бота. Ошибка Remnawave
+		// откатывает транзакцию, чтобы даты не разошлись
+		if s.remnawave.Configured() {
+			user, err := s.remnawaveUser(ctx, customer)
+			if err != nil {
+				return nil, err
+			}
+			if _, err := s.remnawave.UpdateExpireAt(ctx, user.UUID, expireAt); err != nil {
+				return nil, fmt.Errorf("failed to update Remnawave user: %w", err)
+			}
+			setAuditDetail(ctx, "remnawave_uuid", user.UUID)
+		}
+		customer.ExpireAt = &expireAt
+
+	case CustomerActionRegenerateLink:
+		if !s.remnawave.Configured() {
+			return nil, errRemnawaveNotConfigured
+		}
+		user, err := s.remnawaveUser(ctx, customer)
+		if err != nil {
+			return nil, err
+		}
+		revoked, err := s.remnawave.RevokeSubscription(ctx, user.UUID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to revoke Remnawave subscription: %w", err)
+		}
+		if revoked.SubscriptionURL == "" {
+			return nil, errors.New("remnawave не вернул новую ссылку подписки")
+		}
+		setAuditDetail(ctx, "remnawave_uuid", user.UUID)
+
+		// Старая ссылка в Remnawave уже отозвана: если новую не удастся сохранить, ее видно в журнале
+		link := revoked.SubscriptionURL
+		field, oldValue, newValue = "subscription_link", customer.SubscriptionLink, &link
+		if _, err := tx.Exec(ctx, `UPDATE customer SET subscription_link = $2 WHERE id = $1`, customer.ID, link); err != nil {
+			slog.Error("Subscription revoked in Remnawave but not saved", "customer_id", customer.ID, "subscription_link", link, "error", err)
+			return nil, fmt.Errorf("failed to update subscription_link: %w", err)
+		}
+		customer.SubscriptionLink = &link
+
+	case CustomerActionClearLink:
+		// Удаляется только копия ссылки в БД бота; в Remnawave подписка продолжает работать
+		field, oldValue, newValue = "subscription_link", customer.SubscriptionLink, nil
+		if _, err := tx.Exec(ctx, `UPDATE customer SET subscription_link = NULL WHERE id = $1`, customer.ID); err != nil {
+			return nil, fmt.Errorf("failed to update subscription_link: %w", err)
+		}
+		customer.SubscriptionLink = nil
+
+	case CustomerActionSetLanguage:
+		language := strings.TrimSpace(req.Language)
+		if !languageCodePattern.MatchString(language) {
+			return nil, fmt.Errorf("invalid language code %q", req.Language)
+		}
+
+		old := customer.Language
+		field, oldValue, newValue = "language", &old, &language
+		if _, err := tx.Exec(ctx, `UPDATE customer SET language = $2 WHERE id = $1`, customer.ID, language); err != nil {
+			return nil, fmt.Errorf("failed to update language: %w", err)
+		}
+		customer.Language = language
+
+	default:
+		return nil, fmt.Errorf("unknown action %q", req.Action)
+	}
+
+	_, err = tx.Exec(ctx,
+		`INSERT INTO admin_customer_change (customer_id, admin_id, username, action, field, old_value, new_value)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		customer.ID, session.AdminID, session.Username, req.Action, field, oldValue, newValue,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record customer change: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		if req.Action == CustomerActionRegenerateLink {
+			slog.Error("Subscription revoked in Remnawave but not saved", "customer_id", customer.ID, "subscription_link", *newValue, "error", err)
+		}
+		return nil, fmt.Errorf("failed to commit customer update: %w", err)
+	}
+
+	setAuditDetail(ctx, "diff", map[string]interface{}{
+		field: map[string]*string{"old": oldValue, "new": newValue},
+	})
+	return customer, nil
+}
+
+// customerUpdateMessage - что изменилось: в БД бота и в Remnawave или только в БД бота
+func customerUpdateMessage(action string, remnawaveConfigured bool) string {
+	switch action {
+	case CustomerActionExtend, CustomerActionSetExpire:
+		if remnawaveConfigured {
+			return "Дата окончания изменена в БД бота и в Remnawave"
+		}
+		return "Дата окончания изменена только в БД бота: Remnawave не настроен (REMNAWAVE_URL, REMNAWAVE_TOKEN)"
+	case CustomerActionRegenerateLink:
+		return "Подписка отозвана в Remnawave, новая ссылка сохранена"
+	case CustomerActionClearLink:
+		return "Ссылка удалена в БД бота; подписка в Remnawave не отозвана"
+	default:
+		return "Изменения сохранены"
+	}
+}
+
+// customerDetailHandler - карточка клиента и история изменений из панели
+func (s *Server) customerDetailHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil || id <= 0 {
+		writeJSONError(w, http.StatusBadRequest, "Customer ID is required")
+		return
+	}
+
+	customer, err := getCustomer(r.Context(), s.db, id, false)
+
+	var changes []CustomerChange
+	if err == nil {
+		changes, err = s.listCustomerChanges(r.Context(), id, customerChangesShown)
+	}
+
+	response := CustomerDetailResponse{
+		Success:  err == nil,
+		Customer: customer,
+		Changes:  changes,
+	}
+
+	if err != nil {
+		if !errors.Is(err, errCustomerNotFound) {
+			slog.Error("Failed to load customer", "customer_id", id, "error", err)
+		}
+		response.Error = err.Error()
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// updateCustomerHandler - изменение подписки, ссылки или языка клиента
+func (s *Server) updateCustomerHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req UpdateCustomerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if req.ID == 0 || req.Action == "" {
+		http.Error(w, "Customer ID and action are required", http.StatusBadRequest)
+		return
+	}
+
+	setAuditTarget(r.Context(), fmt.Sprintf("customer:%d", req.ID))
+
+	current := sessionFromContext(r.Context())
+	customer, err := s.updateCustomer(r.Context(), current, req)
+
+	response := CustomerDetailResponse{
+		Success:  err == nil,
+		Customer: customer,
+	}
+
+	if err != nil {
+		response.Error = err.Error()
+	} else {
+		log.Printf("👤 %s изменил клиента #%d (telegram %d): %s", current.Username, customer.ID, customer.TelegramID, req.Action)
+		response.Message = customerUpdateMessage(req.Action, s.remnawave.Configured())
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
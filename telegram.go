@@ -0,0 +1,517 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Лимиты Bot API: около 30 сообщений в секунду на бота и не больше одного сообщения в секунду в один чат.
+// Берем с запасом, чтобы не упираться в 429.
+const (
+	telegramGlobalRate  = 25
+	telegramGlobalBurst = 25
+	telegramChatRate    = 1
+	telegramChatBurst   = 1
+
+	telegramMaxRetries     = 5
+	telegramBaseBackoff    = time.Second
+	telegramMaxBackoff     = 30 * time.Second
+	telegramRequestTimeout = 30 * time.Second
+	telegramUploadTimeout  = 5 * time.Minute
+
+	// telegramChatBucketsLimit - после скольких чатов неиспользуемые ограничители начинают удаляться
+	telegramChatBucketsLimit = 10000
+)
+
+// telegramDefaultAPIURL - адрес Bot API по умолчанию; TELEGRAM_API_URL задает свой (локальный Bot API сервер
+// или fake-bot-api для проверки рассылок)
+const telegramDefaultAPIURL = "https://api.telegram.org"
+
+var errTelegramNotConfigured = errors.New("Telegram token not configured")
+
+// TelegramAPI - методы Bot API, которые использует панель. Реализация - TelegramClient; адрес API настраивается,
+// поэтому рассылки можно проверить на fake-bot-api без настоящего Telegram.
+type TelegramAPI interface {
+	Configured() bool
+	SendMessage(ctx context.Context, chatID int64, text string, markup *InlineKeyboardMarkup) (int64, error)
+	SendMedia(ctx context.Context, chatID int64, media TelegramMedia, caption string, markup *InlineKeyboardMarkup) (telegramMessage, error)
+	EditMessage(ctx context.Context, chatID, messageID int64, text string, caption bool, markup *InlineKeyboardMarkup) error
+	DeleteMessage(ctx context.Context, chatID, messageID int64) error
+}
+
+// TelegramError - ошибка, которую вернул Bot API
+type TelegramError struct {
+	StatusCode  int
+	ErrorCode   int
+	Description string
+	RetryAfter  time.Duration
+}
+
+func (e *TelegramError) Error() string {
+	if e.Description == "" {
+		return fmt.Sprintf("telegram API error: %d", e.StatusCode)
+	}
+	return fmt.Sprintf("telegram API error %d: %s", e.ErrorCode, e.Description)
+}
+
+// retryable - имеет ли смысл повторить запрос: 429 и ошибки сервера Telegram
+func (e *TelegramError) retryable() bool {
+	return e.ErrorCode == http.StatusTooManyRequests || e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// RecipientUnreachable - пользователь заблокировал бота, удалил аккаунт или чат не существует:
+// повторные отправки этому получателю бессмысленны
+func (e *TelegramError) RecipientUnreachable() bool {
+	if e.ErrorCode == http.StatusForbidden {
+		return true
+	}
+	return e.ErrorCode == http.StatusBadRequest && strings.Contains(strings.ToLower(e.Description), "chat not found")
+}
+
+// isRecipientUnreachable - ошибка отправки означает, что получатель недоступен для бота
+func isRecipientUnreachable(err error) bool {
+	var tgErr *TelegramError
+	return errors.As(err, &tgErr) && tgErr.RecipientUnreachable()
+}
+
+// telegramResponse - общий формат ответа Bot API
+type telegramResponse struct {
+	OK          bool            `json:"ok"`
+	Result      json.RawMessage `json:"result"`
+	ErrorCode   int             `json:"error_code"`
+	Description string          `json:"description"`
+	Parameters  struct {
+		RetryAfter int `json:"retry_after"`
+	} `json:"parameters"`
+}
+
+// telegramClock - источник времени и пауз для ограничителей и повторов; в тестах подменяется,
+// чтобы проверять лимиты и задержки без реального ожидания
+type telegramClock struct {
+	now   func() time.Time
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+var systemClock = telegramClock{now: time.Now, sleep: sleepContext}
+
+// tokenBucket - ограничитель частоты: rate токенов в секунду, не больше burst накопленных
+type tokenBucket struct {
+	mu     sync.Mutex
+	now    func() time.Time
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate, burst float64, now func() time.Time) *tokenBucket {
+	return &tokenBucket{now: now, rate: rate, burst: burst, tokens: burst, last: now()}
+}
+
+// reserve - забирает токен и возвращает, сколько нужно подождать до его появления
+func (b *tokenBucket) reserve() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens--
+
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// idle - восстановился ли ограничитель полностью, то есть давно не использовался
+func (b *tokenBucket) idle() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.tokens+b.now().Sub(b.last).Seconds()*b.rate >= b.burst
+}
+
+// sleepContext - пауза, прерываемая отменой контекста
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// TelegramClient - общий клиент Bot API для всей панели: соблюдает лимиты и повторяет запросы после 429 и 5xx
+type TelegramClient struct {
+	token      string
+	baseURL    string
+	httpClient *http.Client
+	clock      telegramClock
+
+	global *tokenBucket
+
+	mu          sync.Mutex
+	chats       map[int64]*tokenBucket
+	pausedUntil time.Time // после 429 Telegram просит подождать всех
+}
+
+func newTelegramClient(token, baseURL string) *TelegramClient {
+	return newTelegramClientWithClock(token, baseURL, systemClock)
+}
+
+func newTelegramClientWithClock(token, baseURL string, clock telegramClock) *TelegramClient {
+	return &TelegramClient{
+		token:      token,
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: &http.Client{},
+		clock:      clock,
+		global:     newTokenBucket(telegramGlobalRate, telegramGlobalBurst, clock.now),
+		chats:      make(map[int64]*tokenBucket),
+	}
+}
+
+// Configured - задан ли токен бота
+func (c *TelegramClient) Configured() bool {
+	return c.token != ""
+}
+
+// chatBucket - ограничитель для чата; неиспользуемые ограничители периодически удаляются
+func (c *TelegramClient) chatBucket(chatID int64) *tokenBucket {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if bucket, ok := c.chats[chatID]; ok {
+		return bucket
+	}
+
+	if len(c.chats) >= telegramChatBucketsLimit {
+		for id, bucket := range c.chats {
+			if bucket.idle() {
+				delete(c.chats, id)
+			}
+		}
+	}
+
+	bucket := newTokenBucket(telegramChatRate, telegramChatBurst, c.clock.now)
+	c.chats[chatID] = bucket
+	return bucket
+}
+
+// pauseFor - общая пауза для всех запросов после ответа 429
+func (c *TelegramClient) pauseFor(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if until := c.clock.now().Add(d); until.After(c.pausedUntil) {
+		c.pausedUntil = until
+	}
+}
+
+func (c *TelegramClient) pauseRemaining() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.pausedUntil.Sub(c.clock.now())
+}
+
+// throttle - ждет общей паузы после 429 и токенов глобального и (если chatID != 0) чатового ограничителей
+func (c *TelegramClient) throttle(ctx context.Context, chatID int64) error {
+	if err := c.clock.sleep(ctx, c.pauseRemaining()); err != nil {
+		return err
+	}
+	if chatID != 0 {
+		if err := c.clock.sleep(ctx, c.chatBucket(chatID).reserve()); err != nil {
+			return err
+		}
+	}
+	return c.clock.sleep(ctx, c.global.reserve())
+}
+
+// Call - вызывает метод Bot API с JSON параметрами и раскладывает result в result (если не nil).
+// chatID используется для лимита на чат, 0 - запрос не адресован чату.
+func (c *TelegramClient) Call(ctx context.Context, method string, chatID int64, params interface{}, result interface{}) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s params: %w", method, err)
+	}
+	return c.call(ctx, method, chatID, telegramRequest{body: body, contentType: "application/json", timeout: telegramRequestTimeout}, result)
+}
+
+// CallMultipart - вызывает метод Bot API с загрузкой файла: fields - обычные параметры, файл передается в поле fileField
+func (c *TelegramClient) CallMultipart(ctx context.Context, method string, chatID int64, fields map[string]string, fileField, fileName string, data []byte, result interface{}) error {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for name, value := range fields {
+		if err := writer.WriteField(name, value); err != nil {
+			return fmt.Errorf("failed to build %s request: %w", method, err)
+		}
+	}
+	part, err := writer.CreateFormFile(fileField, fileName)
+	if err == nil {
+		_, err = part.Write(data)
+	}
+	if err == nil {
+		err = writer.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to build %s request: %w", method, err)
+	}
+
+	return c.call(ctx, method, chatID, telegramRequest{body: body.Bytes(), contentType: writer.FormDataContentType(), timeout: telegramUploadTimeout}, result)
+}
+
+// telegramRequest - готовое тело запроса; хранится целиком, чтобы его можно было отправить повторно
+type telegramRequest struct {
+	body        []byte
+	contentType string
+	timeout     time.Duration
+}
+
+// call - запрос с учетом лимитов и повторами после 429 и 5xx
+func (c *TelegramClient) call(ctx context.Context, method string, chatID int64, request telegramRequest, result interface{}) error {
+	if !c.Configured() {
+		return errTelegramNotConfigured
+	}
+
+	backoff := telegramBaseBackoff
+	for attempt := 0; ; attempt++ {
+		if err := c.throttle(ctx, chatID); err != nil {
+			return err
+		}
+
+		raw, err := c.do(ctx, method, request)
+		if err == nil {
+			if result != nil {
+				if err := json.Unmarshal(raw, result); err != nil {
+					return fmt.Errorf("failed to parse %s result: %w", method, err)
+				}
+			}
+			return nil
+		}
+
+		// Сетевые ошибки не повторяем: запрос мог дойти, и сообщение пришло бы дважды
+		var tgErr *TelegramError
+		if !errors.As(err, &tgErr) || !tgErr.retryable() || ctx.Err() != nil || attempt >= telegramMaxRetries {
+			return err
+		}
+
+		// 429: ждем столько, сколько просит Telegram, 5xx - с экспоненциальной задержкой
+		wait := backoff
+		if tgErr.RetryAfter > 0 {
+			wait = tgErr.RetryAfter
+			c.pauseFor(wait)
+		} else {
+			backoff = min(backoff*2, telegramMaxBackoff)
+		}
+
+		slog.Warn("Retrying Telegram request",
+			"method", method,
+			"chat_id", chatID,
+			"attempt", attempt+1,
+			"wait", wait,
+			"error", err)
+
+		if err := c.clock.sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// do - один HTTP запрос к Bot API; ответ без ok превращается в *TelegramError
+func (c *TelegramClient) do(ctx context.Context, method string, request telegramRequest) (json.RawMessage, error) {
+	url := fmt.Sprintf("%s/bot%s/%s", c.baseURL, c.token, method)
+
+	ctx, cancel := context.WithTimeout(ctx, request.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(request.body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", request.contentType)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		// Ошибка сети: токен в URL не должен попасть в логи
+		var urlErr interface{ Unwrap() error }
+		if errors.As(err, &urlErr) {
+			err = urlErr.Unwrap()
+		}
+		return nil, fmt.Errorf("telegram request %s failed: %w", method, err)
+	}
+	defer resp.Body.Close()
+
+	var parsed telegramResponse
+	if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil || !parsed.OK {
+		tgErr := &TelegramError{
+			StatusCode:  resp.StatusCode,
+			ErrorCode:   parsed.ErrorCode,
+			Description: parsed.Description,
+			RetryAfter:  time.Duration(parsed.Parameters.RetryAfter) * time.Second,
+		}
+		if tgErr.ErrorCode == 0 {
+			tgErr.ErrorCode = resp.StatusCode
+		}
+		return nil, tgErr
+	}
+
+	return parsed.Result, nil
+}
+
+// InlineButton - кнопка inline клавиатуры: ссылка или callback_data для бота
+type InlineButton struct {
+	Text         string `json:"text"`
+	URL          string `json:"url,omitempty"`
+	CallbackData string `json:"callback_data,omitempty"`
+}
+
+// InlineKeyboardMarkup - reply_markup с inline кнопками, по строкам
+type InlineKeyboardMarkup struct {
+	InlineKeyboard [][]InlineButton `json:"inline_keyboard"`
+}
+
+// telegramFile - загруженный в Telegram файл
+type telegramFile struct {
+	FileID string `json:"file_id"`
+}
+
+// telegramMessage - часть объекта Message из ответа Bot API
+type telegramMessage struct {
+	MessageID int64          `json:"message_id"`
+	Photo     []telegramFile `json:"photo"` // размеры по возрастанию
+	Video     *telegramFile  `json:"video"`
+	Document  *telegramFile  `json:"document"`
+}
+
+// fileID - file_id вложения сообщения, чтобы отправлять тот же файл без повторной загрузки
+func (m telegramMessage) fileID() string {
+	switch {
+	case len(m.Photo) > 0:
+		return m.Photo[len(m.Photo)-1].FileID
+	case m.Video != nil:
+		return m.Video.FileID
+	case m.Document != nil:
+		return m.Document.FileID
+	}
+	return ""
+}
+
+// telegramMediaMethods - метод Bot API и имя поля файла для типа вложения
+var telegramMediaMethods = map[string]struct {
+	method string
+	field  string
+}{
+	MediaPhoto:    {"sendPhoto", "photo"},
+	MediaVideo:    {"sendVideo", "video"},
+	MediaDocument: {"sendDocument", "document"},
+}
+
+// TelegramMedia - вложение для отправки: URL или file_id в Source, либо содержимое файла в Data
+type TelegramMedia struct {
+	Type     string
+	Source   string
+	FileName string
+	Data     []byte
+}
+
+// SendMessage - отправляет HTML сообщение в чат и возвращает ID сообщения
+func (c *TelegramClient) SendMessage(ctx context.Context, chatID int64, text string, markup *InlineKeyboardMarkup) (int64, error) {
+	params := map[string]interface{}{
+		"chat_id":    chatID,
+		"text":       text,
+		"parse_mode": "HTML",
+	}
+	if markup != nil {
+		params["reply_markup"] = markup
+	}
+
+	var message telegramMessage
+	if err := c.Call(ctx, "sendMessage", chatID, params, &message); err != nil {
+		return 0, err
+	}
+	return message.MessageID, nil
+}
+
+// SendMedia - отправляет фото, видео или документ с HTML подписью.
+// Возвращает отправленное сообщение, из которого можно взять file_id загруженного файла.
+func (c *TelegramClient) SendMedia(ctx context.Context, chatID int64, media TelegramMedia, caption string, markup *InlineKeyboardMarkup) (telegramMessage, error) {
+	var message telegramMessage
+
+	target, ok := telegramMediaMethods[media.Type]
+	if !ok {
+		return message, fmt.Errorf("unknown media type %q", media.Type)
+	}
+
+	if media.Data == nil {
+		params := map[string]interface{}{
+			"chat_id":    chatID,
+			target.field: media.Source,
+			"caption":    caption,
+			"parse_mode": "HTML",
+		}
+		if markup != nil {
+			params["reply_markup"] = markup
+		}
+		err := c.Call(ctx, target.method, chatID, params, &message)
+		return message, err
+	}
+
+	fields := map[string]string{
+		"chat_id":    strconv.FormatInt(chatID, 10),
+		"caption":    caption,
+		"parse_mode": "HTML",
+	}
+	if markup != nil {
+		markupJSON, err := json.Marshal(markup)
+		if err != nil {
+			return message, fmt.Errorf("failed to marshal reply markup: %w", err)
+		}
+		fields["reply_markup"] = string(markupJSON)
+	}
+	err := c.CallMultipart(ctx, target.method, chatID, fields, target.field, media.FileName, media.Data, &message)
+	return message, err
+}
+
+// EditMessage - заменяет текст сообщения (editMessageText) или подпись к вложению (editMessageCaption) и кнопки
+func (c *TelegramClient) EditMessage(ctx context.Context, chatID, messageID int64, text string, caption bool, markup *InlineKeyboardMarkup) error {
+	method, field := "editMessageText", "text"
+	if caption {
+		method, field = "editMessageCaption", "caption"
+	}
+
+	params := map[string]interface{}{
+		"chat_id":    chatID,
+		"message_id": messageID,
+		field:        text,
+		"parse_mode": "HTML",
+	}
+	if markup != nil {
+		params["reply_markup"] = markup
+	}
+	return c.Call(ctx, method, chatID, params, nil)
+}
+
+// DeleteMessage - удаляет сообщение из чата
+func (c *TelegramClient) DeleteMessage(ctx context.Context, chatID, messageID int64) error {
+	params := map[string]interface{}{
+		"chat_id":    chatID,
+		"message_id": messageID,
+	}
+	return c.Call(ctx, "deleteMessage", chatID, params, nil)
+}
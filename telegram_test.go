@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock - время для ограничителей и повторов TelegramClient: пауза не ждет, а сдвигает часы и запоминается
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	sleeps []time.Duration
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+func (f *fakeClock) clock() telegramClock {
+	return telegramClock{now: f.Now, sleep: f.Sleep}
+}
+
+func (f *fakeClock) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *fakeClock) Sleep(ctx context.Context, d time.Duration) error {
+	if d > 0 {
+		f.mu.Lock()
+		f.sleeps = append(f.sleeps, d)
+		f.now = f.now.Add(d)
+		f.mu.Unlock()
+	}
+	return ctx.Err()
+}
+
+func (f *fakeClock) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+}
+
+// Sleeps - запрошенные паузы с прошлого вызова
+func (f *fakeClock) Sleeps() []time.Duration {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	sleeps := f.sleeps
+	f.sleeps = nil
+	return sleeps
+}
+
+func TestTokenBucketGlobalLimit(t *testing.T) {
+	clk := newFakeClock()
+	bucket := newTokenBucket(telegramGlobalRate, telegramGlobalBurst, clk.Now)
+
+	// Накопленный запас уходит сразу, следующий токен - через 1/25 секунды
+	for i := 0; i < telegramGlobalBurst; i++ {
+		if wait := bucket.reserve(); wait != 0 {
+			t.Fatalf("reserve #%d within the burst waits %s", i+1, wait)
+		}
+	}
+	if wait := bucket.reserve(); wait != time.Second/telegramGlobalRate {
+		t.Errorf("first reserve after the burst waits %s, want %s", wait, time.Second/telegramGlobalRate)
+	}
+
+	// Под постоянной нагрузкой выходит не больше 25 запросов в секунду
+	clk = newFakeClock()
+	bucket = newTokenBucket(telegramGlobalRate, telegramGlobalBurst, clk.Now)
+	start := clk.Now()
+	const requests = 250
+	for i := 0; i < requests; i++ {
+		clk.Sleep(context.Background(), bucket.reserve())
+	}
+	elapsed := clk.Now().Sub(start)
+	want := time.Duration(requests-telegramGlobalBurst) * time.Second / telegramGlobalRate
+	if diff := elapsed - want; diff < -time.Millisecond || diff > time.Millisecond {
+		t.Errorf("%d requests took %s, want %s", requests, elapsed, want)
+	}
+}
+
+func TestTelegramChatLimit(t *testing.T) {
+	clk := newFakeClock()
+	client := newTelegramClientWithClock("token", "http://127.0.0.1:0", clk.clock())
+	ctx := context.Background()
+
+	steps := []struct {
+		chatID  int64
+		advance time.Duration
+		want    []time.Duration
+	}{
+		{chatID: 1, want: nil},
+		{chatID: 1, want: []time.Duration{time.Second}},
+		{chatID: 2, want: nil}, // другой чат не ждет
+		{chatID: 1, want: []time.Duration{time.Second}},
+		{chatID: 1, advance: 400 * time.Millisecond, want: []time.Duration{600 * time.Millisecond}},
+		{chatID: 2, advance: time.Second, want: nil},
+		{chatID: 0, want: nil}, // запросы без чата ограничены только глобально
+	}
+
+	for i, step := range steps {
+		clk.Advance(step.advance)
+		if err := client.throttle(ctx, step.chatID); err != nil {
+			t.Fatalf("step %d: throttle: %v", i, err)
+		}
+		if got := clk.Sleeps(); !reflect.DeepEqual(got, step.want) {
+			t.Errorf("step %d (chat %d): waited %v, want %v", i, step.chatID, got, step.want)
+		}
+	}
+}
+
+func TestTelegramIdleChatBucketsRemoved(t *testing.T) {
+	clk := newFakeClock()
+	client := newTelegramClientWithClock("token", "http://127.0.0.1:0", clk.clock())
+
+	for id := int64(1); id <= telegramChatBucketsLimit; id++ {
+		client.chatBucket(id).reserve()
+	}
+
+	// Все ограничители только что использованы - удалять нечего
+	client.chatBucket(telegramChatBucketsLimit + 1).reserve()
+	if got := len(client.chats); got != telegramChatBucketsLimit+1 {
+		t.Fatalf("chats = %d, want %d", got, telegramChatBucketsLimit+1)
+	}
+
+	// Через секунду все восстановились; чат 1 снова занят и должен остаться
+	clk.Advance(time.Second)
+	client.chatBucket(1).reserve()
+	client.chatBucket(telegramChatBucketsLimit + 2)
+
+	if got := len(client.chats); got != 2 {
+		t.Errorf("chats after cleanup = %d, want 2", got)
+	}
+	if _, ok := client.chats[1]; !ok {
+		t.Error("busy chat bucket was removed")
+	}
+}
+
+// scriptedResponse - ответ scriptedBotAPI: статус и retry_after для 429
+type scriptedResponse struct {
+	status     int
+	retryAfter int
+}
+
+// scriptedBotAPI - Bot API, отвечающий по списку; последний ответ повторяется. Возвращает и счетчик запросов
+func scriptedBotAPI(t *testing.T, responses []scriptedResponse) (*httptest.Server, *int) {
+	t.Helper()
+
+	var mu sync.Mutex
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		response := responses[min(requests, len(responses)-1)]
+		requests++
+		mu.Unlock()
+
+		if response.status != http.StatusOK {
+			writeFakeBotError(w, response.status, http.StatusText(response.status), response.retryAfter)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "result": map[string]int{"message_id": 1}})
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestTelegramCallRetries(t *testing.T) {
+	ok := scriptedResponse{status: http.StatusOK}
+	serverError := scriptedResponse{status: http.StatusInternalServerError}
+
+	tests := []struct {
+		name         string
+		responses    []scriptedResponse
+		wantRequests int
+		wantSleeps   []time.Duration
+		wantStatus   int // 0 - успех
+	}{
+		{
+			name:         "success",
+			responses:    []scriptedResponse{ok},
+			wantRequests: 1,
+		},
+		{
+			name:         "429 waits retry_after",
+			responses:    []scriptedResponse{{status: http.StatusTooManyRequests, retryAfter: 3}, ok},
+			wantRequests: 2,
+			wantSleeps:   []time.Duration{3 * time.Second},
+		},
+		{
+			name:         "5xx backs off exponentially",
+			responses:    []scriptedResponse{serverError, {status: http.StatusBadGateway}, ok},
+			wantRequests: 3,
+			wantSleeps:   []time.Duration{time.Second, 2 * time.Second},
+		},
+		{
+			name:         "gives up after max 5xx attempts",
+			responses:    []scriptedResponse{serverError},
+			wantRequests: telegramMaxRetries + 1,
+			wantSleeps:   []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 16 * time.Second},
+			wantStatus:   http.StatusInternalServerError,
+		},
+		{
+			name:         "400 is not retried",
+			responses:    []scriptedResponse{{status: http.StatusBadRequest}, ok},
+			wantRequests: 1,
+			wantStatus:   http.StatusBadRequest,
+		},
+		{
+			name:         "403 is not retried",
+			responses:    []scriptedResponse{{status: http.StatusForbidden}, ok},
+			wantRequests: 1,
+			wantStatus:   http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, requests := scriptedBotAPI(t, tt.responses)
+			clk := newFakeClock()
+			client := newTelegramClientWithClock("token", server.URL, clk.clock())
+
+			// Без chat_id, чтобы в паузах были только повторы, а не лимит на чат
+			err := client.Call(context.Background(), "getMe", 0, map[string]string{}, nil)
+
+			var tgErr *TelegramError
+			switch {
+			case tt.wantStatus == 0 && err != nil:
+				t.Fatalf("Call: %v", err)
+			case tt.wantStatus != 0 && (!errors.As(err, &tgErr) || tgErr.StatusCode != tt.wantStatus):
+				t.Fatalf("Call error = %v, want Telegram error %d", err, tt.wantStatus)
+			}
+			if *requests != tt.wantRequests {
+				t.Errorf("requests = %d, want %d", *requests, tt.wantRequests)
+			}
+			if got := clk.Sleeps(); !reflect.DeepEqual(got, tt.wantSleeps) {
+				t.Errorf("sleeps = %v, want %v", got, tt.wantSleeps)
+			}
+		})
+	}
+}
+
+// После 429 пауза действует на все запросы клиента, а не только на повтор
+func TestTelegramRetryAfterPausesAllChats(t *testing.T) {
+	clk := newFakeClock()
+	client := newTelegramClientWithClock("token", "http://127.0.0.1:0", clk.clock())
+
+	client.pauseFor(5 * time.Second)
+	if err := client.throttle(context.Background(), 7); err != nil {
+		t.Fatal(err)
+	}
+	if got := clk.Sleeps(); !reflect.DeepEqual(got, []time.Duration{5 * time.Second}) {
+		t.Errorf("throttle during the pause waited %v, want [5s]", got)
+	}
+
+	// Пауза не сокращается более коротким retry_after
+	client.pauseFor(10 * time.Second)
+	client.pauseFor(2 * time.Second)
+	if remaining := client.pauseRemaining(); remaining != 10*time.Second {
+		t.Errorf("pause remaining = %s, want 10s", remaining)
+	}
+}
+
+func TestTelegramCallCanceledDuringWait(t *testing.T) {
+	server, requests := scriptedBotAPI(t, []scriptedResponse{{status: http.StatusTooManyRequests, retryAfter: 30}})
+	clk := newFakeClock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	client := newTelegramClientWithClock("token", server.URL, telegramClock{
+		now: clk.Now,
+		sleep: func(ctx context.Context, d time.Duration) error {
+			if d > 0 {
+				cancel()
+			}
+			return clk.Sleep(ctx, d)
+		},
+	})
+
+	err := client.Call(ctx, "getMe", 0, map[string]string{}, nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Call error = %v, want context.Canceled", err)
+	}
+	if *requests != 1 {
+		t.Errorf("requests = %d, want 1", *requests)
+	}
+}
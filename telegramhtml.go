@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// telegramHTMLTags - теги, которые Telegram понимает в parse_mode HTML, и их синонимы
+var telegramHTMLTags = map[string]bool{
+	"b": true, "strong": true,
+	"i": true, "em": true,
+	"u": true, "ins": true,
+	"s": true, "strike": true, "del": true,
+	"a":          true,
+	"code":       true,
+	"pre":        true,
+	"tg-spoiler": true,
+	"span":       true, // только <span class="tg-spoiler">
+	"blockquote": true,
+	"tg-emoji":   true,
+}
+
+// telegramHTMLEntities - именованные сущности, которые поддерживает Telegram
+var telegramHTMLEntities = map[string]rune{"lt": '<', "gt": '>', "amp": '&', "quot": '"'}
+
+// htmlTag - открытый тег и его позиция в тексте для сообщения об ошибке
+type htmlTag struct {
+	name   string
+	offset int
+}
+
+// validateTelegramHTML - проверяет разметку по правилам Telegram и возвращает длину текста после разбора разметки
+// (без тегов, сущность - как символ, который она обозначает). Длина считается, как в Telegram, в кодовых
+// единицах UTF-16: эмодзи и другие символы вне BMP занимают две. Ошибка указывает строку и столбец.
+func validateTelegramHTML(text string) (int, error) {
+	var stack []htmlTag
+	length := 0
+
+	for i := 0; i < len(text); {
+		switch text[i] {
+		case '<':
+			end := strings.IndexByte(text[i:], '>')
+			if end < 0 {
+				return 0, htmlError(text, i, "unterminated tag; use &lt; for a literal '<'")
+			}
+			tag := text[i+1 : i+end]
+
+			if strings.HasPrefix(tag, "/") {
+				name := strings.ToLower(strings.TrimSpace(tag[1:]))
+				if len(stack) == 0 {
+					return 0, htmlError(text, i, fmt.Sprintf("unexpected closing tag </%s>", name))
+				}
+				if open := stack[len(stack)-1]; open.name != name {
+					return 0, htmlError(text, i, fmt.Sprintf("expected </%s> to close <%s> opened at %s, got </%s>",
+						open.name, open.name, htmlPosition(text, open.offset), name))
+				}
+				stack = stack[:len(stack)-1]
+			} else {
+				name, err := validateHTMLTag(tag, stack)
+				if err != nil {
+					return 0, htmlError(text, i, err.Error())
+				}
+				stack = append(stack, htmlTag{name: name, offset: i})
+			}
+			i += end + 1
+
+		case '&':
+			// Неизвестная сущность остается текстом, как и в Telegram
+			if n, r := htmlEntity(text[i:]); n > 0 {
+				i += n
+				length += utf16Len(r)
+			} else {
+				i++
+				length++
+			}
+
+		default:
+			r, size := utf8.DecodeRuneInString(text[i:])
+			i += size
+			length += utf16Len(r)
+		}
+	}
+
+	if len(stack) > 0 {
+		open := stack[len(stack)-1]
+		return 0, htmlError(text, open.offset, fmt.Sprintf("tag <%s> is not closed", open.name))
+	}
+	return length, nil
+}
+
+// validateHTMLTag - проверяет открывающий тег (без угловых скобок) и его вложенность, возвращает имя тега
+func validateHTMLTag(tag string, stack []htmlTag) (string, error) {
+	name, rest := tag, ""
+	if n := strings.IndexAny(tag, " \t\r\n"); n >= 0 {
+		name, rest = tag[:n], tag[n:]
+	}
+	name = strings.ToLower(name)
+	if name == "" {
+		return "", fmt.Errorf("unescaped '<'; use &lt;")
+	}
+	if !telegramHTMLTags[name] {
+		return "", fmt.Errorf("unsupported tag <%s> (supported: b, i, u, s, a, code, pre, tg-spoiler, blockquote)", name)
+	}
+
+	attrs, err := parseHTMLAttributes(rest)
+	if err != nil {
+		return "", fmt.Errorf("tag <%s>: %w", name, err)
+	}
+	switch name {
+	case "a":
+		if strings.TrimSpace(attrs["href"]) == "" {
+			return "", fmt.Errorf("tag <a> requires href")
+		}
+	case "span":
+		if attrs["class"] != "tg-spoiler" {
+			return "", fmt.Errorf(`tag <span> is supported only as <span class="tg-spoiler">`)
+		}
+	case "tg-emoji":
+		if attrs["emoji-id"] == "" {
+			return "", fmt.Errorf("tag <tg-emoji> requires emoji-id")
+		}
+	}
+
+	if len(stack) > 0 {
+		parent := stack[len(stack)-1].name
+		if parent == "code" || (parent == "pre" && name != "code") {
+			return "", fmt.Errorf("tag <%s> cannot be nested in <%s>", name, parent)
+		}
+	}
+	return name, nil
+}
+
+// parseHTMLAttributes - атрибуты тега: name="value", name='value', name=value или name
+func parseHTMLAttributes(s string) (map[string]string, error) {
+	attrs := make(map[string]string)
+	for {
+		s = strings.TrimLeft(s, " \t\r\n")
+		if s == "" {
+			return attrs, nil
+		}
+
+		n := strings.IndexAny(s, "= \t\r\n")
+		if n < 0 {
+			n = len(s)
+		}
+		name := strings.ToLower(s[:n])
+		if name == "" || strings.ContainsAny(name, `"'/<`) {
+			return nil, fmt.Errorf("invalid attribute %q", s)
+		}
+		s = s[n:]
+
+		if !strings.HasPrefix(s, "=") {
+			attrs[name] = ""
+			continue
+		}
+		s = s[1:]
+
+		var value string
+		if s != "" && (s[0] == '"' || s[0] == '\'') {
+			end := strings.IndexByte(s[1:], s[0])
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated value of attribute %s", name)
+			}
+			value, s = s[1:end+1], s[end+2:]
+		} else {
+			end := strings.IndexAny(s, " \t\r\n")
+			if end < 0 {
+				end = len(s)
+			}
+			value, s = s[:end], s[end:]
+		}
+		attrs[name] = value
+	}
+}
+
+// htmlEntity - длина сущности &lt; &#123; &#x1F600; в начале s и символ, который она обозначает; 0 - не сущность
+func htmlEntity(s string) (int, rune) {
+	end := strings.IndexByte(s, ';')
+	if end < 2 || end > 10 {
+		return 0, 0
+	}
+	name := s[1:end]
+
+	if r, ok := telegramHTMLEntities[name]; ok {
+		return end + 1, r
+	}
+	digits, base := strings.TrimPrefix(name, "#"), 10
+	if digits == name {
+		return 0, 0
+	}
+	if strings.HasPrefix(digits, "x") || strings.HasPrefix(digits, "X") {
+		digits, base = digits[1:], 16
+	}
+	code, err := strconv.ParseUint(digits, base, 32)
+	if err != nil {
+		return 0, 0
+	}
+	return end + 1, rune(code)
+}
+
+// utf16Len - сколько кодовых единиц UTF-16 занимает символ; недопустимый символ Telegram заменяет одним
+func utf16Len(r rune) int {
+	if n := utf16.RuneLen(r); n > 0 {
+		return n
+	}
+	return 1
+}
+
+// htmlPosition - строка и столбец байтового смещения
+func htmlPosition(text string, offset int) string {
+	line := strings.Count(text[:offset], "\n") + 1
+	column := utf8.RuneCountInString(text[strings.LastIndexByte(text[:offset], '\n')+1:offset]) + 1
+	return fmt.Sprintf("line %d, column %d", line, column)
+}
+
+func htmlError(text string, offset int, msg string) error {
+	return fmt.Errorf("invalid HTML at %s: %s", htmlPosition(text, offset), msg)
+}
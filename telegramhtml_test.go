@@ -0,0 +1,111 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateTelegramHTMLLength(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want int
+	}{
+		{"plain ascii", "Hello", 5},
+		{"cyrillic is one unit per letter", "Привет", 6},
+		{"emoji is two UTF-16 units", "😀", 2},
+		{"emoji with skin tone", "👍🏽", 4},
+		{"flag", "🇷🇺", 4},
+		{"tags are not counted", "<b>bold</b> <i>it</i>", 7},
+		{"link", `<a href="https://example.com/?a=1&amp;b=2">link</a>`, 4},
+		{"spoiler span", `<span class="tg-spoiler">s</span>`, 1},
+		{"code in pre", `<pre><code class="language-go">x := 1</code></pre>`, 6},
+		{"custom emoji", `<tg-emoji emoji-id="5368324170671202286">👍</tg-emoji>`, 2},
+		{"named entities", "&lt;&gt;&amp;&quot;", 4},
+		{"decimal entity", "&#65;", 1},
+		{"decimal entity outside BMP", "&#128512;", 2},
+		{"hex entity outside BMP", "&#x1F600;", 2},
+		{"hex entity upper X", "&#X41;", 1},
+		{"unknown entity stays text", "&nbsp;", 6},
+		{"lone ampersand", "a & b", 5},
+		{"entity without digits", "&#;", 3},
+		{"invalid utf-8 byte", "a\xffb", 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := validateTelegramHTML(tt.text)
+			if err != nil {
+				t.Fatalf("validateTelegramHTML(%q): %v", tt.text, err)
+			}
+			if got != tt.want {
+				t.Errorf("validateTelegramHTML(%q) = %d, want %d", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateTelegramHTMLErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		wantErr string
+	}{
+		{"unclosed tag", "<b>bold", "tag <b> is not closed"},
+		{"unclosed inner tag", "<b><i>x</b>", "expected </i> to close <i> opened at line 1, column 4, got </b>"},
+		{"misnested tags", "<b><i>x</b></i>", "expected </i> to close <i>"},
+		{"unexpected closing tag", "text</b>", "unexpected closing tag </b>"},
+		{"unknown tag", "<div>x</div>", "unsupported tag <div>"},
+		{"unknown tag br", "line<br>", "unsupported tag <br>"},
+		{"link without href", "<a>x</a>", "tag <a> requires href"},
+		{"span without spoiler class", `<span class="red">x</span>`, `tag <span> is supported only as <span class="tg-spoiler">`},
+		{"custom emoji without id", "<tg-emoji>👍</tg-emoji>", "tag <tg-emoji> requires emoji-id"},
+		{"tag inside code", "<code><b>x</b></code>", "tag <b> cannot be nested in <code>"},
+		{"tag inside pre", "<pre><b>x</b></pre>", "tag <b> cannot be nested in <pre>"},
+		{"literal less-than", "1 < 2", "unterminated tag"},
+		{"empty tag", "<>", "unescaped '<'"},
+		{"unterminated attribute", `<a href="x>y</a>`, "unterminated value of attribute href"},
+		{"position on a later line", "ab\ncd<b>", "line 2, column 3"},
+		{"position counts runes", "Привет <b>", "line 1, column 8"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := validateTelegramHTML(tt.text)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("validateTelegramHTML(%q) error = %v, want %q", tt.text, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// Лимит Telegram считается в UTF-16: 2048 эмодзи - ровно 4096 единиц, хотя символов вдвое меньше
+func TestValidateMessageTextLimit(t *testing.T) {
+	emoji := strings.Repeat("😀", telegramMaxMessageLength/2)
+
+	tests := []struct {
+		name    string
+		text    string
+		limit   int
+		wantErr bool
+	}{
+		{"emoji exactly at the limit", emoji, telegramMaxMessageLength, false},
+		{"emoji at the limit with markup", "<b>" + emoji + "</b>", telegramMaxMessageLength, false},
+		{"one unit over with emoji", emoji + "a", telegramMaxMessageLength, true},
+		{"emoji crossing the limit", strings.Repeat("a", telegramMaxMessageLength-1) + "😀", telegramMaxMessageLength, true},
+		{"ascii exactly at the limit", strings.Repeat("a", telegramMaxMessageLength), telegramMaxMessageLength, false},
+		{"entities at the caption limit", strings.Repeat("&amp;", telegramMaxCaptionLength), telegramMaxCaptionLength, false},
+		{"entity emoji over the caption limit", strings.Repeat("&#x1F600;", telegramMaxCaptionLength/2) + "a", telegramMaxCaptionLength, true},
+		{"only markup", "<b></b>", telegramMaxMessageLength, true},
+		{"empty text", "", telegramMaxMessageLength, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateMessageText(tt.text, tt.limit)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateMessageText error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret - ключ из тестовых векторов RFC 4226 и RFC 6238 ("12345678901234567890") в base32
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestHOTPRFC4226(t *testing.T) {
+	// RFC 4226, Appendix D
+	want := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+
+	for counter, code := range want {
+		if got := hotp([]byte("12345678901234567890"), uint64(counter)); got != code {
+			t.Errorf("hotp(counter=%d) = %s, want %s", counter, got, code)
+		}
+	}
+}
+
+func TestTOTPRFC6238(t *testing.T) {
+	// RFC 6238, Appendix B, SHA-1. В RFC коды восьмизначные, у нас шесть цифр - последние шесть из них
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},          // 94287082
+		{1111111109, "081804"},  // 07081804
+		{1111111111, "050471"},  // 14050471
+		{1234567890, "005924"},  // 89005924
+		{2000000000, "279037"},  // 69279037
+		{20000000000, "353130"}, // 65353130
+	}
+
+	for _, tt := range tests {
+		got, err := totpCode(rfcSecret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatalf("totpCode(%d): %v", tt.unix, err)
+		}
+		if got != tt.code {
+			t.Errorf("totpCode(%d) = %s, want %s", tt.unix, got, tt.code)
+		}
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	current := totpStep(now)
+
+	codeAt := func(step int64) string {
+		code, err := totpCode(rfcSecret, time.Unix(step*totpPeriod, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+
+	tests := []struct {
+		name     string
+		code     string
+		lastStep int64
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current step", codeAt(current), 0, current, true},
+		{"previous step", codeAt(current - 1), 0, current - 1, true},
+		{"next step", codeAt(current + 1), 0, current + 1, true},
+		{"two steps behind", codeAt(current - 2), 0, 0, false},
+		{"two steps ahead", codeAt(current + 2), 0, 0, false},
+		{"spaces are ignored", " " + codeAt(current)[:3] + " " + codeAt(current)[3:] + " ", 0, current, true},
+		{"wrong length", codeAt(current)[:5], 0, 0, false},
+		{"wrong code", "000000", 0, 0, false},
+
+		// Повторное использование: окна не новее lastStep не принимаются
+		{"reuse of the last step", codeAt(current), current, 0, false},
+		{"step below the last step", codeAt(current - 1), current, 0, false},
+		{"step after the last step", codeAt(current + 1), current, current + 1, true},
+		{"last step in the past", codeAt(current), current - 1, current, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := verifyTOTP(rfcSecret, tt.code, now, tt.lastStep)
+			if ok != tt.wantOK || step != tt.wantStep {
+				t.Errorf("verifyTOTP(%q, lastStep=%d) = (%d, %v), want (%d, %v)", tt.code, tt.lastStep, step, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestVerifyTOTPInvalidSecret(t *testing.T) {
+	if _, ok := verifyTOTP("not base32!", "123456", time.Now(), 0); ok {
+		t.Error("verifyTOTP accepted a code for an invalid secret")
+	}
+}
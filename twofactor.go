@@ -0,0 +1,585 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"log"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+const (
+	pendingLoginTTL         = 5 * time.Minute
+	pendingLoginMaxAttempts = 5
+)
+
+var (
+	errInvalidTOTPCode = errors.New("неверный код подтверждения")
+	errTwoFactorLocked = errors.New("слишком много неверных кодов")
+)
+
+type TwoFactorStatusResponse struct {
+	Success           bool   `json:"success"`
+	Enabled           bool   `json:"enabled"`
+	Enforced          bool   `json:"enforced"`
+	RecoveryCodesLeft int    `json:"recovery_codes_left"`
+	Error             string `json:"error,omitempty"`
+}
+
+type TwoFactorSetupResponse struct {
+	Success bool   `json:"success"`
+	Secret  string `json:"secret,omitempty"`
+	URI     string `json:"uri,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code"`
+}
+
+type RecoveryCodesResponse struct {
+	Success       bool     `json:"success"`
+	Message       string   `json:"message,omitempty"`
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
+	Error         string   `json:"error,omitempty"`
+}
+
+// pendingLogin - администратор, прошедший проверку пароля и ожидающий ввода кода
+type pendingLogin struct {
+	adminID   int64
+	username  string
+	expiresAt time.Time
+	attempts  int
+}
+
+// pendingLoginStore - незавершенные входы; живут несколько минут, поэтому хранятся в памяти
+type pendingLoginStore struct {
+	mu    sync.Mutex
+	items map[string]*pendingLogin
+}
+
+func newPendingLoginStore() *pendingLoginStore {
+	return &pendingLoginStore{items: make(map[string]*pendingLogin)}
+}
+
+// add - регистрирует вход, ожидающий второго фактора, и возвращает его токен
+func (p *pendingLoginStore) add(adminID int64, username string) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	for token, item := range p.items {
+		if now.After(item.expiresAt) {
+			delete(p.items, token)
+		}
+	}
+
+	token := generateRandomString(32)
+	p.items[token] = &pendingLogin{adminID: adminID, username: username, expiresAt: now.Add(pendingLoginTTL)}
+	return token
+}
+
+// attempt - засчитывает попытку ввода кода; после исчерпания попыток вход придется начать заново
+func (p *pendingLoginStore) attempt(token string) (pendingLogin, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	item, ok := p.items[token]
+	if !ok || time.Now().After(item.expiresAt) {
+		delete(p.items, token)
+		return pendingLogin{}, false
+	}
+
+	item.attempts++
+	if item.attempts > pendingLoginMaxAttempts {
+		delete(p.items, token)
+		return pendingLogin{}, false
+	}
+
+	return *item, true
+}
+
+// remove - завершает ожидающий вход
+func (p *pendingLoginStore) remove(token string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.items, token)
+}
+
+// twoFactorEnforced - обязательна ли 2FA для всех администраторов (REQUIRE_2FA=true)
+func twoFactorEnforced() bool {
+	value := getEnv("REQUIRE_2FA", "false")
+	return value == "true" || value == "1"
+}
+
+// showTOTPForm - второй шаг входа: ввод кода из приложения или кода восстановления
+func (s *Server) showTOTPForm(w http.ResponseWriter, token, errorMsg string) {
+	renderAuthPage(w, errorMsg, `<form method="POST" action="/login">
+            <input type="hidden" name="step" value="totp">
+            <input type="hidden" name="token" value="`+template.HTMLEscapeString(token)+`">
+            <div class="form-group">
+                <label for="code">Код из приложения-аутентификатора:</label>
+                <input type="text" id="code" name="code" inputmode="numeric" autocomplete="one-time-code" autofocus required>
+            </div>
+            <button type="submit" class="btn">Подтвердить</button>
+        </form>
+        <div class="info">
+            <p>Нет доступа к приложению? Введите один из кодов восстановления.</p>
+        </div>`)
+}
+
+// loginTOTPStep - проверка второго фактора при входе
+func (s *Server) loginTOTPStep(w http.ResponseWriter, r *http.Request) {
+	token := r.FormValue("token")
+	ip := clientIP(r)
+
+	pending, ok := s.pendingLogins.attempt(token)
+	if !ok {
+		s.showLoginForm(w, "Время подтверждения истекло, войдите заново")
+		return
+	}
+
+	if !s.checkLoginAllowed(w, r, ip, pending.username) {
+		s.pendingLogins.remove(token)
+		return
+	}
+
+	err := s.verifySecondFactor(r.Context(), pending.adminID, r.FormValue("code"))
+	if errors.Is(err, errInvalidTOTPCode) {
+		s.recordLoginFailure(r.Context(), ip, pending.username, "bad_totp")
+		s.showTOTPForm(w, token, "Неверный код")
+		return
+	}
+	if err != nil {
+		slog.Error("Failed to verify second factor", "admin_id", pending.adminID, "error", err)
+		s.showLoginForm(w, "Ошибка сервера, попробуйте позже")
+		return
+	}
+
+	s.pendingLogins.remove(token)
+
+	admin, err := getAdminByID(r.Context(), s.db, pending.adminID)
+	if err != nil {
+		slog.Error("Failed to load admin after second factor", "admin_id", pending.adminID, "error", err)
+		s.showLoginForm(w, "Ошибка сервера, попробуйте позже")
+		return
+	}
+
+	s.completeLogin(w, r, admin)
+}
+
+// getAdminByID - ищет администратора по ID
+func getAdminByID(ctx context.Context, db *pgxpool.Pool, id int64) (*Admin, error) {
+	var username string
+	err := db.QueryRow(ctx, `SELECT username FROM admin_user WHERE id = $1`, id).Scan(&username)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errAdminNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query admin: %w", err)
+	}
+	return getAdminByUsername(ctx, db, username)
+}
+
+// loadTOTPState - секрет, признак включения и последнее использованное окно
+func (s *Server) loadTOTPState(ctx context.Context, adminID int64) (secret *string, enabled bool, lastStep int64, err error) {
+	query := `SELECT totp_secret, totp_enabled_at IS NOT NULL, totp_last_step
+			  FROM admin_user
+			  WHERE id = $1`
+
+	err = s.db.QueryRow(ctx, query, adminID).Scan(&secret, &enabled, &lastStep)
+	if err != nil {
+		return nil, false, 0, fmt.Errorf("failed to query TOTP state: %w", err)
+	}
+	return secret, enabled, lastStep, nil
+}
+
+// consumeTOTPStep - атомарно запоминает использованное окно; повторный код того же окна не пройдет
+func (s *Server) consumeTOTPStep(ctx context.Context, adminID, step int64) (bool, error) {
+	tag, err := s.db.Exec(ctx, `UPDATE admin_user SET totp_last_step = $2 WHERE id = $1 AND totp_last_step < $2`, adminID, step)
+	if err != nil {
+		return false, fmt.Errorf("failed to store TOTP step: %w", err)
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// verifySecondFactor - проверяет TOTP код или одноразовый код восстановления
+func (s *Server) verifySecondFactor(ctx context.Context, adminID int64, code string) error {
+	secret, enabled, lastStep, err := s.loadTOTPState(ctx, adminID)
+	if err != nil {
+		return err
+	}
+	if !enabled || secret == nil {
+		return errInvalidTOTPCode
+	}
+
+	if step, ok := verifyTOTP(*secret, code, time.Now(), lastStep); ok {
+		consumed, err := s.consumeTOTPStep(ctx, adminID, step)
+		if err != nil {
+			return err
+		}
+		if !consumed {
+			return errInvalidTOTPCode
+		}
+		return nil
+	}
+
+	query := `UPDATE admin_recovery_code SET used_at = now()
+			  WHERE admin_id = $1 AND code_hash = $2 AND used_at IS NULL`
+
+	tag, err := s.db.Exec(ctx, query, adminID, hashRecoveryCode(code))
+	if err != nil {
+		return fmt.Errorf("failed to use recovery code: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return errInvalidTOTPCode
+	}
+
+	log.Printf("🆘 Администратор #%d использовал код восстановления", adminID)
+	return nil
+}
+
+// checkSecondFactor - проверка кода перед изменением 2FA. Неверные коды идут в тот же счетчик, что и вход
+// (IP и логин), поэтому после нескольких ошибок блокируются и вход, и отключение 2FA, и перевыпуск кодов
+func (s *Server) checkSecondFactor(r *http.Request, session *Session, code string) error {
+	ctx := r.Context()
+	ip := clientIP(r)
+
+	blockedFor, err := s.loginBlockedFor(ctx, ip, session.Username)
+	if err != nil {
+		return err
+	}
+	if blockedFor > 0 {
+		s.logLoginFailure(ctx, ip, session.Username, "blocked")
+		return fmt.Errorf("%w, повторите через %s", errTwoFactorLocked, blockedFor.Round(time.Second))
+	}
+
+	err = s.verifySecondFactor(ctx, session.AdminID, code)
+	if errors.Is(err, errInvalidTOTPCode) {
+		s.recordLoginFailure(ctx, ip, session.Username, "bad_totp")
+	}
+	return err
+}
+
+// replaceRecoveryCodes - выпускает новые коды восстановления взамен старых
+func replaceRecoveryCodes(ctx context.Context, tx pgx.Tx, adminID int64) ([]string, error) {
+	codes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM admin_recovery_code WHERE admin_id = $1`, adminID); err != nil {
+		return nil, fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+
+	for _, code := range codes {
+		_, err := tx.Exec(ctx, `INSERT INTO admin_recovery_code (admin_id, code_hash) VALUES ($1, $2)`, adminID, hashRecoveryCode(code))
+		if err != nil {
+			return nil, fmt.Errorf("failed to insert recovery code: %w", err)
+		}
+	}
+
+	return codes, nil
+}
+
+// regenerateRecoveryCodes - replaceRecoveryCodes в отдельной транзакции
+func (s *Server) regenerateRecoveryCodes(ctx context.Context, adminID int64) ([]string, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	codes, err := replaceRecoveryCodes(ctx, tx, adminID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit recovery codes: %w", err)
+	}
+
+	return codes, nil
+}
+
+// disableTOTP - отключает 2FA и удаляет коды восстановления
+func disableTOTP(ctx context.Context, db *pgxpool.Pool, adminID int64) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	query := `UPDATE admin_user
+			  SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = 0
+			  WHERE id = $1`
+
+	if _, err := tx.Exec(ctx, query, adminID); err != nil {
+		return fmt.Errorf("failed to disable TOTP: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM admin_recovery_code WHERE admin_id = $1`, adminID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+
+	return tx.Commit(ctx)
+}
+
+// twoFactorStatusHandler - состояние 2FA текущего администратора
+func (s *Server) twoFactorStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session := sessionFromContext(r.Context())
+
+	response := TwoFactorStatusResponse{
+		Success:  true,
+		Enabled:  session.TOTPEnabled,
+		Enforced: twoFactorEnforced(),
+	}
+
+	err := s.db.QueryRow(r.Context(),
+		`SELECT COUNT(*) FROM admin_recovery_code WHERE admin_id = $1 AND used_at IS NULL`,
+		session.AdminID,
+	).Scan(&response.RecoveryCodesLeft)
+	if err != nil {
+		slog.Error("Failed to count recovery codes", "admin_id", session.AdminID, "error", err)
+		response.Success = false
+		response.Error = err.Error()
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// twoFactorSetupHandler - выпускает новый секрет; 2FA включится только после подтверждения кодом
+func (s *Server) twoFactorSetupHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session := sessionFromContext(r.Context())
+	if session.TOTPEnabled {
+		writeJSONError(w, http.StatusConflict, "Двухфакторная аутентификация уже включена")
+		return
+	}
+
+	secret, err := generateTOTPSecret()
+	if err == nil {
+		_, err = s.db.Exec(r.Context(),
+			`UPDATE admin_user SET totp_secret = $2, totp_last_step = 0 WHERE id = $1 AND totp_enabled_at IS NULL`,
+			session.AdminID, secret,
+		)
+	}
+
+	response := TwoFactorSetupResponse{
+		Success: err == nil,
+	}
+
+	if err != nil {
+		slog.Error("Failed to set up TOTP", "admin_id", session.AdminID, "error", err)
+		response.Error = err.Error()
+	} else {
+		response.Secret = secret
+		response.URI = totpProvisioningURI(session.Username, secret)
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// twoFactorEnableHandler - подтверждает секрет первым кодом, включает 2FA и выдает коды восстановления
+func (s *Server) twoFactorEnableHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req TwoFactorCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	session := sessionFromContext(r.Context())
+	codes, err := s.enableTOTP(r.Context(), session.AdminID, req.Code)
+
+	response := RecoveryCodesResponse{
+		Success:       err == nil,
+		RecoveryCodes: codes,
+	}
+
+	if err != nil {
+		if !errors.Is(err, errInvalidTOTPCode) {
+			slog.Error("Failed to enable TOTP", "admin_id", session.AdminID, "error", err)
+		}
+		response.Error = err.Error()
+	} else {
+		log.Printf("🛡️ Администратор %s включил двухфакторную аутентификацию", session.Username)
+		response.Message = "Двухфакторная аутентификация включена"
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// enableTOTP - проверяет код по ожидающему секрету и включает 2FA
+func (s *Server) enableTOTP(ctx context.Context, adminID int64, code string) ([]string, error) {
+	secret, enabled, lastStep, err := s.loadTOTPState(ctx, adminID)
+	if err != nil {
+		return nil, err
+	}
+	if enabled {
+		return nil, errors.New("двухфакторная аутентификация уже включена")
+	}
+	if secret == nil {
+		return nil, errors.New("сначала получите секрет для приложения")
+	}
+
+	step, ok := verifyTOTP(*secret, code, time.Now(), lastStep)
+	if !ok {
+		return nil, errInvalidTOTPCode
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx,
+		`UPDATE admin_user SET totp_enabled_at = now(), totp_last_step = $2 WHERE id = $1`,
+		adminID, step,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to enable TOTP: %w", err)
+	}
+
+	codes, err := replaceRecoveryCodes(ctx, tx, adminID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit TOTP enable: %w", err)
+	}
+
+	return codes, nil
+}
+
+// twoFactorDisableHandler - отключение 2FA (требует действующий код)
+func (s *Server) twoFactorDisableHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if twoFactorEnforced() {
+		writeJSONError(w, http.StatusForbidden, "Двухфакторная аутентификация обязательна и не может быть отключена")
+		return
+	}
+
+	var req TwoFactorCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	session := sessionFromContext(r.Context())
+	err := s.checkSecondFactor(r, session, req.Code)
+	if errors.Is(err, errTwoFactorLocked) {
+		writeJSONError(w, http.StatusTooManyRequests, err.Error())
+		return
+	}
+	if err == nil {
+		err = disableTOTP(r.Context(), s.db, session.AdminID)
+	}
+
+	response := map[string]interface{}{
+		"success": err == nil,
+	}
+
+	if err != nil {
+		if !errors.Is(err, errInvalidTOTPCode) {
+			slog.Error("Failed to disable TOTP", "admin_id", session.AdminID, "error", err)
+		}
+		response["error"] = err.Error()
+	} else {
+		log.Printf("⚠️ Администратор %s отключил двухфакторную аутентификацию", session.Username)
+		response["message"] = "Двухфакторная аутентификация отключена"
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// twoFactorRecoveryCodesHandler - перевыпуск кодов восстановления (требует действующий код)
+func (s *Server) twoFactorRecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req TwoFactorCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	session := sessionFromContext(r.Context())
+
+	var codes []string
+	err := s.checkSecondFactor(r, session, req.Code)
+	if errors.Is(err, errTwoFactorLocked) {
+		writeJSONError(w, http.StatusTooManyRequests, err.Error())
+		return
+	}
+	if err == nil {
+		codes, err = s.regenerateRecoveryCodes(r.Context(), session.AdminID)
+	}
+
+	response := RecoveryCodesResponse{
+		Success: err == nil,
+	}
+
+	if err != nil {
+		if !errors.Is(err, errInvalidTOTPCode) {
+			slog.Error("Failed to regenerate recovery codes", "admin_id", session.AdminID, "error", err)
+		}
+		response.Error = err.Error()
+	} else {
+		response.Message = "Выпущены новые коды восстановления, старые больше не действуют"
+		response.RecoveryCodes = codes
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// runResetTwoFactorCommand - сброс 2FA потерявшему телефон: reset-2fa -username <логин>
+func runResetTwoFactorCommand(ctx context.Context, db *pgxpool.Pool, args []string) error {
+	fs := flag.NewFlagSet("reset-2fa", flag.ContinueOnError)
+	username := fs.String("username", "", "логин администратора")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	admin, err := getAdminByUsername(ctx, db, *username)
+	if err != nil {
+		return err
+	}
+
+	if err := disableTOTP(ctx, db, admin.ID); err != nil {
+		return err
+	}
+
+	fmt.Printf("✅ Двухфакторная аутентификация администратора %s сброшена\n", admin.Username)
+	return nil
+}
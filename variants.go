@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+const broadcastMaxVariants = 20
+
+// normalizeVariants - проверяет тексты по языкам. Основной текст message становится вариантом
+// fallback языка, если такого варианта нет, а в message всегда попадает текст fallback языка.
+func (c *BroadcastContent) normalizeVariants() error {
+	if len(c.Messages) == 0 {
+		c.FallbackLanguage = ""
+		return nil
+	}
+
+	if len(c.Messages) > broadcastMaxVariants {
+		return fmt.Errorf("too many language variants (max %d)", broadcastMaxVariants)
+	}
+
+	messages := make(map[string]string, len(c.Messages))
+	for language, message := range c.Messages {
+		language = strings.TrimSpace(language)
+		if !languageCodePattern.MatchString(language) {
+			return fmt.Errorf("invalid language code %q", language)
+		}
+		if strings.TrimSpace(message) == "" {
+			continue
+		}
+		if _, ok := messages[language]; ok {
+			return fmt.Errorf("duplicate language variant %q", language)
+		}
+		messages[language] = message
+	}
+
+	c.FallbackLanguage = strings.TrimSpace(c.FallbackLanguage)
+	if c.FallbackLanguage == "" {
+		return fmt.Errorf("fallback_language is required with language variants")
+	}
+	if _, ok := messages[c.FallbackLanguage]; !ok {
+		if strings.TrimSpace(c.Message) == "" {
+			return fmt.Errorf("no message for fallback language %q", c.FallbackLanguage)
+		}
+		messages[c.FallbackLanguage] = c.Message
+	}
+
+	c.Messages = messages
+	c.Message = messages[c.FallbackLanguage]
+	return nil
+}
+
+// messageFor - текст для языка получателя: точное совпадение, затем основной язык (en для en-US),
+// иначе текст fallback языка
+func (c *broadcastContent) messageFor(language string) string {
+	if len(c.Messages) == 0 {
+		return c.Message
+	}
+	if message, ok := c.Messages[language]; ok {
+		return message
+	}
+	if base, _, found := strings.Cut(language, "-"); found {
+		if message, ok := c.Messages[base]; ok {
+			return message
+		}
+	}
+	return c.Message
+}
+
+// countCustomersByLanguage - число клиентов под фильтром по языкам
+func (s *Server) countCustomersByLanguage(ctx context.Context, filter CustomerFilter) (map[string]int64, error) {
+	rows, err := s.db.Query(ctx,
+		`SELECT language, COUNT(*) FROM customer WHERE `+customerFilterWhere+` GROUP BY language`,
+		filter.args()...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count customers by language: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int64)
+	for rows.Next() {
+		var language string
+		var count int64
+		if err := rows.Scan(&language, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan language count: %w", err)
+		}
+		counts[language] = count
+	}
+
+	return counts, rows.Err()
+}
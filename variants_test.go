@@ -0,0 +1,158 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeVariants(t *testing.T) {
+	tests := []struct {
+		name         string
+		content      BroadcastContent
+		wantErr      bool
+		wantMessage  string
+		wantMessages map[string]string
+		wantFallback string
+	}{
+		{
+			name:        "no variants",
+			content:     BroadcastContent{Message: "Hello", FallbackLanguage: "en"},
+			wantMessage: "Hello",
+		},
+		{
+			name:         "fallback variant becomes the message",
+			content:      BroadcastContent{Message: "ignored", Messages: map[string]string{"en": "Hello", "ru": "Привет"}, FallbackLanguage: "en"},
+			wantMessage:  "Hello",
+			wantMessages: map[string]string{"en": "Hello", "ru": "Привет"},
+			wantFallback: "en",
+		},
+		{
+			name:         "message fills the missing fallback variant",
+			content:      BroadcastContent{Message: "Hello", Messages: map[string]string{"ru": "Привет"}, FallbackLanguage: "en"},
+			wantMessage:  "Hello",
+			wantMessages: map[string]string{"en": "Hello", "ru": "Привет"},
+			wantFallback: "en",
+		},
+		{
+			name:         "blank variants are dropped",
+			content:      BroadcastContent{Messages: map[string]string{"en": "Hello", "ru": "  \n"}, FallbackLanguage: "en"},
+			wantMessage:  "Hello",
+			wantMessages: map[string]string{"en": "Hello"},
+			wantFallback: "en",
+		},
+		{
+			name:         "language codes and fallback are trimmed",
+			content:      BroadcastContent{Messages: map[string]string{" pt-BR ": "Olá", "en": "Hello"}, FallbackLanguage: " en "},
+			wantMessage:  "Hello",
+			wantMessages: map[string]string{"en": "Hello", "pt-BR": "Olá"},
+			wantFallback: "en",
+		},
+		{
+			name:    "fallback language is required",
+			content: BroadcastContent{Message: "Hello", Messages: map[string]string{"ru": "Привет"}},
+			wantErr: true,
+		},
+		{
+			name:    "no text for the fallback language",
+			content: BroadcastContent{Messages: map[string]string{"ru": "Привет"}, FallbackLanguage: "en"},
+			wantErr: true,
+		},
+		{
+			name:    "blank fallback variant and blank message",
+			content: BroadcastContent{Message: " ", Messages: map[string]string{"en": " "}, FallbackLanguage: "en"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid language code",
+			content: BroadcastContent{Messages: map[string]string{"English": "Hello"}, FallbackLanguage: "English"},
+			wantErr: true,
+		},
+		{
+			name:    "duplicate after trimming",
+			content: BroadcastContent{Messages: map[string]string{"en": "Hello", " en": "Hi"}, FallbackLanguage: "en"},
+			wantErr: true,
+		},
+		{
+			name:    "too many variants",
+			content: BroadcastContent{Messages: manyVariants(broadcastMaxVariants + 1), FallbackLanguage: "aa"},
+			wantErr: true,
+		},
+		{
+			name:         "exactly the maximum of variants",
+			content:      BroadcastContent{Messages: manyVariants(broadcastMaxVariants), FallbackLanguage: "aa"},
+			wantMessage:  "text",
+			wantMessages: manyVariants(broadcastMaxVariants),
+			wantFallback: "aa",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := tt.content
+			err := content.normalizeVariants()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("normalizeVariants() = nil, want an error (content %+v)", content)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("normalizeVariants(): %v", err)
+			}
+			if content.Message != tt.wantMessage {
+				t.Errorf("Message = %q, want %q", content.Message, tt.wantMessage)
+			}
+			if len(content.Messages) != 0 || len(tt.wantMessages) != 0 {
+				if !reflect.DeepEqual(content.Messages, tt.wantMessages) {
+					t.Errorf("Messages = %v, want %v", content.Messages, tt.wantMessages)
+				}
+			}
+			if content.FallbackLanguage != tt.wantFallback {
+				t.Errorf("FallbackLanguage = %q, want %q", content.FallbackLanguage, tt.wantFallback)
+			}
+		})
+	}
+}
+
+// manyVariants - n вариантов с кодами aa, ab, ac...
+func manyVariants(n int) map[string]string {
+	messages := make(map[string]string, n)
+	for i := 0; i < n; i++ {
+		messages[string([]byte{'a' + byte(i/26), 'a' + byte(i%26)})] = "text"
+	}
+	return messages
+}
+
+func TestMessageFor(t *testing.T) {
+	content := &broadcastContent{BroadcastContent: BroadcastContent{
+		Message:          "Hello",
+		Messages:         map[string]string{"en": "Hello", "ru": "Привет", "pt": "Olá", "pt-BR": "Oi"},
+		FallbackLanguage: "en",
+	}}
+
+	tests := []struct {
+		language string
+		want     string
+	}{
+		{"ru", "Привет"},
+		{"en", "Hello"},
+		{"pt-BR", "Oi"},     // точное совпадение важнее основного языка
+		{"pt-PT", "Olá"},    // основной язык
+		{"ru-RU", "Привет"}, // основной язык
+		{"de", "Hello"},     // нет варианта - fallback
+		{"de-AT", "Hello"},  // нет ни точного, ни основного
+		{"", "Hello"},       // язык клиента не задан
+		{"RU", "Hello"},     // коды сравниваются как есть
+	}
+
+	for _, tt := range tests {
+		if got := content.messageFor(tt.language); got != tt.want {
+			t.Errorf("messageFor(%q) = %q, want %q", tt.language, got, tt.want)
+		}
+	}
+
+	plain := &broadcastContent{BroadcastContent: BroadcastContent{Message: "Only text"}}
+	if got := plain.messageFor("ru"); got != "Only text" {
+		t.Errorf("messageFor without variants = %q, want the message", got)
+	}
+}